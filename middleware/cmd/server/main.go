@@ -10,11 +10,12 @@ import (
 
 	"github.com/clayworks/middleware/internal/config"
 	"github.com/clayworks/middleware/internal/handlers"
+	"github.com/clayworks/middleware/internal/metrics"
 	"github.com/clayworks/middleware/internal/middleware"
 	"github.com/clayworks/middleware/internal/services"
+	"github.com/clayworks/middleware/internal/tracing"
 	"github.com/go-chi/chi/v5"
 	chiMiddleware "github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
 	"github.com/go-chi/httprate"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -35,66 +36,286 @@ func main() {
 		Str("port", cfg.Port).
 		Msg("Starting ClayWorks API Gateway")
 
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(context.Background(), cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+
 	// Initialize services
 	cacheService := services.NewCacheService(cfg)
 	strapiService := services.NewStrapiService(cfg, cacheService)
 	analyticsService := services.NewAnalyticsService(cfg)
+	crowdSecBouncer := services.NewCrowdSecBouncer(cfg)
+	locationIndex := services.NewLocationIndex(cfg, strapiService)
+	mailer := services.NewMailer(cfg)
+	formService, err := services.NewFormService(cfg, strapiService, mailer)
+	if err != nil {
+		log.Fatal().Err(err).Str("file", cfg.FormsFile).Msg("Failed to load form schemas")
+	}
+	tourService, err := services.NewTourService(cfg, strapiService, cacheService, mailer)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid tour schedule")
+	}
+	applicationService, err := services.NewApplicationService(cfg, strapiService)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid job application settings")
+	}
 
 	// Initialize handlers
 	contentHandler := handlers.NewContentHandler(strapiService)
-	analyticsHandler := handlers.NewAnalyticsHandler(analyticsService)
+	analyticsHandler := handlers.NewAnalyticsHandler(analyticsService, handlers.IngestLimits{
+		MaxBodyBytes:          cfg.AnalyticsMaxBodyBytes,
+		MaxEvents:             cfg.AnalyticsMaxBatchEvents,
+		MaxPropertyDepth:      cfg.AnalyticsMaxPropertyDepth,
+		MaxPropertyKeys:       cfg.AnalyticsMaxPropertyKeys,
+		MaxStringLength:       cfg.AnalyticsMaxStringLength,
+		DisallowUnknownFields: cfg.AnalyticsRejectUnknownFields,
+	})
+	formHandler := handlers.NewFormHandler(formService)
+	tourHandler := handlers.NewTourHandler(tourService)
+	applicationHandler := handlers.NewApplicationHandler(applicationService)
 	healthHandler := handlers.NewHealthHandler(cacheService, strapiService)
+	cacheWarmer := services.NewCacheWarmer(cfg, strapiService)
+	webhookHandler := handlers.NewWebhookHandler(strapiService, cacheWarmer, locationIndex)
+	locationHandler := handlers.NewLocationHandler(locationIndex, cfg.LocationsNearbyRadius)
+	adminHandler := handlers.NewAdminHandler(cacheService, strapiService, cacheWarmer)
+
+	metrics.RegisterGaugeFunc("analytics", "queue_depth", "Analytics events waiting for delivery.", func() float64 {
+		return float64(analyticsService.QueueDepth())
+	})
+	metrics.RegisterGaugeFunc("locations", "index_size", "Locations in the nearby search index.", func() float64 {
+		return float64(locationIndex.Size())
+	})
+	metrics.RegisterGaugeFunc("locations", "index_age_seconds", "Seconds since the nearby search index was built.", func() float64 {
+		return locationIndex.Age().Seconds()
+	})
+
+	// API keys by value, named for rate limit policies. API_KEY's public
+	// development default is dropped once real keys are configured.
+	var apiKeys []string
+	apiKeyIdentities := make(map[string]string)
+	for name, key := range cfg.APIKeys {
+		if key != "" {
+			apiKeys = append(apiKeys, key)
+			apiKeyIdentities[key] = name
+		}
+	}
+	switch {
+	case cfg.APIKey == "":
+	case cfg.APIKey == middleware.DevelopmentAPIKey && len(apiKeys) > 0:
+		log.Warn().Msg("API_KEY is left at its development default next to API_KEYS and will not be accepted")
+	default:
+		apiKeys = append(apiKeys, cfg.APIKey)
+		apiKeyIdentities[cfg.APIKey] = "default"
+	}
+
+	trustedProxies, err := middleware.ParseNetworks(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid TRUSTED_PROXIES")
+	}
+
+	// Security signals for CrowdSec
+	if cfg.CrowdSecSignalLog != "" {
+		signalFile, err := os.OpenFile(cfg.CrowdSecSignalLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			log.Fatal().Err(err).Str("file", cfg.CrowdSecSignalLog).Msg("Failed to open CrowdSec signal log")
+		}
+		defer signalFile.Close()
+		middleware.SetSignalOutput(signalFile)
+	}
+
+	// Public analytics ingest routes, with their own CORS and rate limit
+	// policies
+	analyticsIngestRoutes := []string{"/api/v1/analytics/events", "/api/v1/analytics/beacon", "/api/v1/analytics/pixel.gif"}
 
 	// Setup router
 	r := chi.NewRouter()
 
 	// Global middleware
 	r.Use(chiMiddleware.RequestID)
-	r.Use(chiMiddleware.RealIP)
+	r.Use(middleware.RealIP(trustedProxies))
+	r.Use(middleware.Tracing)
+	r.Use(middleware.Metrics)
 	r.Use(middleware.Logger)
 	r.Use(chiMiddleware.Recoverer)
 	r.Use(chiMiddleware.Timeout(30 * time.Second))
 
-	// CORS
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   cfg.AllowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-API-Key"},
-		ExposedHeaders:   []string{"X-Request-ID", "X-Cache-Status"},
-		AllowCredentials: true,
-		MaxAge:           300,
+	// Security headers
+	r.Use(middleware.SecurityHeaders(middleware.SecurityHeadersConfig{
+		HSTSMaxAge:            cfg.HSTSMaxAge,
+		HSTSIncludeSubdomains: cfg.HSTSIncludeSubdomains,
+		ContentSecurityPolicy: cfg.ContentSecurityPolicy,
+		ReferrerPolicy:        cfg.ReferrerPolicy,
+		FrameOptions:          "DENY",
 	}))
 
-	// Rate limiting
-	r.Use(httprate.LimitByIP(cfg.RateLimitRequests, cfg.RateLimitWindow))
+	// CORS: the analytics ingest routes are called from any page that embeds
+	// the tracker; everything else only from our own origins
+	rateLimitHeaders := []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After", "X-CrowdSec-Remediation"}
+	corsHandler, err := middleware.CORS(
+		middleware.CORSPolicy{
+			Name:           "analytics",
+			Routes:         analyticsIngestRoutes,
+			AllowedOrigins: cfg.AnalyticsAllowedOrigins,
+			AllowedMethods: []string{"GET", "POST", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", cfg.AnalyticsConsentHeader},
+			ExposedHeaders: append([]string{"X-Request-ID"}, rateLimitHeaders...),
+			MaxAge:         300,
+		},
+		middleware.CORSPolicy{
+			Name:             "api",
+			AllowedOrigins:   cfg.AllowedOrigins,
+			AllowedMethods:   []string{"GET", "POST", "DELETE", "OPTIONS"},
+			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-API-Key"},
+			ExposedHeaders:   append([]string{"X-Request-ID", "X-Cache-Status"}, rateLimitHeaders...),
+			AllowCredentials: cfg.CORSAllowCredentials,
+			MaxAge:           300,
+		},
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid CORS configuration")
+	}
+	r.Use(corsHandler)
+
+	// Refuse clients with a CrowdSec decision before they count against
+	// rate limits
+	if crowdSecBouncer.Enabled() {
+		r.Use(middleware.CrowdSec(crowdSecBouncer))
+	}
+
+	// Rate limiting, shared across replicas through Redis unless disabled
+	limitCounter := func(name string) httprate.LimitCounter {
+		if cfg.RateLimitStore != "redis" {
+			return nil
+		}
+		return services.NewRedisLimitCounter(cacheService, name)
+	}
+	rateLimitPolicies, err := middleware.LoadRateLimitPolicies(cfg.RateLimitPoliciesFile, []middleware.RateLimitPolicy{
+		{
+			Name:   "analytics",
+			Routes: analyticsIngestRoutes,
+			Limit:  100,
+			Window: middleware.Duration(time.Minute),
+		},
+		{
+			Name:   "forms",
+			Routes: []string{"/api/v1/forms/*"},
+			Limit:  10,
+			Window: middleware.Duration(time.Minute),
+		},
+		{
+			Name:   "tours",
+			Routes: []string{"/api/v1/tours"},
+			Limit:  10,
+			Window: middleware.Duration(time.Minute),
+		},
+		{
+			Name:   "applications",
+			Routes: []string{"/api/v1/jobs/*/applications"},
+			Limit:  5,
+			Window: middleware.Duration(10 * time.Minute),
+		},
+		{
+			Name:   "global",
+			Limit:  cfg.RateLimitRequests,
+			Window: middleware.Duration(cfg.RateLimitWindow),
+		},
+	})
+	if err != nil {
+		log.Fatal().Err(err).Str("file", cfg.RateLimitPoliciesFile).Msg("Failed to load rate limit policies")
+	}
+	r.Use(middleware.RateLimitPolicies(rateLimitPolicies, apiKeyIdentities, limitCounter))
 
 	// API key authentication for protected routes
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.APIKeyAuth(cfg.APIKey))
+		r.Use(middleware.APIKeyAuth(apiKeys...))
 
 		// Content API (proxied to Strapi)
 		r.Route("/api/v1/content", func(r chi.Router) {
 			r.Get("/{type}", contentHandler.GetCollection)
 			r.Get("/{type}/{id}", contentHandler.GetSingle)
+			r.Get("/{type}/by-slug/{slug}", contentHandler.GetBySlug)
 		})
 
 		// Page API
 		r.Get("/api/v1/pages/{slug}", contentHandler.GetPage)
 
+		// Nearest locations by coordinates
+		r.Get("/api/v1/locations/nearby", locationHandler.Nearby)
+
 		// Preview API
 		r.Get("/api/v1/preview/{type}/{id}", contentHandler.GetPreview)
+
+		// First-party analytics reports
+		r.Route("/api/v1/analytics/reports", func(r chi.Router) {
+			r.Get("/pageviews", analyticsHandler.PageViewsReport)
+			r.Get("/referrers", analyticsHandler.ReferrersReport)
+			r.Get("/events", analyticsHandler.EventsReport)
+			r.Get("/sessions", analyticsHandler.SessionsReport)
+		})
+		r.Get("/api/v1/analytics/stats", analyticsHandler.Stats)
 	})
 
-	// Analytics (separate rate limit)
+	// Analytics (separate rate limit policy)
 	r.Group(func(r chi.Router) {
-		r.Use(httprate.LimitByIP(100, time.Minute))
 		r.Post("/api/v1/analytics/events", analyticsHandler.IngestEvents)
+		r.Post("/api/v1/analytics/beacon", analyticsHandler.Beacon)
+		r.Get("/api/v1/analytics/pixel.gif", analyticsHandler.Pixel)
 	})
 
+	// Lead forms, public but limited to our own origins by the api CORS
+	// policy
+	r.Post("/api/v1/forms/{formId}", formHandler.Submit)
+	r.Get("/api/v1/forms/token", formHandler.Token)
+
+	// Tour bookings, public like the forms
+	r.Post("/api/v1/tours", tourHandler.Book)
+	r.Get("/api/v1/tours/availability", tourHandler.Availability)
+
+	// Job applications with a resume upload
+	r.Post("/api/v1/jobs/{slug}/applications", applicationHandler.Apply)
+
+	// Sales API for tour bookings, only when a sales key is configured
+	if cfg.SalesAPIKey != "" {
+		r.Route("/api/v1/sales/tours", func(r chi.Router) {
+			r.Use(middleware.APIKeyAuth(cfg.SalesAPIKey))
+			r.Get("/", tourHandler.List)
+			r.Get("/{id}", tourHandler.Get)
+			r.Post("/{id}/confirm", tourHandler.Confirm)
+			r.Post("/{id}/cancel", tourHandler.Cancel)
+		})
+	}
+
+	// Strapi webhooks for cache invalidation, only when a secret is configured
+	if cfg.StrapiWebhookSecret != "" {
+		r.With(middleware.APIKeyAuth(cfg.StrapiWebhookSecret)).
+			Post("/api/v1/webhooks/strapi", webhookHandler.Strapi)
+	}
+
+	// Admin API, only when a separate admin key is configured
+	if cfg.AdminAPIKey != "" {
+		r.Route("/api/v1/admin/cache", func(r chi.Router) {
+			r.Use(middleware.APIKeyAuth(cfg.AdminAPIKey))
+			r.Get("/keys", adminHandler.CacheKeys)
+			r.Get("/entry", adminHandler.CacheEntry)
+			r.Get("/stats", adminHandler.CacheStats)
+			r.Delete("/", adminHandler.PurgeCache)
+		})
+	}
+
 	// Health checks (no auth)
 	r.Get("/health", healthHandler.Health)
 	r.Get("/ready", healthHandler.Ready)
 
+	// Prometheus metrics, optionally protected by a bearer token
+	r.Group(func(r chi.Router) {
+		if cfg.MetricsToken != "" {
+			r.Use(middleware.APIKeyAuth(cfg.MetricsToken))
+		}
+		r.Handle("/metrics", metrics.Handler())
+	})
+
 	// Start server
 	server := &http.Server{
 		Addr:         ":" + cfg.Port,
@@ -113,12 +334,25 @@ func main() {
 
 	log.Info().Str("port", cfg.Port).Msg("Server started")
 
+	// Background work, stopped before the server shuts down
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+
+	// Warm the content cache now and after each webhook invalidation
+	go cacheWarmer.Run(backgroundCtx)
+
+	// Build the nearby search index and keep it fresh
+	go locationIndex.Run(backgroundCtx)
+
+	// Keep CrowdSec decisions in sync, or expire live-mode answers
+	go crowdSecBouncer.Run(backgroundCtx)
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	log.Info().Msg("Shutting down server...")
+	stopBackground()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -128,7 +362,14 @@ func main() {
 	}
 
 	// Close services
+	analyticsService.Close(ctx)
+	formService.Close(ctx)
+	tourService.Close(ctx)
 	cacheService.Close()
 
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to flush traces")
+	}
+
 	log.Info().Msg("Server exited")
 }
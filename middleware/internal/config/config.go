@@ -18,23 +18,177 @@ type Config struct {
 	StrapiURL   string
 	StrapiToken string
 
+	// Shared secret Strapi webhooks send as a bearer token
+	StrapiWebhookSecret string
+
+	// Per-operation budgets
+	StrapiTimeout       time.Duration
+	StrapiRequestBudget time.Duration
+	StrapiUploadTimeout time.Duration
+	RedisTimeout        time.Duration
+	HealthCheckTimeout  time.Duration
+
+	// Strapi resilience
+	StrapiMaxRetries         int
+	StrapiRetryBaseDelay     time.Duration
+	StrapiRetryMaxDelay      time.Duration
+	StrapiBreakerThreshold   int
+	StrapiBreakerOpenTimeout time.Duration
+	StrapiBreakerHalfOpenMax int
+
 	// Redis
-	RedisURL      string
-	RedisPassword string
-	CacheTTL      time.Duration
+	RedisURL         string
+	RedisPassword    string
+	CacheTTL         time.Duration
+	CacheStaleTTL    time.Duration
+	CacheNegativeTTL time.Duration
+
+	// Cache warming
+	CacheWarmRoutes      []string
+	CacheWarmConcurrency int
+	CacheWarmCrawl       bool
 
 	// API Key
 	APIKey string
+	// APIKeys are additional named keys ("nextjs:secret,..."), usable in
+	// rate limit policies
+	APIKeys map[string]string
+
+	// TrustedProxies are the networks whose X-Forwarded-For is believed,
+	// e.g. the Traefik container's network
+	TrustedProxies []string
+
+	// CrowdSec bouncer, enabled when CrowdSecLAPIURL is set
+	CrowdSecLAPIURL        string
+	CrowdSecAPIKey         string
+	CrowdSecMode           string
+	CrowdSecUpdateInterval time.Duration
+	CrowdSecCacheTTL       time.Duration
+	CrowdSecTimeout        time.Duration
+	// CrowdSecSignalLog is a file receiving auth failures and rate limit
+	// hits for CrowdSec to parse
+	CrowdSecSignalLog string
+
+	// Metrics
+	MetricsToken string
+
+	// Enables the /api/v1/admin routes when set
+	AdminAPIKey string
+
+	// Tracing
+	TracingExporter    string
+	TracingServiceName string
+	TracingSampleRatio float64
 
 	// Rate Limiting
 	RateLimitRequests int
 	RateLimitWindow   time.Duration
+	// RateLimitStore is "redis" (shared, with local fallback) or "local"
+	RateLimitStore string
+	// RateLimitPoliciesFile is a JSON policy table replacing the defaults
+	RateLimitPoliciesFile string
+
+	// CORS. AllowedOrigins apply to the content and admin APIs,
+	// AnalyticsAllowedOrigins to the public analytics ingest routes.
+	AllowedOrigins          []string
+	AnalyticsAllowedOrigins []string
+	CORSAllowCredentials    bool
+
+	// Security headers
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	ContentSecurityPolicy string
+	ReferrerPolicy        string
+
+	// Lead forms
+	FormsFile          string
+	FormsHoneypotField string
+	FormsMinFillTime   time.Duration
+	FormsMaxAge        time.Duration
+	FormsTokenSecret   string
+	FormsNotifyTimeout time.Duration
+	FormsNotifyEmails  []string
+	FormsWebhookURL    string
+	FormsWebhookSecret string
 
-	// CORS
-	AllowedOrigins []string
+	// SMTP for form notifications, disabled without SMTPHost
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// Location tour bookings
+	ToursTimezone          string
+	ToursOpeningHours      string
+	ToursSlotDuration      time.Duration
+	ToursSlotCapacity      int
+	ToursScheduleFile      string
+	ToursMinNotice         time.Duration
+	ToursMaxAdvance        time.Duration
+	ToursMaxPreferredSlots int
+	ToursPendingHold       time.Duration
+	ToursNotifyEmails      []string
+	// Key for the sales team's booking API, disabled when empty
+	SalesAPIKey string
+
+	// Job applications
+	JobsResumeMaxBytes int64
+	JobsResumeTypes    []string
+
+	// Nearby location search
+	LocationsIndexRefresh time.Duration
+	LocationsNearbyRadius float64
 
 	// Analytics (for future Google Analytics integration)
 	GoogleAnalyticsID string
+
+	// Analytics privacy
+	AnalyticsConsentRequired     bool
+	AnalyticsConsentHeader       string
+	AnalyticsConsentCookie       string
+	AnalyticsMarketingCategories []string
+	AnalyticsHonorDNT            bool
+	AnalyticsIPv4MaskBits        int
+	AnalyticsIPv6MaskBits        int
+	AnalyticsHashSecret          string
+	AnalyticsSaltRotation        time.Duration
+	AnalyticsPIIKeys             []string
+
+	// Analytics ingest limits
+	AnalyticsMaxBodyBytes        int64
+	AnalyticsMaxBatchEvents      int
+	AnalyticsMaxPropertyDepth    int
+	AnalyticsMaxPropertyKeys     int
+	AnalyticsMaxStringLength     int
+	AnalyticsRejectUnknownFields bool
+
+	// Analytics delivery
+	AnalyticsQueueSize       int
+	AnalyticsWorkers         int
+	AnalyticsProviderTimeout time.Duration
+
+	// Analytics providers
+	MixpanelToken                string
+	MixpanelAPISecret            string
+	MixpanelProjectID            string
+	MixpanelAPIURL               string
+	PostHogAPIKey                string
+	PostHogHost                  string
+	AnalyticsWebhookURL          string
+	AnalyticsWebhookSecret       string
+	AnalyticsWebhookTemplate     string
+	AnalyticsWebhookTemplateFile string
+
+	// First-party analytics storage
+	AnalyticsStoreDir     string
+	AnalyticsRawRetention time.Duration
+
+	// Analytics bot filtering
+	AnalyticsBotListFile         string
+	AnalyticsBotReloadInterval   time.Duration
+	AnalyticsBotPolicy           string
+	AnalyticsBotProviderPolicies map[string]string
 }
 
 func Load() *Config {
@@ -48,21 +202,158 @@ func Load() *Config {
 		StrapiURL:   getEnv("STRAPI_URL", "http://localhost:1337"),
 		StrapiToken: getEnv("STRAPI_API_TOKEN", ""),
 
+		StrapiWebhookSecret: getEnv("STRAPI_WEBHOOK_SECRET", ""),
+
+		StrapiTimeout:       getDuration("STRAPI_TIMEOUT", 10*time.Second),
+		StrapiRequestBudget: getDuration("STRAPI_REQUEST_BUDGET", 20*time.Second),
+		StrapiUploadTimeout: getDuration("STRAPI_UPLOAD_TIMEOUT", 30*time.Second),
+		RedisTimeout:        getDuration("REDIS_TIMEOUT", 250*time.Millisecond),
+		HealthCheckTimeout:  getDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+
+		StrapiMaxRetries:         getInt("STRAPI_MAX_RETRIES", 2),
+		StrapiRetryBaseDelay:     getDuration("STRAPI_RETRY_BASE_DELAY", 100*time.Millisecond),
+		StrapiRetryMaxDelay:      getDuration("STRAPI_RETRY_MAX_DELAY", 2*time.Second),
+		StrapiBreakerThreshold:   getInt("STRAPI_BREAKER_THRESHOLD", 5),
+		StrapiBreakerOpenTimeout: getDuration("STRAPI_BREAKER_OPEN_TIMEOUT", 30*time.Second),
+		StrapiBreakerHalfOpenMax: getInt("STRAPI_BREAKER_HALF_OPEN_PROBES", 1),
+
 		RedisURL:      getEnv("REDIS_URL", "localhost:6379"),
 		RedisPassword: getEnv("REDIS_PASSWORD", ""),
 		CacheTTL:      getDuration("CACHE_TTL", 5*time.Minute),
+		CacheStaleTTL: getDuration("CACHE_STALE_TTL", 0),
+
+		CacheNegativeTTL: getDuration("CACHE_NEGATIVE_TTL", 30*time.Second),
+
+		// Defaults match the homepage and listing queries in the Next.js app
+		CacheWarmRoutes: getSlice("CACHE_WARM_ROUTES", []string{
+			"/api/v1/content/site-setting?populate=*",
+			"/api/v1/content/hero-sections?filters[page][$eq]=home&populate=*",
+			"/api/v1/content/locations?populate=*&pagination[pageSize]=4&sort=order:asc&filters[featured][$eq]=true",
+			"/api/v1/content/testimonials?populate=*&pagination[pageSize]=10&sort=order:asc&filters[featured][$eq]=true",
+			"/api/v1/content/blog-posts?populate=*&pagination[pageSize]=4&sort=publishedAt:desc&filters[featured][$eq]=true",
+			"/api/v1/content/locations?populate=*&pagination[pageSize]=100&sort=order:asc",
+			"/api/v1/content/blog-posts?populate=*&pagination[pageSize]=20&sort=publishedAt:desc",
+		}),
+		CacheWarmConcurrency: getInt("CACHE_WARM_CONCURRENCY", 4),
+		CacheWarmCrawl:       getBool("CACHE_WARM_CRAWL", true),
+
+		APIKey:  getEnv("API_KEY", "development-api-key"),
+		APIKeys: getMap("API_KEYS"),
+
+		TrustedProxies: getSlice("TRUSTED_PROXIES", nil),
+
+		CrowdSecLAPIURL:        getEnv("CROWDSEC_LAPI_URL", ""),
+		CrowdSecAPIKey:         getEnv("CROWDSEC_API_KEY", ""),
+		CrowdSecMode:           getEnv("CROWDSEC_MODE", "stream"),
+		CrowdSecUpdateInterval: getDuration("CROWDSEC_UPDATE_INTERVAL", 10*time.Second),
+		CrowdSecCacheTTL:       getDuration("CROWDSEC_CACHE_TTL", time.Minute),
+		CrowdSecTimeout:        getDuration("CROWDSEC_TIMEOUT", 2*time.Second),
+		CrowdSecSignalLog:      getEnv("CROWDSEC_SIGNAL_LOG", ""),
+
+		MetricsToken: getEnv("METRICS_TOKEN", ""),
 
-		APIKey: getEnv("API_KEY", "development-api-key"),
+		AdminAPIKey: getEnv("ADMIN_API_KEY", ""),
+
+		TracingExporter:    getEnv("OTEL_TRACES_EXPORTER", "none"),
+		TracingServiceName: getEnv("OTEL_SERVICE_NAME", "clayworks-gateway"),
+		TracingSampleRatio: getFloat("OTEL_TRACES_SAMPLER_ARG", 1.0),
 
 		RateLimitRequests: getInt("RATE_LIMIT_REQUESTS", 100),
 		RateLimitWindow:   getDuration("RATE_LIMIT_WINDOW", time.Minute),
+		RateLimitStore:    getEnv("RATE_LIMIT_STORE", "redis"),
+
+		RateLimitPoliciesFile: getEnv("RATE_LIMIT_POLICIES_FILE", ""),
 
 		AllowedOrigins: getSlice("ALLOWED_ORIGINS", []string{
 			"http://localhost:3000",
 			"http://localhost:8080",
 		}),
+		AnalyticsAllowedOrigins: getSlice("ANALYTICS_ALLOWED_ORIGINS", []string{"*"}),
+		CORSAllowCredentials:    getBool("CORS_ALLOW_CREDENTIALS", true),
+
+		HSTSMaxAge:            getDuration("HSTS_MAX_AGE", 365*24*time.Hour),
+		HSTSIncludeSubdomains: getBool("HSTS_INCLUDE_SUBDOMAINS", true),
+		ContentSecurityPolicy: getEnv("CONTENT_SECURITY_POLICY", "default-src 'none'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'"),
+		ReferrerPolicy:        getEnv("REFERRER_POLICY", "no-referrer"),
+
+		FormsFile:          getEnv("FORMS_FILE", ""),
+		FormsHoneypotField: getEnv("FORMS_HONEYPOT_FIELD", "website"),
+		FormsMinFillTime:   getDuration("FORMS_MIN_FILL_TIME", 3*time.Second),
+		FormsMaxAge:        getDuration("FORMS_MAX_AGE", 24*time.Hour),
+		FormsTokenSecret:   getEnv("FORMS_TOKEN_SECRET", ""),
+		FormsNotifyTimeout: getDuration("FORMS_NOTIFY_TIMEOUT", 10*time.Second),
+		FormsNotifyEmails:  getSlice("FORMS_NOTIFY_EMAIL", nil),
+		FormsWebhookURL:    getEnv("FORMS_WEBHOOK_URL", ""),
+		FormsWebhookSecret: getEnv("FORMS_WEBHOOK_SECRET", ""),
+
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getInt("SMTP_PORT", 1025),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:     getEnv("SMTP_FROM", "ClayWorks <noreply@clayworks.local>"),
+
+		ToursTimezone:          getEnv("TOURS_TIMEZONE", "Asia/Kolkata"),
+		ToursOpeningHours:      getEnv("TOURS_OPENING_HOURS", "mon-sat 10:00-12:00,mon-sat 14:00-17:00"),
+		ToursSlotDuration:      getDuration("TOURS_SLOT_DURATION", 30*time.Minute),
+		ToursSlotCapacity:      getInt("TOURS_SLOT_CAPACITY", 2),
+		ToursScheduleFile:      getEnv("TOURS_SCHEDULE_FILE", ""),
+		ToursMinNotice:         getDuration("TOURS_MIN_NOTICE", 2*time.Hour),
+		ToursMaxAdvance:        getDuration("TOURS_MAX_ADVANCE", 30*24*time.Hour),
+		ToursMaxPreferredSlots: getInt("TOURS_MAX_PREFERRED_SLOTS", 3),
+		ToursPendingHold:       getDuration("TOURS_PENDING_HOLD", 48*time.Hour),
+		ToursNotifyEmails:      getSlice("TOURS_NOTIFY_EMAIL", nil),
+		SalesAPIKey:            getEnv("SALES_API_KEY", ""),
+
+		JobsResumeMaxBytes: int64(getInt("JOBS_RESUME_MAX_BYTES", 5<<20)),
+		JobsResumeTypes:    getSlice("JOBS_RESUME_TYPES", []string{"pdf", "doc", "docx"}),
+
+		LocationsIndexRefresh: getDuration("LOCATIONS_INDEX_REFRESH", 10*time.Minute),
+		LocationsNearbyRadius: getFloat("LOCATIONS_NEARBY_RADIUS_KM", 25),
 
 		GoogleAnalyticsID: getEnv("GOOGLE_ANALYTICS_ID", ""),
+
+		AnalyticsConsentRequired:     getBool("ANALYTICS_CONSENT_REQUIRED", false),
+		AnalyticsConsentHeader:       getEnv("ANALYTICS_CONSENT_HEADER", "X-Consent"),
+		AnalyticsConsentCookie:       getEnv("ANALYTICS_CONSENT_COOKIE", "cw_consent"),
+		AnalyticsMarketingCategories: getSlice("ANALYTICS_MARKETING_CATEGORIES", []string{"marketing", "advertising"}),
+		AnalyticsHonorDNT:            getBool("ANALYTICS_HONOR_DNT", true),
+		AnalyticsIPv4MaskBits:        getInt("ANALYTICS_IPV4_MASK_BITS", 24),
+		AnalyticsIPv6MaskBits:        getInt("ANALYTICS_IPV6_MASK_BITS", 48),
+		AnalyticsHashSecret:          getEnv("ANALYTICS_HASH_SECRET", ""),
+		AnalyticsSaltRotation:        getDuration("ANALYTICS_SALT_ROTATION", 24*time.Hour),
+		AnalyticsPIIKeys: getSlice("ANALYTICS_PII_KEYS", []string{
+			"email", "phone", "name", "first_name", "last_name", "address", "ip",
+		}),
+
+		AnalyticsMaxBodyBytes:        int64(getInt("ANALYTICS_MAX_BODY_BYTES", 256<<10)),
+		AnalyticsMaxBatchEvents:      getInt("ANALYTICS_MAX_BATCH_EVENTS", 100),
+		AnalyticsMaxPropertyDepth:    getInt("ANALYTICS_MAX_PROPERTY_DEPTH", 5),
+		AnalyticsMaxPropertyKeys:     getInt("ANALYTICS_MAX_PROPERTY_KEYS", 100),
+		AnalyticsMaxStringLength:     getInt("ANALYTICS_MAX_STRING_LENGTH", 2048),
+		AnalyticsRejectUnknownFields: getBool("ANALYTICS_REJECT_UNKNOWN_FIELDS", false),
+
+		AnalyticsQueueSize:       getInt("ANALYTICS_QUEUE_SIZE", 1000),
+		AnalyticsWorkers:         getInt("ANALYTICS_WORKERS", 2),
+		AnalyticsProviderTimeout: getDuration("ANALYTICS_PROVIDER_TIMEOUT", 5*time.Second),
+
+		MixpanelToken:                getEnv("MIXPANEL_TOKEN", ""),
+		MixpanelAPISecret:            getEnv("MIXPANEL_API_SECRET", ""),
+		MixpanelProjectID:            getEnv("MIXPANEL_PROJECT_ID", ""),
+		MixpanelAPIURL:               getEnv("MIXPANEL_API_URL", "https://api.mixpanel.com"),
+		PostHogAPIKey:                getEnv("POSTHOG_API_KEY", ""),
+		PostHogHost:                  getEnv("POSTHOG_HOST", "https://us.i.posthog.com"),
+		AnalyticsWebhookURL:          getEnv("ANALYTICS_WEBHOOK_URL", ""),
+		AnalyticsWebhookSecret:       getEnv("ANALYTICS_WEBHOOK_SECRET", ""),
+		AnalyticsWebhookTemplate:     getEnv("ANALYTICS_WEBHOOK_TEMPLATE", ""),
+		AnalyticsWebhookTemplateFile: getEnv("ANALYTICS_WEBHOOK_TEMPLATE_FILE", ""),
+
+		AnalyticsStoreDir:     getEnv("ANALYTICS_STORE_DIR", ""),
+		AnalyticsRawRetention: getDuration("ANALYTICS_RAW_RETENTION", 30*24*time.Hour),
+
+		AnalyticsBotListFile:         getEnv("ANALYTICS_BOT_LIST_FILE", ""),
+		AnalyticsBotReloadInterval:   getDuration("ANALYTICS_BOT_RELOAD_INTERVAL", time.Minute),
+		AnalyticsBotPolicy:           getEnv("ANALYTICS_BOT_POLICY", "drop"),
+		AnalyticsBotProviderPolicies: getMap("ANALYTICS_BOT_PROVIDER_POLICY"),
 	}
 }
 
@@ -82,6 +373,24 @@ func getInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+func getBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
 func getDuration(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if d, err := time.ParseDuration(value); err == nil {
@@ -91,6 +400,18 @@ func getDuration(key string, defaultValue time.Duration) time.Duration {
 	return defaultValue
 }
 
+// getMap parses "key:value,key:value" pairs
+func getMap(key string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range getSlice(key, nil) {
+		k, v, ok := strings.Cut(pair, ":")
+		if ok {
+			result[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return result
+}
+
 func getSlice(key string, defaultValue []string) []string {
 	if value := os.Getenv(key); value != "" {
 		return strings.Split(value, ",")
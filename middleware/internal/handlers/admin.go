@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/clayworks/middleware/internal/services"
+	"github.com/rs/zerolog/log"
+)
+
+// maxKeysLimit caps how many keys one listing returns
+const maxKeysLimit = 1000
+
+type AdminHandler struct {
+	cache  *services.CacheService
+	strapi *services.StrapiService
+	warmer *services.CacheWarmer
+}
+
+func NewAdminHandler(cache *services.CacheService, strapi *services.StrapiService, warmer *services.CacheWarmer) *AdminHandler {
+	return &AdminHandler{cache: cache, strapi: strapi, warmer: warmer}
+}
+
+// CacheKey is one entry in a key listing
+type CacheKey struct {
+	Key string `json:"key"`
+	// TTL is the remaining lifetime in seconds, -1 without expiry
+	TTL  float64 `json:"ttl"`
+	Size int64   `json:"size"`
+}
+
+type CacheKeysResponse struct {
+	Prefix string     `json:"prefix"`
+	Count  int        `json:"count"`
+	Keys   []CacheKey `json:"keys"`
+}
+
+type CacheEntryResponse struct {
+	CacheKey
+	// Negative marks a cached not-found result
+	Negative bool            `json:"negative"`
+	Value    json.RawMessage `json:"value,omitempty"`
+}
+
+type CachePurgeResponse struct {
+	Success bool `json:"success"`
+	Deleted int  `json:"deleted"`
+}
+
+func toCacheKey(info services.CacheEntryInfo) CacheKey {
+	ttl := info.TTL.Seconds()
+	if info.TTL < 0 {
+		ttl = -1
+	}
+	return CacheKey{Key: info.Key, TTL: ttl, Size: info.Size}
+}
+
+// CacheKeys lists cached keys starting with ?prefix= (default: all), up to
+// ?limit= (default 100)
+func (h *AdminHandler) CacheKeys(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+
+	limit := 100
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxKeysLimit {
+			writeError(w, r, http.StatusBadRequest, "invalid_request", "limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+
+	infos, err := h.cache.Keys(r.Context(), services.GlobPrefix(prefix), limit)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list cache keys")
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list cache keys")
+		return
+	}
+
+	keys := make([]CacheKey, len(infos))
+	for i, info := range infos {
+		keys[i] = toCacheKey(info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CacheKeysResponse{
+		Prefix: prefix,
+		Count:  len(keys),
+		Keys:   keys,
+	})
+}
+
+// CacheEntry returns the cached value of ?key=
+func (h *AdminHandler) CacheEntry(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "key is required")
+		return
+	}
+
+	info, value, found, err := h.cache.Entry(r.Context(), key)
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("Failed to read cache entry")
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read cache entry")
+		return
+	}
+	if !found {
+		writeError(w, r, http.StatusNotFound, "not_found", "Key not cached")
+		return
+	}
+
+	response := CacheEntryResponse{CacheKey: toCacheKey(info), Negative: len(value) == 0}
+	if json.Valid(value) {
+		response.Value = value
+	} else if len(value) > 0 {
+		response.Value, _ = json.Marshal(string(value))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// PurgeCache deletes cached content selected by exactly one of ?key=,
+// ?type= (optionally with &slug=) or ?all=true, then re-warms the cache
+func (h *AdminHandler) PurgeCache(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	key, contentType, slug := query.Get("key"), query.Get("type"), query.Get("slug")
+	all, _ := strconv.ParseBool(query.Get("all"))
+
+	var (
+		deleted int
+		err     error
+	)
+
+	switch {
+	case key != "" && contentType == "" && !all:
+		deleted, err = h.cache.Delete(r.Context(), key)
+	case contentType != "" && slug != "" && key == "" && !all:
+		deleted, err = h.strapi.InvalidateSlug(r.Context(), contentType, slug)
+	case contentType != "" && key == "" && !all:
+		deleted, err = h.strapi.InvalidateCache(r.Context(), contentType)
+	case all && key == "" && contentType == "":
+		deleted, err = h.strapi.InvalidateAll(r.Context())
+	default:
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Specify one of key, type (with optional slug) or all=true")
+		return
+	}
+
+	if err != nil {
+		log.Error().Err(err).Msg("Cache purge failed")
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Cache purge failed")
+		return
+	}
+
+	log.Info().
+		Str("key", key).
+		Str("type", contentType).
+		Str("slug", slug).
+		Bool("all", all).
+		Int("deleted", deleted).
+		Msg("Cache purged by admin")
+
+	if deleted > 0 {
+		h.warmer.Trigger()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CachePurgeResponse{Success: true, Deleted: deleted})
+}
+
+// CacheStats reports key counts and sizes per prefix and Redis counters
+func (h *AdminHandler) CacheStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.cache.Stats(r.Context(), services.ContentCachePatterns())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read cache stats")
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read cache stats")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -4,16 +4,18 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/clayworks/middleware/internal/middleware"
 	"github.com/clayworks/middleware/internal/models"
 	"github.com/clayworks/middleware/internal/services"
 )
 
 type AnalyticsHandler struct {
 	analytics *services.AnalyticsService
+	limits    IngestLimits
 }
 
-func NewAnalyticsHandler(analytics *services.AnalyticsService) *AnalyticsHandler {
-	return &AnalyticsHandler{analytics: analytics}
+func NewAnalyticsHandler(analytics *services.AnalyticsService, limits IngestLimits) *AnalyticsHandler {
+	return &AnalyticsHandler{analytics: analytics, limits: limits}
 }
 
 type IngestResponse struct {
@@ -23,26 +25,45 @@ type IngestResponse struct {
 }
 
 func (h *AnalyticsHandler) IngestEvents(w http.ResponseWriter, r *http.Request) {
-	var batch models.AnalyticsEventBatch
+	h.limits.limitBody(w, r, 0)
 
-	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(IngestResponse{
-			Success: false,
-			Message: "Invalid request body",
-		})
+	var batch models.AnalyticsEventBatch
+	if err := decodeJSON(r.Body, &batch, h.limits.DisallowUnknownFields); err != nil {
+		writeDecodeError(w, r, err)
 		return
 	}
-
-	// Process each event
-	for _, event := range batch.Events {
-		h.analytics.TrackEvent(event)
+	if !h.limits.checkEvents(w, r, batch.Events) {
+		return
 	}
 
+	h.track(r, batch.Events)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(IngestResponse{
 		Success: true,
 		Count:   len(batch.Events),
 	})
 }
+
+type AnalyticsStatsResponse struct {
+	QueueDepth int               `json:"queue_depth"`
+	Bots       services.BotStats `json:"bots"`
+}
+
+// Stats reports the delivery queue depth and bot filtering counters
+func (h *AnalyticsHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AnalyticsStatsResponse{
+		QueueDepth: h.analytics.QueueDepth(),
+		Bots:       h.analytics.BotStats(),
+	})
+}
+
+// track attaches the request's client context to each event and queues it
+func (h *AnalyticsHandler) track(r *http.Request, events []models.AnalyticsEvent) {
+	client := h.analytics.ClientContext(r, middleware.ClientIP(r))
+	for _, event := range events {
+		event.Client = client
+		h.analytics.TrackEvent(event)
+	}
+}
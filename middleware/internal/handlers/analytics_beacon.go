@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/clayworks/middleware/internal/models"
+)
+
+// maxBeaconBytes caps beacon bodies; sendBeacon payloads are limited to 64KB
+// by browsers anyway
+const maxBeaconBytes = 64 << 10
+
+// transparentGIF is a 1x1 transparent GIF89a
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00,
+	0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00,
+	0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+var errNoEvents = errors.New("no events in payload")
+
+// Beacon accepts events sent with navigator.sendBeacon. Bodies may be JSON
+// sent as text/plain (or application/json), or form-encoded with either an
+// "events" field holding JSON or individual event fields.
+func (h *AnalyticsHandler) Beacon(w http.ResponseWriter, r *http.Request) {
+	h.limits.limitBody(w, r, maxBeaconBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var (
+		events []models.AnalyticsEvent
+		err    error
+	)
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		events, err = beaconFormEvents(r, h.limits.DisallowUnknownFields)
+	default:
+		var body []byte
+		if body, err = io.ReadAll(r.Body); err == nil {
+			events, err = decodeEventsJSON(body, h.limits.DisallowUnknownFields)
+		}
+	}
+
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if !h.limits.checkEvents(w, r, events) {
+		return
+	}
+
+	h.track(r, events)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Pixel records a single event described by the query string and responds
+// with a 1x1 GIF. The event name defaults to page_view.
+//
+//	/api/v1/analytics/pixel.gif?n=page_view&sid=abc&p.path=/locations
+func (h *AnalyticsHandler) Pixel(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("n") == "" && query.Get("name") == "" {
+		query.Set("n", "page_view")
+	}
+
+	// A pixel always answers with the image; events over the limits are
+	// dropped
+	if event, ok := eventFromValues(query); ok && len(h.limits.validate([]models.AnalyticsEvent{event})) == 0 {
+		h.track(r, []models.AnalyticsEvent{event})
+	}
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Content-Length", strconv.Itoa(len(transparentGIF)))
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	w.Write(transparentGIF)
+}
+
+func beaconFormEvents(r *http.Request, strict bool) ([]models.AnalyticsEvent, error) {
+	if err := r.ParseMultipartForm(maxBeaconBytes); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, err
+	}
+
+	if payload := r.PostForm.Get("events"); payload != "" {
+		return decodeEventsJSON([]byte(payload), strict)
+	}
+
+	event, ok := eventFromValues(r.PostForm)
+	if !ok {
+		return nil, errNoEvents
+	}
+	return []models.AnalyticsEvent{event}, nil
+}
+
+// decodeEventsJSON accepts a batch object, a bare array of events or a
+// single event object
+func decodeEventsJSON(body []byte, strict bool) ([]models.AnalyticsEvent, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, errNoEvents
+	}
+
+	switch body[0] {
+	case '[':
+		var events []models.AnalyticsEvent
+		if err := decodeJSON(bytes.NewReader(body), &events, strict); err != nil {
+			return nil, err
+		}
+		return events, nil
+	case '{':
+		var probe map[string]json.RawMessage
+		if err := json.Unmarshal(body, &probe); err != nil {
+			return nil, err
+		}
+		if _, isBatch := probe["events"]; isBatch {
+			var batch models.AnalyticsEventBatch
+			if err := decodeJSON(bytes.NewReader(body), &batch, strict); err != nil {
+				return nil, err
+			}
+			return batch.Events, nil
+		}
+		var event models.AnalyticsEvent
+		if err := decodeJSON(bytes.NewReader(body), &event, strict); err != nil {
+			return nil, err
+		}
+		if event.Name == "" {
+			return nil, errNoEvents
+		}
+		return []models.AnalyticsEvent{event}, nil
+	}
+
+	return nil, errNoEvents
+}
+
+// eventFromValues builds an event from short or long field names. Properties
+// are taken from "p.<key>" fields or a "properties" JSON object.
+func eventFromValues(values url.Values) (models.AnalyticsEvent, bool) {
+	get := func(short, long string) string {
+		if v := values.Get(short); v != "" {
+			return v
+		}
+		return values.Get(long)
+	}
+
+	event := models.AnalyticsEvent{
+		Name:      get("n", "name"),
+		Category:  get("c", "category"),
+		Label:     get("l", "label"),
+		SessionID: get("sid", "session_id"),
+		UserID:    get("uid", "user_id"),
+	}
+	if event.Name == "" {
+		return event, false
+	}
+
+	if v := get("v", "value"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			event.Value = f
+		}
+	}
+
+	props := make(map[string]interface{})
+	if raw := values.Get("properties"); raw != "" {
+		json.Unmarshal([]byte(raw), &props)
+	}
+	for key, vals := range values {
+		if name, ok := strings.CutPrefix(key, "p."); ok && name != "" && len(vals) > 0 {
+			props[name] = vals[0]
+		}
+	}
+	if len(props) > 0 {
+		event.Properties = props
+	}
+
+	return event, true
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/clayworks/middleware/internal/models"
+)
+
+// maxErrorDetails caps how many violations one error response lists
+const maxErrorDetails = 20
+
+var (
+	errUnknownField = errors.New("unknown field")
+	errTrailingData = errors.New("unexpected data after JSON value")
+)
+
+// IngestLimits bounds what a single analytics ingest request may contain.
+// Zero values disable the corresponding check.
+type IngestLimits struct {
+	MaxBodyBytes int64
+	MaxEvents    int
+	// MaxPropertyDepth counts nesting levels, with an event's properties
+	// object at depth 1
+	MaxPropertyDepth int
+	// MaxPropertyKeys caps the object keys and array elements in one
+	// event's properties, at every depth
+	MaxPropertyKeys int
+	// MaxStringLength caps event fields, property keys and string values,
+	// in characters
+	MaxStringLength int
+	// DisallowUnknownFields rejects events carrying fields the gateway does
+	// not know
+	DisallowUnknownFields bool
+}
+
+// decodeJSON decodes exactly one JSON value from r into v, rejecting
+// unknown fields when strict
+func decodeJSON(r io.Reader, v interface{}, strict bool) error {
+	dec := json.NewDecoder(r)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+
+	if err := dec.Decode(v); err != nil {
+		// encoding/json reports unknown fields as plain errors
+		if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return fmt.Errorf("%w %s", errUnknownField, name)
+		}
+		return err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return errTrailingData
+	}
+	return nil
+}
+
+// writeDecodeError responds to a body that could not be decoded: 413 when
+// it was too large, 422 for unknown fields, 400 otherwise
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, r, http.StatusRequestEntityTooLarge, "payload_too_large",
+			fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+	case errors.Is(err, errUnknownField):
+		writeErrorDetails(w, r, http.StatusUnprocessableEntity, "invalid_events", "Events failed validation",
+			[]ErrorDetail{{Field: "events", Message: err.Error()}})
+	default:
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+	}
+}
+
+// checkEvents writes a 413 or 422 response and returns false when events
+// exceed the limits
+func (l IngestLimits) checkEvents(w http.ResponseWriter, r *http.Request, events []models.AnalyticsEvent) bool {
+	if l.MaxEvents > 0 && len(events) > l.MaxEvents {
+		writeError(w, r, http.StatusRequestEntityTooLarge, "too_many_events",
+			fmt.Sprintf("Batch has %d events; the limit is %d", len(events), l.MaxEvents))
+		return false
+	}
+
+	if details := l.validate(events); len(details) > 0 {
+		writeErrorDetails(w, r, http.StatusUnprocessableEntity, "invalid_events", "Events failed validation", details)
+		return false
+	}
+	return true
+}
+
+// validate returns one detail per violation, up to maxErrorDetails
+func (l IngestLimits) validate(events []models.AnalyticsEvent) []ErrorDetail {
+	v := eventValidator{limits: l}
+
+	for i, event := range events {
+		prefix := fmt.Sprintf("events[%d]", i)
+		v.checkString(prefix+".name", event.Name)
+		v.checkString(prefix+".category", event.Category)
+		v.checkString(prefix+".label", event.Label)
+		v.checkString(prefix+".session_id", event.SessionID)
+		v.checkString(prefix+".user_id", event.UserID)
+
+		v.keys = 0
+		v.checkValue(prefix+".properties", event.Properties, 1)
+		if l.MaxPropertyKeys > 0 && v.keys > l.MaxPropertyKeys {
+			v.add(prefix+".properties", fmt.Sprintf("has %d keys; the limit is %d", v.keys, l.MaxPropertyKeys))
+		}
+
+		if len(v.details) >= maxErrorDetails {
+			break
+		}
+	}
+	return v.details
+}
+
+type eventValidator struct {
+	limits  IngestLimits
+	keys    int
+	details []ErrorDetail
+}
+
+func (v *eventValidator) add(field, message string) {
+	if len(v.details) < maxErrorDetails {
+		v.details = append(v.details, ErrorDetail{Field: field, Message: message})
+	}
+}
+
+func (v *eventValidator) checkString(field, value string) {
+	if v.limits.MaxStringLength > 0 && utf8.RuneCountInString(value) > v.limits.MaxStringLength {
+		v.add(field, fmt.Sprintf("is longer than %d characters", v.limits.MaxStringLength))
+	}
+}
+
+// checkValue walks a decoded property value; depth is the nesting level of
+// value itself when it is an object or array
+func (v *eventValidator) checkValue(field string, value interface{}, depth int) {
+	switch value := value.(type) {
+	case string:
+		v.checkString(field, value)
+	case map[string]interface{}:
+		if !v.checkDepth(field, depth) {
+			return
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			v.keys++
+			v.checkString(field+"."+key, key)
+			v.checkValue(field+"."+key, value[key], depth+1)
+		}
+	case []interface{}:
+		if !v.checkDepth(field, depth) {
+			return
+		}
+		for i, nested := range value {
+			v.keys++
+			v.checkValue(fmt.Sprintf("%s[%d]", field, i), nested, depth+1)
+		}
+	}
+}
+
+func (v *eventValidator) checkDepth(field string, depth int) bool {
+	if v.limits.MaxPropertyDepth > 0 && depth > v.limits.MaxPropertyDepth {
+		v.add(field, fmt.Sprintf("is nested deeper than %d levels", v.limits.MaxPropertyDepth))
+		return false
+	}
+	return true
+}
+
+// limitBody applies MaxBodyBytes, capped at max when max is positive
+func (l IngestLimits) limitBody(w http.ResponseWriter, r *http.Request, max int64) {
+	limit := l.MaxBodyBytes
+	if max > 0 && (limit <= 0 || limit > max) {
+		limit = max
+	}
+	if limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+}
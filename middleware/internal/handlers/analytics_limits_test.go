@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/clayworks/middleware/internal/models"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		strict bool
+		want   error
+	}{
+		{name: "valid", body: `{"events": []}`},
+		{name: "unknown field allowed", body: `{"events": [{"name": "x", "extra": 1}]}`},
+		{name: "unknown field strict", body: `{"events": [{"name": "x", "extra": 1}]}`, strict: true, want: errUnknownField},
+		{name: "trailing data", body: `{"events": []} {"events": []}`, want: errTrailingData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var batch models.AnalyticsEventBatch
+			err := decodeJSON(strings.NewReader(tt.body), &batch, tt.strict)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("decodeJSON() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIngestLimitsValidate(t *testing.T) {
+	limits := IngestLimits{MaxPropertyDepth: 2, MaxPropertyKeys: 4, MaxStringLength: 5}
+
+	tests := []struct {
+		name   string
+		event  string
+		fields []string
+	}{
+		{name: "within limits", event: `{"name": "click", "properties": {"a": {"b": 1}, "c": [1]}}`},
+		{name: "long name", event: `{"name": "page_view"}`, fields: []string{"events[0].name"}},
+		{name: "long key", event: `{"name": "x", "properties": {"longkey": 1}}`, fields: []string{"events[0].properties.longkey"}},
+		{name: "long value", event: `{"name": "x", "properties": {"a": ["abcdef"]}}`, fields: []string{"events[0].properties.a[0]"}},
+		{name: "multibyte within limit", event: `{"name": "ಕ್ಲೇ"}`},
+		{name: "too deep", event: `{"name": "x", "properties": {"a": {"b": {"c": 1}}}}`, fields: []string{"events[0].properties.a.b"}},
+		{name: "too many keys", event: `{"name": "x", "properties": {"a": [1, 2, 3], "b": 1}}`, fields: []string{"events[0].properties"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var event models.AnalyticsEvent
+			if err := json.Unmarshal([]byte(tt.event), &event); err != nil {
+				t.Fatal(err)
+			}
+			details := limits.validate([]models.AnalyticsEvent{event})
+
+			var fields []string
+			for _, d := range details {
+				fields = append(fields, d.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("violations = %v, want %v", details, tt.fields)
+			}
+		})
+	}
+}
+
+func TestIngestLimitsCapDetails(t *testing.T) {
+	events := make([]models.AnalyticsEvent, 50)
+	for i := range events {
+		events[i].Name = "much-too-long"
+	}
+	if got := len((IngestLimits{MaxStringLength: 5}).validate(events)); got != maxErrorDetails {
+		t.Errorf("details = %d, want %d", got, maxErrorDetails)
+	}
+}
+
+func TestIngestEventsRejects(t *testing.T) {
+	h := NewAnalyticsHandler(nil, IngestLimits{MaxBodyBytes: 200, MaxEvents: 2, MaxStringLength: 20, DisallowUnknownFields: true})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{name: "body too large", body: `{"events": [{"name": "` + strings.Repeat("x", 300) + `"}]}`, status: http.StatusRequestEntityTooLarge, code: "payload_too_large"},
+		{name: "too many events", body: `{"events": [{"name": "a"}, {"name": "b"}, {"name": "c"}]}`, status: http.StatusRequestEntityTooLarge, code: "too_many_events"},
+		{name: "unknown field", body: `{"events": [{"name": "a", "ip": "1.2.3.4"}]}`, status: http.StatusUnprocessableEntity, code: "invalid_events"},
+		{name: "invalid event", body: `{"events": [{"name": "` + strings.Repeat("x", 21) + `"}]}`, status: http.StatusUnprocessableEntity, code: "invalid_events"},
+		{name: "malformed", body: `{"events": [`, status: http.StatusBadRequest, code: "invalid_request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.IngestEvents(rec, httptest.NewRequest(http.MethodPost, "/api/v1/analytics/events", strings.NewReader(tt.body)))
+
+			var resp struct {
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if rec.Code != tt.status || resp.Error.Code != tt.code {
+				t.Errorf("response = %d %q, want %d %q", rec.Code, resp.Error.Code, tt.status, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/clayworks/middleware/internal/services"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultReportRange = 7 * 24 * time.Hour
+	// maxReportRange bounds the hours a single report walks
+	maxReportRange = 366 * 24 * time.Hour
+)
+
+var errReportRangeTooLong = errors.New("Report range must be at most 366 days")
+
+type ReportResponse struct {
+	From time.Time             `json:"from"`
+	To   time.Time             `json:"to"`
+	Data []services.CountEntry `json:"data"`
+}
+
+// SessionsReportResponse counts distinct session IDs. Stored IDs are hashed
+// with a salt that rotates every ANALYTICS_SALT_ROTATION, so over a longer
+// range a session is counted once per rotation period: the count is the sum of
+// the daily uniques with the default rotation. SaltRotation says which.
+type SessionsReportResponse struct {
+	From           time.Time `json:"from"`
+	To             time.Time `json:"to"`
+	UniqueSessions int       `json:"unique_sessions"`
+	SaltRotation   string    `json:"salt_rotation"`
+}
+
+// PageViewsReport returns page views by path
+func (h *AnalyticsHandler) PageViewsReport(w http.ResponseWriter, r *http.Request) {
+	h.rankedReport(w, r, func(rollup *services.AnalyticsRollup) map[string]int64 {
+		return rollup.PageViews
+	})
+}
+
+// ReferrersReport returns the top referring hosts
+func (h *AnalyticsHandler) ReferrersReport(w http.ResponseWriter, r *http.Request) {
+	h.rankedReport(w, r, func(rollup *services.AnalyticsRollup) map[string]int64 {
+		return rollup.Referrers
+	})
+}
+
+// EventsReport returns event counts by name
+func (h *AnalyticsHandler) EventsReport(w http.ResponseWriter, r *http.Request) {
+	h.rankedReport(w, r, func(rollup *services.AnalyticsRollup) map[string]int64 {
+		return rollup.Events
+	})
+}
+
+// SessionsReport returns the number of unique sessions, per salt rotation
+// period
+func (h *AnalyticsHandler) SessionsReport(w http.ResponseWriter, r *http.Request) {
+	rollup, ok := h.report(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SessionsReportResponse{
+		From:           rollup.Start,
+		To:             rollup.End,
+		UniqueSessions: len(rollup.Sessions),
+		SaltRotation:   h.analytics.SaltRotation().String(),
+	})
+}
+
+func (h *AnalyticsHandler) rankedReport(w http.ResponseWriter, r *http.Request, pick func(*services.AnalyticsRollup) map[string]int64) {
+	rollup, ok := h.report(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limit = n
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReportResponse{
+		From: rollup.Start,
+		To:   rollup.End,
+		Data: services.TopCounts(pick(rollup), limit),
+	})
+}
+
+func (h *AnalyticsHandler) report(w http.ResponseWriter, r *http.Request) (*services.AnalyticsRollup, bool) {
+	store := h.analytics.Store()
+	if store == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "storage_disabled", "First-party analytics storage is disabled")
+		return nil, false
+	}
+
+	from, to, err := parseReportRange(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+		return nil, false
+	}
+
+	rollup, err := store.Report(r.Context(), from, to)
+	if err != nil {
+		if r.Context().Err() != nil {
+			// The client left or the request timed out; nobody reads this
+			return nil, false
+		}
+		log.Error().Err(err).Msg("Failed to build analytics report")
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to build report")
+		return nil, false
+	}
+
+	return rollup, true
+}
+
+// parseReportRange reads from/to as RFC 3339 timestamps or YYYY-MM-DD dates.
+// A date-only "to" includes that whole day. Defaults to the last seven days;
+// ranges longer than maxReportRange are refused.
+func parseReportRange(r *http.Request) (time.Time, time.Time, error) {
+	to := time.Now().UTC()
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, dateOnly, err := parseReportTime(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, errInvalidParam("to")
+		}
+		if dateOnly {
+			t = t.Add(24 * time.Hour)
+		}
+		to = t
+	}
+
+	from := to.Add(-defaultReportRange)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, _, err := parseReportTime(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, errInvalidParam("from")
+		}
+		from = t
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errInvalidParam("from")
+	}
+	if to.Sub(from) > maxReportRange {
+		return time.Time{}, time.Time{}, errReportRangeTooLong
+	}
+
+	return from, to, nil
+}
+
+func parseReportTime(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
+}
+
+type errInvalidParam string
+
+func (e errInvalidParam) Error() string {
+	return "Invalid " + string(e) + " parameter"
+}
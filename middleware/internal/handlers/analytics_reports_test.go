@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseReportRange(t *testing.T) {
+	tests := []struct {
+		query    string
+		from, to string
+		err      string
+	}{
+		{query: "from=2026-03-01&to=2026-03-07", from: "2026-03-01T00:00:00Z", to: "2026-03-08T00:00:00Z"},
+		{query: "from=2026-03-01T10:00:00Z&to=2026-03-01T12:00:00Z", from: "2026-03-01T10:00:00Z", to: "2026-03-01T12:00:00Z"},
+		{query: "to=2026-03-07", from: "2026-03-01T00:00:00Z", to: "2026-03-08T00:00:00Z"},
+		{query: "from=2025-03-01&to=2026-02-28", from: "2025-03-01T00:00:00Z", to: "2026-03-01T00:00:00Z"},
+		{query: "from=2025-01-01&to=2026-03-01", err: "Report range must be at most 366 days"},
+		{query: "from=0001-01-01&to=2026-03-01", err: "Report range must be at most 366 days"},
+		{query: "from=2026-03-07&to=2026-03-01", err: "Invalid from parameter"},
+		{query: "from=yesterday", err: "Invalid from parameter"},
+		{query: "to=03/07/2026", err: "Invalid to parameter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			from, to, err := parseReportRange(httptest.NewRequest("GET", "/?"+tt.query, nil))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("parseReportRange() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseReportRange() error = %v", err)
+			}
+			if got := from.Format(time.RFC3339); got != tt.from {
+				t.Errorf("from = %s, want %s", got, tt.from)
+			}
+			if got := to.Format(time.RFC3339); got != tt.to {
+				t.Errorf("to = %s, want %s", got, tt.to)
+			}
+		})
+	}
+}
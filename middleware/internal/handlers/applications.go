@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/clayworks/middleware/internal/middleware"
+	"github.com/clayworks/middleware/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// resumeField is the multipart field carrying the resume
+const resumeField = "resume"
+
+type ApplicationHandler struct {
+	applications *services.ApplicationService
+}
+
+func NewApplicationHandler(applications *services.ApplicationService) *ApplicationHandler {
+	return &ApplicationHandler{applications: applications}
+}
+
+type ApplicationResponse struct {
+	Success bool `json:"success"`
+}
+
+// Apply accepts a multipart/form-data application to the job listing with
+// the given slug: the applicant's details as fields and the resume as a file
+// in "resume". The details must come before the resume, which is streamed to
+// Strapi as it arrives; anything after it is ignored. Spam gets the same 202
+// as an accepted application.
+func (h *ApplicationHandler) Apply(w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "multipart/form-data" {
+		writeError(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type", "Applications must be multipart/form-data")
+		return
+	}
+
+	// Room for the fields on top of the resume; the service refuses larger
+	// resumes while streaming them
+	r.Body = http.MaxBytesReader(w, r.Body, h.applications.MaxResumeBytes()+maxFormBytes)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	values, resume, err := applicationParts(reader)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	req := services.ApplicationRequest{
+		Values:    values,
+		Resume:    resume,
+		UserAgent: r.UserAgent(),
+		ClientIP:  middleware.ClientIP(r),
+	}
+	_, err = h.applications.Apply(r.Context(), chi.URLParam(r, "slug"), req)
+
+	var invalid *services.FormValidationError
+	switch {
+	case err == nil, errors.Is(err, services.ErrSpam):
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(ApplicationResponse{Success: true})
+	case errors.As(err, &invalid):
+		details := make([]ErrorDetail, len(invalid.Fields))
+		for i, field := range invalid.Fields {
+			details[i] = ErrorDetail{Field: field.Field, Message: field.Message}
+		}
+		writeErrorDetails(w, r, http.StatusUnprocessableEntity, "invalid_application", "Application failed validation", details)
+	case errors.Is(err, services.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "unknown_listing", "Unknown job listing")
+	case errors.Is(err, services.ErrListingClosed):
+		writeError(w, r, http.StatusGone, "listing_closed", "This job listing is no longer accepting applications")
+	case errors.Is(err, services.ErrUploadRead):
+		writeDecodeError(w, r, err)
+	default:
+		writeSubmitError(w, r, err, "application_failed", "Application could not be saved")
+	}
+}
+
+// applicationParts reads the fields of an application up to the resume,
+// whose file is left unread for the service to stream. The fields share
+// maxFormBytes, and the first value of a repeated field wins. Other files
+// are skipped.
+func applicationParts(reader *multipart.Reader) (map[string]string, *services.Resume, error) {
+	values := make(map[string]string)
+	remaining := int64(maxFormBytes)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return values, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if part.FileName() != "" {
+			if part.FormName() == resumeField {
+				return values, &services.Resume{Filename: part.FileName(), File: part}, nil
+			}
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, remaining+1))
+		if err != nil {
+			return nil, nil, err
+		}
+		remaining -= int64(len(value))
+		if remaining < 0 {
+			return nil, nil, &http.MaxBytesError{Limit: maxFormBytes}
+		}
+		if _, seen := values[part.FormName()]; !seen {
+			values[part.FormName()] = string(value)
+		}
+	}
+}
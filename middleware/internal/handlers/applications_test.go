@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+	"github.com/clayworks/middleware/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// fakeJobsStrapi serves one open listing, the upload API and the
+// application collection
+type fakeJobsStrapi struct {
+	mu           sync.Mutex
+	uploaded     []byte
+	applications int
+}
+
+func (f *fakeJobsStrapi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/api/job-listings" && r.URL.Query().Get("filters[slug][$eq]") == "designer":
+		w.Write([]byte(`{"data":[{"documentId":"listing1","isActive":true}]}`))
+	case r.URL.Path == "/api/job-listings":
+		w.Write([]byte(`{"data":[]}`))
+	case r.URL.Path == "/api/upload":
+		f.uploaded = nil
+		file, _, err := r.FormFile("files")
+		if err != nil {
+			http.Error(w, "bad upload", http.StatusBadRequest)
+			return
+		}
+		f.uploaded, _ = io.ReadAll(file)
+		w.Write([]byte(`[{"id":7,"documentId":"file7"}]`))
+	case r.URL.Path == "/api/applications":
+		f.applications++
+		w.Write([]byte(`{"data":{"documentId":"app1"}}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type multipartPart struct {
+	name, filename, value string
+}
+
+func multipartBody(t *testing.T, parts ...multipartPart) (*bytes.Buffer, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		var w io.Writer
+		var err error
+		if p.filename != "" {
+			w, err = mw.CreateFormFile(p.name, p.filename)
+		} else {
+			w, err = mw.CreateFormField(p.name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, p.value)
+	}
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func TestApplicationHandlerApply(t *testing.T) {
+	pdf := "%PDF-1.7\n" + strings.Repeat("x", 600)
+	fields := []multipartPart{
+		{name: "fullName", value: "Jane Doe"},
+		{name: "email", value: "jane@example.com"},
+		{name: "linkedIn", value: "https://www.linkedin.com/in/janedoe"},
+	}
+	with := func(parts ...multipartPart) []multipartPart {
+		return append(append([]multipartPart(nil), fields...), parts...)
+	}
+
+	tests := []struct {
+		name        string
+		slug        string
+		parts       []multipartPart
+		contentType string
+		status      int
+		field       string
+		noToken     bool
+		uploaded    bool
+	}{
+		{name: "accepted", slug: "designer", parts: with(multipartPart{"resume", "cv.pdf", pdf}), status: http.StatusAccepted, uploaded: true},
+		{name: "no token", slug: "designer", parts: with(multipartPart{"resume", "cv.pdf", pdf}), noToken: true, status: http.StatusAccepted},
+		{name: "small resume", slug: "designer", parts: with(multipartPart{"resume", "cv.pdf", "%PDF-1"}), status: http.StatusAccepted, uploaded: true},
+		{name: "fields after resume ignored", slug: "designer", parts: append([]multipartPart{{"resume", "cv.pdf", pdf}}, fields...), status: http.StatusUnprocessableEntity, field: "fullName"},
+		{name: "missing resume", slug: "designer", parts: fields, status: http.StatusUnprocessableEntity, field: "resume"},
+		{name: "resume without file name", slug: "designer", parts: with(multipartPart{name: "resume", value: pdf}), status: http.StatusUnprocessableEntity, field: "resume"},
+		{name: "empty resume", slug: "designer", parts: with(multipartPart{"resume", "cv.pdf", ""}), status: http.StatusUnprocessableEntity, field: "resume"},
+		{name: "wrong extension", slug: "designer", parts: with(multipartPart{"resume", "cv.exe", pdf}), status: http.StatusUnprocessableEntity, field: "resume"},
+		{name: "wrong content", slug: "designer", parts: with(multipartPart{"resume", "cv.pdf", "MZ\x90\x00"}), status: http.StatusUnprocessableEntity, field: "resume"},
+		{name: "resume too large", slug: "designer", parts: with(multipartPart{"resume", "cv.pdf", "%PDF-" + strings.Repeat("x", 2048)}), status: http.StatusUnprocessableEntity, field: "resume"},
+		{name: "long URL", slug: "designer", parts: append(with(multipartPart{name: "portfolio", value: "https://example.com/" + strings.Repeat("p", 250)}), multipartPart{"resume", "cv.pdf", pdf}), status: http.StatusUnprocessableEntity, field: "portfolio"},
+		{name: "fields too large", slug: "designer", parts: with(multipartPart{name: "coverLetter", value: strings.Repeat("a", maxFormBytes)}, multipartPart{"resume", "cv.pdf", pdf}), status: http.StatusRequestEntityTooLarge},
+		{name: "unknown listing", slug: "astronaut", parts: with(multipartPart{"resume", "cv.pdf", pdf}), status: http.StatusNotFound},
+		{name: "not multipart", slug: "designer", contentType: "application/json", status: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeJobsStrapi{}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			cfg := &config.Config{
+				StrapiURL:          srv.URL,
+				StrapiTimeout:      5 * time.Second,
+				FormsTokenSecret:   "test",
+				JobsResumeMaxBytes: 1024,
+				JobsResumeTypes:    []string{"pdf", "docx"},
+			}
+			strapi := services.NewStrapiService(cfg, &services.CacheService{})
+			applications, err := services.NewApplicationService(cfg, strapi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			forms, err := services.NewFormService(cfg, strapi, services.NewMailer(cfg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router := chi.NewRouter()
+			router.Post("/jobs/{slug}/applications", NewApplicationHandler(applications).Apply)
+
+			parts := tt.parts
+			if !tt.noToken {
+				parts = append([]multipartPart{{name: services.FormTokenField, value: forms.Token()}}, parts...)
+			}
+			body, contentType := multipartBody(t, parts...)
+			if tt.contentType != "" {
+				contentType = tt.contentType
+			}
+			req := httptest.NewRequest(http.MethodPost, "/jobs/"+tt.slug+"/applications", body)
+			req.Header.Set("Content-Type", contentType)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.field != "" {
+				var resp ErrorResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if !hasDetail(resp.Error.Details, tt.field) {
+					t.Errorf("details = %+v, want one for %s", resp.Error.Details, tt.field)
+				}
+			}
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if tt.uploaded {
+				if want := tt.parts[len(tt.parts)-1].value; string(fake.uploaded) != want || fake.applications != 1 {
+					t.Errorf("uploaded %d bytes and %d applications, want %d bytes and 1", len(fake.uploaded), fake.applications, len(want))
+				}
+			} else if fake.applications != 0 || fake.uploaded != nil {
+				t.Errorf("stored %d applications and a %d byte resume, want none", fake.applications, len(fake.uploaded))
+			}
+		})
+	}
+}
+
+func hasDetail(details []ErrorDetail, field string) bool {
+	for _, d := range details {
+		if d.Field == field {
+			return true
+		}
+	}
+	return false
+}
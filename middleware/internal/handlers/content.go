@@ -19,9 +19,9 @@ func (h *ContentHandler) GetCollection(w http.ResponseWriter, r *http.Request) {
 	contentType := chi.URLParam(r, "type")
 	query := r.URL.Query()
 
-	data, cached, err := h.strapi.GetCollection(contentType, query)
+	data, cached, err := h.strapi.GetCollection(r.Context(), contentType, query)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeUpstreamError(w, r, err)
 		return
 	}
 
@@ -33,9 +33,9 @@ func (h *ContentHandler) GetSingle(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	query := r.URL.Query()
 
-	data, cached, err := h.strapi.GetSingle(contentType, id, query)
+	data, cached, err := h.strapi.GetSingle(r.Context(), contentType, id, query)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeUpstreamError(w, r, err)
 		return
 	}
 
@@ -45,9 +45,27 @@ func (h *ContentHandler) GetSingle(w http.ResponseWriter, r *http.Request) {
 func (h *ContentHandler) GetPage(w http.ResponseWriter, r *http.Request) {
 	slug := chi.URLParam(r, "slug")
 
-	data, cached, err := h.strapi.GetPageBySlug(slug)
+	data, cached, err := h.strapi.GetPageBySlug(r.Context(), slug, r.URL.Query().Get("preset"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	h.writeResponse(w, data, cached)
+}
+
+// GetBySlug serves /content/{type}/by-slug/{slug} for registered content
+// types that have a slug field
+func (h *ContentHandler) GetBySlug(w http.ResponseWriter, r *http.Request) {
+	ct, ok := services.LookupContentType(chi.URLParam(r, "type"))
+	if !ok || !ct.HasSlug() {
+		writeError(w, r, http.StatusNotFound, "unknown_content_type", "Content type does not support slug lookups")
+		return
+	}
+
+	data, cached, err := h.strapi.GetBySlug(r.Context(), ct, chi.URLParam(r, "slug"), r.URL.Query().Get("preset"))
+	if err != nil {
+		writeUpstreamError(w, r, err)
 		return
 	}
 
@@ -58,9 +76,9 @@ func (h *ContentHandler) GetPreview(w http.ResponseWriter, r *http.Request) {
 	contentType := chi.URLParam(r, "type")
 	id := chi.URLParam(r, "id")
 
-	data, err := h.strapi.GetPreview(contentType, id)
+	data, err := h.strapi.GetPreview(r.Context(), contentType, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeUpstreamError(w, r, err)
 		return
 	}
 
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/clayworks/middleware/internal/services"
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+)
+
+// statusClientClosedRequest is logged when the client goes away before the
+// upstream answers (nginx convention); nobody is left to read the body
+const statusClientClosedRequest = 499
+
+// ErrorResponse is the JSON envelope for every handler error
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	// Details lists individual validation failures
+	Details []ErrorDetail `json:"details,omitempty"`
+}
+
+// ErrorDetail points at one invalid field of the request
+type ErrorDetail struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeErrorDetails(w, r, status, code, message, nil)
+}
+
+func writeErrorDetails(w http.ResponseWriter, r *http.Request, status int, code, message string, details []ErrorDetail) {
+	requestID := chimw.GetReqID(r.Context())
+	if requestID != "" {
+		w.Header().Set("X-Request-ID", requestID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error: ErrorBody{
+			Status:    status,
+			Code:      code,
+			Message:   message,
+			RequestID: requestID,
+			Details:   details,
+		},
+	})
+}
+
+// writeSubmitError responds to a failed write of a visitor submission. Strapi
+// being unavailable is reported as such; Strapi rejecting the write means the
+// gateway or its token is misconfigured, not that the client sent something
+// wrong, so it becomes a 502 with the given code and message.
+func writeSubmitError(w http.ResponseWriter, r *http.Request, err error, code, message string) {
+	switch {
+	case errors.Is(err, services.ErrCircuitOpen),
+		errors.Is(err, services.ErrUpstreamTimeout),
+		errors.Is(err, context.Canceled):
+		writeUpstreamError(w, r, err)
+	default:
+		log.Error().Err(err).Str("path", r.URL.Path).Msg("Submission failed")
+		writeError(w, r, http.StatusBadGateway, code, message)
+	}
+}
+
+// writeUpstreamError maps a StrapiService error to a status code and a
+// message that is safe to show clients
+func writeUpstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "not_found", "Content not found")
+	case errors.Is(err, services.ErrDuplicateSlug):
+		writeError(w, r, http.StatusConflict, "duplicate_slug", "Slug matches more than one entry")
+	case errors.Is(err, services.ErrUnknownContentType):
+		writeError(w, r, http.StatusNotFound, "unknown_content_type", "Unknown content type")
+	case errors.Is(err, services.ErrUnknownPreset):
+		writeError(w, r, http.StatusBadRequest, "unknown_preset", err.Error())
+	case errors.Is(err, services.ErrValidation):
+		message := services.ValidationMessage(err)
+		if message == "" {
+			message = "Invalid content request"
+		}
+		writeError(w, r, http.StatusBadRequest, "invalid_request", message)
+	case errors.Is(err, services.ErrForbidden):
+		writeError(w, r, http.StatusForbidden, "forbidden", "Content access forbidden")
+	case errors.Is(err, services.ErrCircuitOpen):
+		writeError(w, r, http.StatusServiceUnavailable, "upstream_unavailable", "Content service temporarily unavailable")
+	case errors.Is(err, services.ErrUpstreamTimeout):
+		writeError(w, r, http.StatusGatewayTimeout, "upstream_timeout", "Content service timed out")
+	case errors.Is(err, context.Canceled):
+		log.Debug().Str("path", r.URL.Path).Msg("Client closed request")
+		w.WriteHeader(statusClientClosedRequest)
+	case errors.Is(err, services.ErrUpstreamUnavailable):
+		log.Error().Err(err).Str("path", r.URL.Path).Msg("Content service unavailable")
+		writeError(w, r, http.StatusBadGateway, "upstream_unavailable", "Content service unavailable")
+	default:
+		log.Error().Err(err).Str("path", r.URL.Path).Msg("Content request failed")
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/clayworks/middleware/internal/middleware"
+	"github.com/clayworks/middleware/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxFormBytes caps form bodies, leaving room for a long message in
+// multi-byte characters
+const maxFormBytes = 64 << 10
+
+type FormHandler struct {
+	forms *services.FormService
+}
+
+func NewFormHandler(forms *services.FormService) *FormHandler {
+	return &FormHandler{forms: forms}
+}
+
+type FormResponse struct {
+	Success bool `json:"success"`
+}
+
+// TokenResponse carries a render token for the forms, tour bookings and job
+// applications
+type TokenResponse struct {
+	Token string `json:"token"`
+	Field string `json:"field"`
+}
+
+// Token issues a render token. The site fetches one when it shows a form and
+// submits it in the field it names.
+func (h *FormHandler) Token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TokenResponse{Token: h.forms.Token(), Field: services.FormTokenField})
+}
+
+// Submit accepts a form post as a JSON object or as form-encoded fields.
+// Submissions caught by the spam checks get the same 202 as accepted ones.
+func (h *FormHandler) Submit(w http.ResponseWriter, r *http.Request) {
+	formID := chi.URLParam(r, "formId")
+	if _, ok := h.forms.Form(formID); !ok {
+		writeError(w, r, http.StatusNotFound, "unknown_form", "Unknown form")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+
+	values, details, err := formValues(r)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if len(details) > 0 {
+		writeErrorDetails(w, r, http.StatusUnprocessableEntity, "invalid_form", "Form failed validation", details)
+		return
+	}
+
+	err = h.forms.Submit(r.Context(), formID, services.FormSubmission{
+		Values:    values,
+		UserAgent: r.UserAgent(),
+		ClientIP:  middleware.ClientIP(r),
+	})
+
+	var invalid *services.FormValidationError
+	switch {
+	case err == nil, errors.Is(err, services.ErrSpam):
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(FormResponse{Success: true})
+	case errors.Is(err, services.ErrUnknownForm):
+		writeError(w, r, http.StatusNotFound, "unknown_form", "Unknown form")
+	case errors.As(err, &invalid):
+		details := make([]ErrorDetail, len(invalid.Fields))
+		for i, field := range invalid.Fields {
+			details[i] = ErrorDetail{Field: field.Field, Message: field.Message}
+		}
+		writeErrorDetails(w, r, http.StatusUnprocessableEntity, "invalid_form", "Form failed validation", details)
+	default:
+		writeSubmitError(w, r, err, "submission_failed", "Submission could not be saved")
+	}
+}
+
+// formValues reads the submitted fields. JSON numbers and booleans become
+// strings; nested values are reported as invalid fields.
+func formValues(r *http.Request) (map[string]string, []ErrorDetail, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := r.ParseMultipartForm(maxFormBytes); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return nil, nil, err
+		}
+		values := make(map[string]string, len(r.PostForm))
+		for key := range r.PostForm {
+			values[key] = r.PostForm.Get(key)
+		}
+		return values, nil, nil
+	}
+
+	var raw map[string]interface{}
+	if err := decodeJSON(r.Body, &raw, false); err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make(map[string]string, len(raw))
+	var details []ErrorDetail
+	for _, key := range keys {
+		switch value := raw[key].(type) {
+		case nil:
+		case string:
+			values[key] = value
+		case bool:
+			values[key] = strconv.FormatBool(value)
+		case float64:
+			values[key] = strconv.FormatFloat(value, 'f', -1, 64)
+		default:
+			if len(details) < maxErrorDetails {
+				details = append(details, ErrorDetail{Field: key, Message: "must be a string, number or boolean"})
+			}
+		}
+	}
+	return values, details, nil
+}
@@ -37,19 +37,22 @@ func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
 	checks := make(map[string]string)
 
 	// Check Redis
-	if h.cache.IsConnected() {
+	if h.cache.IsConnected(r.Context()) {
 		checks["redis"] = "ok"
 	} else {
 		checks["redis"] = "unavailable"
 	}
 
 	// Check Strapi
-	if h.strapi.IsHealthy() {
+	if h.strapi.IsHealthy(r.Context()) {
 		checks["strapi"] = "ok"
 	} else {
 		checks["strapi"] = "unavailable"
 	}
 
+	// Report the circuit breaker so operators can see Strapi is being shed
+	checks["strapi_circuit"] = h.strapi.BreakerState().String()
+
 	status := "ok"
 	statusCode := http.StatusOK
 
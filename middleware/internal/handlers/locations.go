@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/clayworks/middleware/internal/services"
+)
+
+// Nearby search limits
+const (
+	defaultNearbyLimit = 10
+	maxNearbyLimit     = 50
+	maxNearbyRadiusKm  = 500
+)
+
+type LocationHandler struct {
+	index         *services.LocationIndex
+	defaultRadius float64
+}
+
+func NewLocationHandler(index *services.LocationIndex, defaultRadiusKm float64) *LocationHandler {
+	return &LocationHandler{index: index, defaultRadius: defaultRadiusKm}
+}
+
+type NearbyOrigin struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+type NearbyMeta struct {
+	Total    int          `json:"total"`
+	Limit    int          `json:"limit"`
+	RadiusKm float64      `json:"radiusKm"`
+	Origin   NearbyOrigin `json:"origin"`
+}
+
+type NearbyResponse struct {
+	Data []map[string]interface{} `json:"data"`
+	Meta NearbyMeta               `json:"meta"`
+}
+
+// Nearby lists the locations within radius kilometres of lat/lng, nearest
+// first, optionally filtered by amenities, tags (comma-separated or
+// repeated; all must match), powerBackup and minSeats
+func (h *LocationHandler) Nearby(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := services.NearbyQuery{
+		RadiusKm:  h.defaultRadius,
+		Limit:     defaultNearbyLimit,
+		Amenities: listParam(query["amenities"]),
+		Tags:      listParam(query["tags"]),
+	}
+
+	var details []ErrorDetail
+	fail := func(field, message string) {
+		details = append(details, ErrorDetail{Field: field, Message: message})
+	}
+
+	for _, param := range []struct {
+		name  string
+		dst   *float64
+		limit float64
+	}{{"lat", &q.Lat, 90}, {"lng", &q.Lng, 180}} {
+		value, err := strconv.ParseFloat(query.Get(param.name), 64)
+		if err != nil || math.IsNaN(value) || math.Abs(value) > param.limit {
+			fail(param.name, "is required and must be a coordinate in degrees")
+		}
+		*param.dst = value
+	}
+	if value := query.Get("radius"); value != "" {
+		radius, err := strconv.ParseFloat(value, 64)
+		if err != nil || !(radius > 0 && radius <= maxNearbyRadiusKm) {
+			fail("radius", fmt.Sprintf("must be more than 0 and at most %d kilometres", maxNearbyRadiusKm))
+		}
+		q.RadiusKm = radius
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxNearbyLimit {
+			fail("limit", fmt.Sprintf("must be between 1 and %d", maxNearbyLimit))
+		}
+		q.Limit = limit
+	}
+	if value := query.Get("powerBackup"); value != "" {
+		powerBackup, err := strconv.ParseBool(value)
+		if err != nil {
+			fail("powerBackup", "must be true or false")
+		}
+		q.PowerBackup = &powerBackup
+	}
+	if value := query.Get("minSeats"); value != "" {
+		minSeats, err := strconv.Atoi(value)
+		if err != nil || minSeats < 0 {
+			fail("minSeats", "must be a whole number, not negative")
+		}
+		q.MinSeats = minSeats
+	}
+	if len(details) > 0 {
+		writeErrorDetails(w, r, http.StatusBadRequest, "invalid_request", "Invalid nearby search", details)
+		return
+	}
+
+	locations, total, err := h.index.Nearby(r.Context(), q)
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NearbyResponse{
+		Data: locations,
+		Meta: NearbyMeta{
+			Total:    total,
+			Limit:    q.Limit,
+			RadiusKm: q.RadiusKm,
+			Origin:   NearbyOrigin{Lat: q.Lat, Lng: q.Lng},
+		},
+	})
+}
+
+// listParam splits repeated and comma-separated values, dropping empty ones
+func listParam(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/clayworks/middleware/internal/middleware"
+	"github.com/clayworks/middleware/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+type TourHandler struct {
+	tours *services.TourService
+}
+
+func NewTourHandler(tours *services.TourService) *TourHandler {
+	return &TourHandler{tours: tours}
+}
+
+type TourResponse struct {
+	Success bool      `json:"success"`
+	Status  string    `json:"status"`
+	Slot    time.Time `json:"slot"`
+}
+
+// TourBookingView is a booking as the sales API returns it
+type TourBookingView struct {
+	ID string `json:"id"`
+	services.TourBooking
+}
+
+type TourBookingResponse struct {
+	Data TourBookingView `json:"data"`
+}
+
+type TourAvailabilityResponse struct {
+	Location string              `json:"location"`
+	Date     string              `json:"date"`
+	Timezone string              `json:"timezone"`
+	Slots    []services.TourSlot `json:"slots"`
+}
+
+// Book requests a tour. The body is a JSON object with "location", "slots"
+// (RFC 3339 start times in order of preference), optional "seats", and the
+// contact fields as strings. Spam gets the same 202 as a booking.
+func (h *TourHandler) Book(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+
+	var raw map[string]interface{}
+	if err := decodeJSON(r.Body, &raw, false); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	req, details := tourRequest(raw)
+	if len(details) > 0 {
+		writeErrorDetails(w, r, http.StatusUnprocessableEntity, "invalid_booking", "Booking failed validation", details)
+		return
+	}
+	req.UserAgent = r.UserAgent()
+	req.ClientIP = middleware.ClientIP(r)
+
+	booking, err := h.tours.Book(r.Context(), req)
+
+	var invalid *services.FormValidationError
+	switch {
+	case err == nil:
+		writeTourAccepted(w, booking.Slot)
+	case errors.Is(err, services.ErrSpam):
+		// Echo the first choice so spam responses look like bookings
+		slot, _ := time.Parse(time.RFC3339, req.Slots[0])
+		writeTourAccepted(w, slot.UTC())
+	case errors.As(err, &invalid):
+		details := make([]ErrorDetail, len(invalid.Fields))
+		for i, field := range invalid.Fields {
+			details[i] = ErrorDetail{Field: field.Field, Message: field.Message}
+		}
+		writeErrorDetails(w, r, http.StatusUnprocessableEntity, "invalid_booking", "Booking failed validation", details)
+	case errors.Is(err, services.ErrSlotUnavailable):
+		writeError(w, r, http.StatusConflict, "slot_unavailable", "None of the requested slots is available")
+	default:
+		writeSubmitError(w, r, err, "booking_failed", "Booking could not be saved")
+	}
+}
+
+// tourRequest splits a decoded booking body into the typed fields and the
+// string values validated by the service
+func tourRequest(raw map[string]interface{}) (services.TourRequest, []ErrorDetail) {
+	req := services.TourRequest{Values: make(map[string]string, len(raw))}
+	var details []ErrorDetail
+	fail := func(field, message string) {
+		details = append(details, ErrorDetail{Field: field, Message: message})
+	}
+
+	for key, value := range raw {
+		switch key {
+		case "location":
+			location, ok := value.(string)
+			if !ok {
+				fail(key, "must be a string")
+			}
+			req.Location = location
+		case "slots":
+			slots, ok := value.([]interface{})
+			if !ok {
+				fail(key, "must be an array of times")
+				continue
+			}
+			for i, slot := range slots {
+				s, ok := slot.(string)
+				if !ok {
+					fail(fmt.Sprintf("slots[%d]", i), "must be a string")
+					continue
+				}
+				req.Slots = append(req.Slots, s)
+			}
+		case "seats":
+			seats, ok := value.(float64)
+			if !ok || seats != float64(int(seats)) {
+				fail(key, "must be a whole number")
+			}
+			req.Seats = int(seats)
+		default:
+			// Unknown non-string fields are ignored, like unknown strings
+			if s, ok := value.(string); ok {
+				req.Values[key] = s
+			}
+		}
+	}
+
+	if len(req.Slots) == 0 && len(details) == 0 {
+		fail("slots", "is required")
+	}
+	return req, details
+}
+
+func writeTourAccepted(w http.ResponseWriter, slot time.Time) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(TourResponse{Success: true, Status: services.TourPending, Slot: slot})
+}
+
+// Availability lists the bookable slots of a location on a date, given as
+// YYYY-MM-DD in the tour timezone
+func (h *TourHandler) Availability(w http.ResponseWriter, r *http.Request) {
+	location := r.URL.Query().Get("location")
+	date := r.URL.Query().Get("date")
+	day, err := time.ParseInLocation("2006-01-02", date, h.tours.Timezone())
+	if location == "" || err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "location and date (YYYY-MM-DD) are required")
+		return
+	}
+
+	slots, err := h.tours.Availability(r.Context(), location, day)
+	if errors.Is(err, services.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "unknown_location", "Unknown location")
+		return
+	}
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TourAvailabilityResponse{
+		Location: location,
+		Date:     date,
+		Timezone: h.tours.Timezone().String(),
+		Slots:    slots,
+	})
+}
+
+// List serves the sales team's booking list, filtered by location, status
+// and a from/to range (YYYY-MM-DD in the tour timezone, or RFC 3339), and
+// paginated like Strapi
+func (h *TourHandler) List(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := services.TourFilter{
+		Location: query.Get("location"),
+		Status:   query.Get("status"),
+	}
+
+	var details []ErrorDetail
+	switch filter.Status {
+	case "", services.TourPending, services.TourConfirmed, services.TourCancelled:
+	default:
+		details = append(details, ErrorDetail{Field: "status", Message: "must be pending, confirmed or cancelled"})
+	}
+	for _, param := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if value := query.Get(param.name); value != "" {
+			t, err := h.parseTime(value)
+			if err != nil {
+				details = append(details, ErrorDetail{Field: param.name, Message: "must be YYYY-MM-DD or an RFC 3339 time"})
+			}
+			*param.dst = t
+		}
+	}
+	for _, param := range []struct {
+		name string
+		dst  *int
+		max  int
+	}{{"page", &filter.Page, 0}, {"pageSize", &filter.PageSize, 100}} {
+		if value := query.Get(param.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || (param.max > 0 && n > param.max) {
+				details = append(details, ErrorDetail{Field: param.name, Message: "is out of range"})
+			}
+			*param.dst = n
+		}
+	}
+	if len(details) > 0 {
+		writeErrorDetails(w, r, http.StatusBadRequest, "invalid_request", "Invalid booking filter", details)
+		return
+	}
+
+	data, err := h.tours.List(r.Context(), filter)
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+func (h *TourHandler) parseTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, h.tours.Timezone()); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Get returns one booking
+func (h *TourHandler) Get(w http.ResponseWriter, r *http.Request) {
+	booking, err := h.tours.Get(r.Context(), chi.URLParam(r, "id"))
+	h.writeBooking(w, r, booking, err)
+}
+
+// Confirm confirms a pending booking and sends the visitor the calendar
+// invite
+func (h *TourHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	booking, err := h.tours.Confirm(r.Context(), chi.URLParam(r, "id"))
+	h.writeBooking(w, r, booking, err)
+}
+
+// Cancel cancels a booking, with an optional JSON body {"reason": "..."}
+// that is included in the visitor's email
+func (h *TourHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+		if err := decodeJSON(r.Body, &body, false); err != nil {
+			writeDecodeError(w, r, err)
+			return
+		}
+	}
+
+	booking, err := h.tours.Cancel(r.Context(), chi.URLParam(r, "id"), body.Reason)
+	h.writeBooking(w, r, booking, err)
+}
+
+func (h *TourHandler) writeBooking(w http.ResponseWriter, r *http.Request, booking services.TourBooking, err error) {
+	switch {
+	case errors.Is(err, services.ErrBookingState):
+		writeError(w, r, http.StatusConflict, "invalid_status", fmt.Sprintf("Booking is %s", booking.Status))
+		return
+	case errors.Is(err, services.ErrSlotUnavailable):
+		writeError(w, r, http.StatusConflict, "slot_unavailable", "The booking's slot is full")
+		return
+	case errors.Is(err, services.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "not_found", "Booking not found")
+		return
+	case err != nil:
+		writeSubmitError(w, r, err, "upstream_error", "Booking service failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TourBookingResponse{
+		Data: TourBookingView{ID: booking.DocumentID, TourBooking: booking},
+	})
+}
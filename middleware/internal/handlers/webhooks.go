@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/clayworks/middleware/internal/services"
+	"github.com/rs/zerolog/log"
+)
+
+type WebhookHandler struct {
+	strapi    *services.StrapiService
+	warmer    *services.CacheWarmer
+	locations *services.LocationIndex
+}
+
+func NewWebhookHandler(strapi *services.StrapiService, warmer *services.CacheWarmer, locations *services.LocationIndex) *WebhookHandler {
+	return &WebhookHandler{strapi: strapi, warmer: warmer, locations: locations}
+}
+
+// StrapiWebhook is the subset of a Strapi webhook payload the gateway uses
+type StrapiWebhook struct {
+	Event string `json:"event"`
+	Model string `json:"model"`
+}
+
+type WebhookResponse struct {
+	Success     bool   `json:"success"`
+	Invalidated string `json:"invalidated,omitempty"`
+}
+
+// Strapi invalidates cached content when an entry is created, updated,
+// deleted, published or unpublished. Every cached collection, single and
+// slug entry of the type is dropped, so renamed slugs stop resolving too.
+// Location events also mark the nearby search index stale.
+func (h *WebhookHandler) Strapi(w http.ResponseWriter, r *http.Request) {
+	var payload StrapiWebhook
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid webhook payload")
+		return
+	}
+
+	response := WebhookResponse{Success: true}
+
+	ct, known := services.LookupContentModel(payload.Model)
+	if strings.HasPrefix(payload.Event, "entry.") && known {
+		if _, err := h.strapi.InvalidateCache(r.Context(), ct.Name); err != nil {
+			log.Error().Err(err).Str("type", ct.Name).Msg("Cache invalidation failed")
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Cache invalidation failed")
+			return
+		}
+		log.Info().Str("event", payload.Event).Str("type", ct.Name).Msg("Cache invalidated by Strapi webhook")
+		response.Invalidated = ct.Name
+		h.warmer.Trigger()
+		if ct.Name == "locations" {
+			h.locations.Invalidate()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,237 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+)
+
+const namespace = "clayworks"
+
+// Cache lookup results
+const (
+	CacheHit   = "hit"
+	CacheMiss  = "miss"
+	CacheStale = "stale"
+)
+
+var (
+	// HTTPRequests counts completed requests by chi route pattern
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPDuration observes request latency by chi route pattern
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by method and route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// CacheResults counts content cache lookups by content type and result.
+	// Unregistered content types are counted as "other".
+	CacheResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "lookups_total",
+		Help:      "Content cache lookups by content type and result (hit, miss, stale).",
+	}, []string{"content_type", "result"})
+
+	// StrapiDuration observes upstream Strapi request latency
+	StrapiDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "strapi",
+		Name:      "request_duration_seconds",
+		Help:      "Strapi upstream request latency by response status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"status"})
+
+	// StrapiErrors counts failed upstream Strapi requests
+	StrapiErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "strapi",
+		Name:      "errors_total",
+		Help:      "Failed Strapi upstream requests by status code, or \"network\" for transport errors.",
+	}, []string{"status"})
+
+	// StrapiRetries counts retried Strapi requests
+	StrapiRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "strapi",
+		Name:      "retries_total",
+		Help:      "Strapi requests retried after a connection error or 502/503/504.",
+	})
+
+	// BreakerState reports each upstream circuit breaker's state
+	BreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "circuit_breaker_state",
+		Help:      "Circuit breaker state by upstream: 0 closed, 1 half-open, 2 open.",
+	}, []string{"upstream"})
+
+	// BreakerTransitions counts circuit breaker state changes
+	BreakerTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "circuit_breaker_transitions_total",
+		Help:      "Circuit breaker state changes by upstream and new state.",
+	}, []string{"upstream", "state"})
+
+	// RedisDuration observes Redis command latency
+	RedisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "command_duration_seconds",
+		Help:      "Redis command latency by command and outcome.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command", "outcome"})
+
+	// RateLimitRejections counts requests rejected by a rate limiter
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ratelimit",
+		Name:      "rejections_total",
+		Help:      "Requests rejected with 429 by limiter.",
+	}, []string{"limiter"})
+
+	// RateLimitFallbacks counts switches from Redis to local rate limit counting
+	RateLimitFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ratelimit",
+		Name:      "redis_fallbacks_total",
+		Help:      "Times a limiter fell back to in-process counting after a Redis error.",
+	}, []string{"limiter"})
+
+	// CrowdSecDecisions is the number of addresses and ranges with decisions
+	// held by the stream-mode bouncer
+	CrowdSecDecisions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "crowdsec",
+		Name:      "decisions",
+		Help:      "Addresses and ranges with active CrowdSec decisions.",
+	})
+
+	// CrowdSecBlocks counts requests refused because of a CrowdSec decision
+	CrowdSecBlocks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "crowdsec",
+		Name:      "blocked_requests_total",
+		Help:      "Requests refused because of a CrowdSec decision.",
+	}, []string{"remediation"})
+
+	// FormSubmissions counts lead form posts by outcome: accepted, spam,
+	// invalid or failed
+	FormSubmissions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "forms",
+		Name:      "submissions_total",
+		Help:      "Lead form submissions by form and outcome.",
+	}, []string{"form", "outcome"})
+
+	// LeadNotifications counts lead notifications by notifier and result
+	LeadNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "forms",
+		Name:      "notifications_total",
+		Help:      "Lead notifications by notifier and result.",
+	}, []string{"notifier", "result"})
+
+	// TourBookings counts tour booking requests by outcome: booked, spam,
+	// invalid, unavailable or failed
+	TourBookings = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "tours",
+		Name:      "bookings_total",
+		Help:      "Tour booking requests by outcome.",
+	}, []string{"outcome"})
+
+	// TourEmails counts tour invite and update emails by kind and result
+	TourEmails = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "tours",
+		Name:      "emails_total",
+		Help:      "Tour emails by kind (requested, confirmed, cancelled, sales) and result.",
+	}, []string{"kind", "result"})
+
+	// JobApplications counts job applications by outcome: accepted, spam,
+	// invalid, closed or failed
+	JobApplications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "jobs",
+		Name:      "applications_total",
+		Help:      "Job applications by outcome.",
+	}, []string{"outcome"})
+)
+
+// Handler serves the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterGaugeFunc registers a gauge whose value is read on every scrape
+func RegisterGaugeFunc(subsystem, name, help string, fn func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      name,
+		Help:      help,
+	}, fn)
+}
+
+// RegisterCounterFunc registers a counter whose value is read on every scrape
+func RegisterCounterFunc(subsystem, name, help string, fn func() float64) {
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      name,
+		Help:      help,
+	}, fn)
+}
+
+// RedisHook records the latency of every Redis command and pipeline
+type RedisHook struct{}
+
+func (RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		RedisDuration.WithLabelValues(cmd.Name(), redisOutcome(err)).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+func (RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		RedisDuration.WithLabelValues("pipeline", redisOutcome(err)).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+func redisOutcome(err error) string {
+	switch err {
+	case nil, redis.Nil:
+		return "ok"
+	default:
+		return "error"
+	}
+}
@@ -3,40 +3,67 @@ package middleware
 import (
 	"net/http"
 	"strings"
+
+	"github.com/rs/zerolog"
 )
 
+// DevelopmentAPIKey is the default API_KEY. Configured as the only key it
+// turns authentication off for local development; next to other keys it is
+// never accepted, since its value is public.
+const DevelopmentAPIKey = "development-api-key"
+
 // APIKeyAuth middleware validates the API key from the X-API-Key header
-func APIKeyAuth(apiKey string) func(http.Handler) http.Handler {
+// against any of keys. Empty keys are ignored.
+func APIKeyAuth(keys ...string) func(http.Handler) http.Handler {
+	accepted := make([]string, 0, len(keys))
+	development := false
+	for _, key := range keys {
+		switch key {
+		case "":
+		case DevelopmentAPIKey:
+			development = true
+		default:
+			accepted = append(accepted, key)
+		}
+	}
+	development = development && len(accepted) == 0
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Skip auth for OPTIONS requests (CORS preflight)
-			if r.Method == http.MethodOptions {
+			if r.Method == http.MethodOptions || development {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			// Check header
-			key := r.Header.Get("X-API-Key")
-			if key == "" {
-				// Also check Authorization header as Bearer token
-				auth := r.Header.Get("Authorization")
-				if strings.HasPrefix(auth, "Bearer ") {
-					key = strings.TrimPrefix(auth, "Bearer ")
+			key := APIKeyFromRequest(r)
+			if key != "" {
+				for _, apiKey := range accepted {
+					if key == apiKey {
+						next.ServeHTTP(w, r)
+						return
+					}
 				}
 			}
 
-			// Allow in development mode if no key configured
-			if apiKey == "development-api-key" {
-				next.ServeHTTP(w, r)
-				return
-			}
-
-			if key == "" || key != apiKey {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			next.ServeHTTP(w, r)
+			emitSignal(r, SignalAuthFailure, http.StatusUnauthorized, func(e *zerolog.Event) {
+				e.Bool("key_present", key != "")
+			})
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		})
 	}
 }
+
+// APIKeyFromRequest returns the key sent in X-API-Key or as a bearer token
+func APIKeyFromRequest(r *http.Request) string {
+	// Check header
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		// Also check Authorization header as Bearer token
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimPrefix(auth, "Bearer ")
+		}
+	}
+	return key
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		keys   []string
+		header string
+		method string
+		want   int
+	}{
+		{name: "valid key", keys: []string{"k1", "k2"}, header: "k2", want: http.StatusOK},
+		{name: "bearer token", keys: []string{"k1"}, header: "Bearer k1", want: http.StatusOK},
+		{name: "wrong key", keys: []string{"k1"}, header: "nope", want: http.StatusUnauthorized},
+		{name: "missing key", keys: []string{"k1"}, want: http.StatusUnauthorized},
+		{name: "preflight", keys: []string{"k1"}, method: http.MethodOptions, want: http.StatusOK},
+		{name: "development key alone", keys: []string{DevelopmentAPIKey}, want: http.StatusOK},
+		{name: "development key next to real keys", keys: []string{DevelopmentAPIKey, "k1"}, want: http.StatusUnauthorized},
+		{name: "development key value not accepted", keys: []string{DevelopmentAPIKey, "k1"}, header: DevelopmentAPIKey, want: http.StatusUnauthorized},
+		{name: "empty key never matches", keys: []string{"", "k1"}, want: http.StatusUnauthorized},
+		{name: "only empty keys", keys: []string{""}, want: http.StatusUnauthorized},
+		{name: "empty key with development key", keys: []string{"", DevelopmentAPIKey}, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := APIKeyAuth(tt.keys...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/api/v1/content/locations", nil)
+			switch {
+			case strings.HasPrefix(tt.header, "Bearer "):
+				req.Header.Set("Authorization", tt.header)
+			case tt.header != "":
+				req.Header.Set("X-API-Key", tt.header)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
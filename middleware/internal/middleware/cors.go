@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/go-chi/cors"
+)
+
+// CORSPolicy is the CORS configuration for a group of routes
+type CORSPolicy struct {
+	Name string
+	// Routes are path.Match patterns; a policy without routes matches
+	// every path
+	Routes []string
+
+	// AllowedOrigins are exact origins such as "https://example.com",
+	// wildcard subdomains such as "https://*.example.com", or "*" for any
+	// origin, which cannot be combined with AllowCredentials
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int
+}
+
+// originPattern is a parsed AllowedOrigins entry
+type originPattern struct {
+	scheme string
+	host   string
+	port   string
+	// wildcard matches any subdomain of host, but not host itself
+	wildcard bool
+}
+
+// CORS applies the first policy whose routes match the request path.
+// Requests matching no policy get no CORS headers. It fails on invalid
+// origins and on "*" with credentials.
+func CORS(policies ...CORSPolicy) (func(http.Handler) http.Handler, error) {
+	handlers := make([]func(http.Handler) http.Handler, len(policies))
+	for i, p := range policies {
+		options, err := p.options()
+		if err != nil {
+			return nil, fmt.Errorf("cors policy %s: %w", p.Name, err)
+		}
+		for _, route := range p.Routes {
+			if _, err := path.Match(route, "/"); err != nil {
+				return nil, fmt.Errorf("cors policy %s: bad route pattern %q: %w", p.Name, route, err)
+			}
+		}
+		handlers[i] = cors.Handler(options)
+	}
+
+	return func(next http.Handler) http.Handler {
+		wrapped := make([]http.Handler, len(handlers))
+		for i, h := range handlers {
+			wrapped[i] = h(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for i, p := range policies {
+				if p.matches(r.URL.Path) {
+					wrapped[i].ServeHTTP(w, r)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+func (p CORSPolicy) matches(urlPath string) bool {
+	if len(p.Routes) == 0 {
+		return true
+	}
+	for _, route := range p.Routes {
+		if ok, _ := path.Match(route, urlPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p CORSPolicy) options() (cors.Options, error) {
+	options := cors.Options{
+		AllowedMethods:   p.AllowedMethods,
+		AllowedHeaders:   p.AllowedHeaders,
+		ExposedHeaders:   p.ExposedHeaders,
+		AllowCredentials: p.AllowCredentials,
+		MaxAge:           p.MaxAge,
+	}
+
+	var patterns []originPattern
+	for _, origin := range p.AllowedOrigins {
+		origin = strings.TrimSpace(origin)
+		switch origin {
+		case "":
+			continue
+		case "*":
+			if p.AllowCredentials {
+				return cors.Options{}, fmt.Errorf(`origin "*" cannot be used with credentials`)
+			}
+			options.AllowedOrigins = []string{"*"}
+			return options, nil
+		}
+
+		pattern, err := parseOriginPattern(origin)
+		if err != nil {
+			return cors.Options{}, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	if len(patterns) == 0 {
+		return cors.Options{}, fmt.Errorf("no allowed origins")
+	}
+
+	options.AllowOriginFunc = func(r *http.Request, origin string) bool {
+		for _, pattern := range patterns {
+			if pattern.matches(origin) {
+				return true
+			}
+		}
+		return false
+	}
+	return options, nil
+}
+
+// parseOriginPattern accepts scheme://host[:port], where host may start
+// with "*." to allow any subdomain
+func parseOriginPattern(origin string) (originPattern, error) {
+	wildcard := false
+	raw := origin
+	if scheme, rest, found := strings.Cut(origin, "://*."); found {
+		wildcard = true
+		raw = scheme + "://" + rest
+	}
+
+	u, err := url.Parse(strings.ToLower(raw))
+	if err != nil {
+		return originPattern{}, fmt.Errorf("invalid origin %q: %w", origin, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return originPattern{}, fmt.Errorf("invalid origin %q: scheme must be http or https", origin)
+	}
+	if u.Hostname() == "" || strings.Contains(u.Host, "*") || u.User != nil ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return originPattern{}, fmt.Errorf("invalid origin %q: expected scheme://host[:port], optionally with a leading *. label", origin)
+	}
+
+	return originPattern{
+		scheme:   u.Scheme,
+		host:     u.Hostname(),
+		port:     u.Port(),
+		wildcard: wildcard,
+	}, nil
+}
+
+func (p originPattern) matches(origin string) bool {
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Scheme != p.scheme || u.Port() != p.port {
+		return false
+	}
+
+	host := u.Hostname()
+	if p.wildcard {
+		return strings.HasSuffix(host, "."+p.host) && len(host) > len(p.host)+1
+	}
+	return host == p.host
+}
@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOriginPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{"https://clayworks.in", "https://clayworks.in", true},
+		{"https://clayworks.in", "HTTPS://ClayWorks.in", true},
+		{"https://clayworks.in", "http://clayworks.in", false},
+		{"https://clayworks.in", "https://clayworks.in:8443", false},
+		{"https://clayworks.in", "https://clayworks.in.evil.com", false},
+		{"http://localhost:3000", "http://localhost:3000", true},
+		{"http://localhost:3000", "http://localhost:3001", false},
+		{"https://*.clayworks.in", "https://preview.clayworks.in", true},
+		{"https://*.clayworks.in", "https://a.b.clayworks.in", true},
+		{"https://*.clayworks.in", "https://clayworks.in", false},
+		{"https://*.clayworks.in", "https://evilclayworks.in", false},
+		{"https://*.clayworks.in", "https://.clayworks.in", false},
+		{"https://*.clayworks.in", "http://preview.clayworks.in", false},
+		{"https://clayworks.in", "null", false},
+	}
+
+	for _, tt := range tests {
+		pattern, err := parseOriginPattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("parseOriginPattern(%q) error = %v", tt.pattern, err)
+		}
+		if got := pattern.matches(tt.origin); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestParseOriginPatternErrors(t *testing.T) {
+	for _, origin := range []string{
+		"clayworks.in",
+		"ftp://clayworks.in",
+		"https://clay*.in",
+		"https://clayworks.in/path",
+		"https://clayworks.in?q=1",
+		"https://user@clayworks.in",
+		"https://",
+	} {
+		if _, err := parseOriginPattern(origin); err == nil {
+			t.Errorf("parseOriginPattern(%q) error = nil", origin)
+		}
+	}
+}
+
+func TestCORSPolicies(t *testing.T) {
+	handler, err := CORS(
+		CORSPolicy{
+			Name:           "analytics",
+			Routes:         []string{"/api/v1/analytics/*"},
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{http.MethodPost},
+		},
+		CORSPolicy{
+			Name:             "api",
+			AllowedOrigins:   []string{"https://clayworks.in", "https://*.clayworks.in"},
+			AllowedMethods:   []string{http.MethodGet, http.MethodPost},
+			AllowCredentials: true,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name        string
+		path        string
+		origin      string
+		allowOrigin string
+		credentials string
+	}{
+		{name: "analytics any origin", path: "/api/v1/analytics/events", origin: "https://partner.example", allowOrigin: "*"},
+		{name: "api exact origin", path: "/api/v1/content/locations", origin: "https://clayworks.in", allowOrigin: "https://clayworks.in", credentials: "true"},
+		{name: "api subdomain", path: "/api/v1/forms/contact", origin: "https://preview.clayworks.in", allowOrigin: "https://preview.clayworks.in", credentials: "true"},
+		{name: "api foreign origin", path: "/api/v1/forms/contact", origin: "https://partner.example"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allowOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != tt.credentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.credentials)
+			}
+		})
+	}
+}
+
+func TestCORSInvalidPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy CORSPolicy
+	}{
+		{"wildcard with credentials", CORSPolicy{Name: "api", AllowedOrigins: []string{"*"}, AllowCredentials: true}},
+		{"no origins", CORSPolicy{Name: "api", AllowedOrigins: []string{" "}}},
+		{"bad origin", CORSPolicy{Name: "api", AllowedOrigins: []string{"clayworks.in"}}},
+		{"bad route", CORSPolicy{Name: "api", Routes: []string{"/["}, AllowedOrigins: []string{"https://clayworks.in"}}},
+	}
+
+	for _, tt := range tests {
+		if _, err := CORS(tt.policy); err == nil {
+			t.Errorf("%s: CORS() error = nil", tt.name)
+		}
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	h := SecurityHeaders(SecurityHeadersConfig{
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		ContentSecurityPolicy: "default-src 'none'",
+		FrameOptions:          "DENY",
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Handlers may override the defaults
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	for header, want := range map[string]string{
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		"X-Content-Type-Options":    "nosniff",
+		"Content-Security-Policy":   "default-src 'none'",
+		"X-Frame-Options":           "SAMEORIGIN",
+		"Referrer-Policy":           "",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/clayworks/middleware/internal/metrics"
+)
+
+// Remediator returns the CrowdSec remediation for a client address: "" to
+// allow, "ban" or "captcha"
+type Remediator interface {
+	Remediation(ctx context.Context, ip string) string
+}
+
+// CrowdSec refuses requests from addresses with a CrowdSec decision. Banned
+// clients get 403; clients under a captcha decision get 403 with
+// X-CrowdSec-Remediation: captcha so the frontend can show a challenge.
+func CrowdSec(bouncer Remediator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			remediation := bouncer.Remediation(r.Context(), ClientIP(r))
+			if remediation == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			metrics.CrowdSecBlocks.WithLabelValues(remediation).Inc()
+			w.Header().Set("X-CrowdSec-Remediation", remediation)
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Logger is a middleware that logs each request using zerolog
@@ -15,13 +16,20 @@ func Logger(next http.Handler) http.Handler {
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 
 		defer func() {
-			log.Info().
+			event := log.Info()
+			if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+				event = event.
+					Str("trace_id", sc.TraceID().String()).
+					Str("span_id", sc.SpanID().String())
+			}
+
+			event.
 				Str("method", r.Method).
 				Str("path", r.URL.Path).
 				Int("status", ww.Status()).
 				Int("bytes", ww.BytesWritten()).
 				Dur("duration", time.Since(start)).
-				Str("ip", r.RemoteAddr).
+				Str("ip", ClientIP(r)).
 				Str("request_id", middleware.GetReqID(r.Context())).
 				Msg("Request completed")
 		}()
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/clayworks/middleware/internal/metrics"
+	"github.com/go-chi/httprate"
+	"github.com/rs/zerolog"
+)
+
+// RateLimitExceeded returns a limit handler that counts the rejection for the
+// named limiter before responding 429
+func RateLimitExceeded(limiter string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RateLimitRejections.WithLabelValues(limiter).Inc()
+		emitSignal(r, SignalRateLimited, http.StatusTooManyRequests, func(e *zerolog.Event) {
+			e.Str("limiter", limiter)
+		})
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	}
+}
+
+// RateLimit limits each key (client IP by default) to limit requests per
+// sliding window, counted by counter (nil counts in process memory).
+// Responses carry the IETF RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset and RateLimit-Policy headers.
+func RateLimit(name string, limit int, window time.Duration, counter httprate.LimitCounter, keyFuncs ...httprate.KeyFunc) func(http.Handler) http.Handler {
+	if len(keyFuncs) == 0 {
+		keyFuncs = []httprate.KeyFunc{KeyByClientIP}
+	}
+
+	options := []httprate.Option{
+		httprate.WithKeyFuncs(keyFuncs...),
+		httprate.WithLimitHandler(RateLimitExceeded(name)),
+		// httprate reports the reset as a timestamp; the standard header is
+		// delta-seconds, set below
+		httprate.WithResponseHeaders(httprate.ResponseHeaders{
+			Limit:      "RateLimit-Limit",
+			Remaining:  "RateLimit-Remaining",
+			RetryAfter: "Retry-After",
+		}),
+	}
+	if counter != nil {
+		options = append(options, httprate.WithLimitCounter(counter))
+	}
+	limiter := httprate.Limit(limit, window, options...)
+
+	policy := fmt.Sprintf("%d;w=%d", limit, int(window.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		limited := limiter(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Windows are aligned the same way httprate aligns them
+			now := time.Now().UTC()
+			reset := now.Truncate(window).Add(window).Sub(now)
+
+			w.Header().Set("RateLimit-Policy", policy)
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(int(reset.Round(time.Second).Seconds())))
+			limited.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/go-chi/httprate"
+	"github.com/rs/zerolog/log"
+)
+
+// Rate limit counting keys
+const (
+	RateLimitKeyIP       = "ip"
+	RateLimitKeyAPIKey   = "api_key"
+	RateLimitKeyIPAPIKey = "ip+api_key"
+)
+
+// defaultBurstWindow applies when a policy sets burst without burst_window
+const defaultBurstWindow = time.Second
+
+// RateLimitPolicy is one row of the policy table. A request uses the first
+// policy whose every non-empty match list matches it.
+type RateLimitPolicy struct {
+	Name string `json:"name"`
+
+	// Routes are path.Match patterns, e.g. "/api/v1/analytics/*"
+	Routes []string `json:"routes,omitempty"`
+	// APIKeys are identity names from API_KEYS ("default" is API_KEY)
+	APIKeys []string `json:"api_keys,omitempty"`
+	// CIDRs are client networks or single addresses
+	CIDRs []string `json:"cidrs,omitempty"`
+
+	// Exempt requests are never limited
+	Exempt bool `json:"exempt,omitempty"`
+
+	Limit  int      `json:"limit"`
+	Window Duration `json:"window"`
+	// Burst caps requests within BurstWindow on top of Limit per Window
+	Burst       int      `json:"burst,omitempty"`
+	BurstWindow Duration `json:"burst_window,omitempty"`
+
+	// Key selects what is counted: "ip" (default), "api_key" or "ip+api_key"
+	Key string `json:"key,omitempty"`
+
+	networks []*net.IPNet
+}
+
+// Duration reads "1m"-style durations from JSON
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// LoadRateLimitPolicies reads a JSON array of policies from file, or returns
+// defaults when file is empty
+func LoadRateLimitPolicies(file string, defaults []RateLimitPolicy) ([]RateLimitPolicy, error) {
+	policies := defaults
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		policies = nil
+		if err := json.Unmarshal(data, &policies); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", file, err)
+		}
+	}
+
+	for i := range policies {
+		if err := policies[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	return policies, nil
+}
+
+func (p *RateLimitPolicy) compile() error {
+	if p.Name == "" {
+		return fmt.Errorf("rate limit policy without a name")
+	}
+
+	for _, route := range p.Routes {
+		if _, err := path.Match(route, "/"); err != nil {
+			return fmt.Errorf("policy %s: bad route pattern %q: %w", p.Name, route, err)
+		}
+	}
+
+	networks, err := ParseNetworks(p.CIDRs)
+	if err != nil {
+		return fmt.Errorf("policy %s: %w", p.Name, err)
+	}
+	p.networks = networks
+
+	if p.Exempt {
+		return nil
+	}
+	if p.Limit <= 0 || p.Window <= 0 {
+		return fmt.Errorf("policy %s: limit and window are required unless exempt", p.Name)
+	}
+	if p.Burst > 0 && p.BurstWindow <= 0 {
+		p.BurstWindow = Duration(defaultBurstWindow)
+	}
+
+	switch p.Key {
+	case "":
+		p.Key = RateLimitKeyIP
+	case RateLimitKeyIP, RateLimitKeyAPIKey, RateLimitKeyIPAPIKey:
+	default:
+		return fmt.Errorf("policy %s: unknown key %q", p.Name, p.Key)
+	}
+	return nil
+}
+
+func (p *RateLimitPolicy) matches(r *http.Request, ip net.IP, identity string) bool {
+	if len(p.Routes) > 0 && !matchAny(p.Routes, func(route string) bool {
+		ok, _ := path.Match(route, r.URL.Path)
+		return ok
+	}) {
+		return false
+	}
+
+	if len(p.APIKeys) > 0 && !matchAny(p.APIKeys, func(name string) bool {
+		return name == identity
+	}) {
+		return false
+	}
+
+	if len(p.networks) > 0 && (ip == nil || !containsIP(p.networks, ip)) {
+		return false
+	}
+
+	return true
+}
+
+func matchAny(values []string, match func(string) bool) bool {
+	for _, value := range values {
+		if match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimitPolicies applies the first matching policy to each request.
+// apiKeys maps key values to identity names; newCounter returns the counter
+// for a named limiter (nil counts in process memory).
+func RateLimitPolicies(policies []RateLimitPolicy, apiKeys map[string]string, newCounter func(name string) httprate.LimitCounter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limited := make([]http.Handler, len(policies))
+		for i, p := range policies {
+			if p.Exempt {
+				limited[i] = next
+				continue
+			}
+
+			keyFn := rateLimitKeyFunc(p.Key, apiKeys)
+			h := RateLimit(p.Name, p.Limit, time.Duration(p.Window), newCounter(p.Name), keyFn)(next)
+			if p.Burst > 0 {
+				// The burst check runs first so its headers are overwritten
+				// by the main window's
+				burstName := p.Name + ":burst"
+				h = RateLimit(burstName, p.Burst, time.Duration(p.BurstWindow), newCounter(burstName), keyFn)(h)
+			}
+			limited[i] = h
+
+			log.Info().
+				Str("policy", p.Name).
+				Int("limit", p.Limit).
+				Dur("window", time.Duration(p.Window)).
+				Int("burst", p.Burst).
+				Str("key", p.Key).
+				Msg("Rate limit policy loaded")
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIPFromRequest(r)
+			identity, _ := apiKeyIdentity(r, apiKeys)
+
+			for i := range policies {
+				if policies[i].matches(r, ip, identity) {
+					limited[i].ServeHTTP(w, r)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKeyFunc counts by IP, by API key identity or both. Requests
+// without a known key are counted by IP under the api_key modes.
+func rateLimitKeyFunc(mode string, apiKeys map[string]string) httprate.KeyFunc {
+	return func(r *http.Request) (string, error) {
+		ip, err := KeyByClientIP(r)
+		if err != nil {
+			return "", err
+		}
+
+		identity, known := apiKeyIdentity(r, apiKeys)
+		switch {
+		case mode == RateLimitKeyAPIKey && known:
+			return "key:" + identity, nil
+		case mode == RateLimitKeyIPAPIKey && known:
+			return "key:" + identity + ":" + ip, nil
+		default:
+			return ip, nil
+		}
+	}
+}
+
+// apiKeyIdentity returns the identity name of the request's API key. A
+// request without a key has no identity.
+func apiKeyIdentity(r *http.Request, apiKeys map[string]string) (string, bool) {
+	key := APIKeyFromRequest(r)
+	if key == "" {
+		return "", false
+	}
+	identity, ok := apiKeys[key]
+	return identity, ok
+}
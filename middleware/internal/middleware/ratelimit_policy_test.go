@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+func TestRateLimitPolicyMatching(t *testing.T) {
+	policies, err := LoadRateLimitPolicies("", []RateLimitPolicy{
+		{Name: "office", CIDRs: []string{"203.0.113.0/24"}, Exempt: true},
+		{Name: "nextjs", APIKeys: []string{"nextjs"}, Limit: 2000, Window: Duration(time.Minute), Key: RateLimitKeyAPIKey},
+		{Name: "default-key", APIKeys: []string{"default"}, Limit: 500, Window: Duration(time.Minute)},
+		{Name: "analytics", Routes: []string{"/api/v1/analytics/*"}, Limit: 100, Window: Duration(time.Minute)},
+		{Name: "applications", Routes: []string{"/api/v1/jobs/*/applications"}, Limit: 5, Window: Duration(10 * time.Minute)},
+		{Name: "global", Limit: 60, Window: Duration(time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities := map[string]string{"next-secret": "nextjs", "main-secret": "default"}
+	handler := RateLimitPolicies(policies, identities, func(string) httprate.LimitCounter { return nil })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name   string
+		path   string
+		ip     string
+		key    string
+		policy string // RateLimit-Policy header, empty when not limited
+	}{
+		{name: "office exempt", path: "/api/v1/analytics/events", ip: "203.0.113.9", key: "next-secret"},
+		{name: "named key", path: "/api/v1/analytics/events", ip: "198.51.100.1", key: "next-secret", policy: "2000;w=60"},
+		{name: "default key", path: "/api/v1/content/locations", ip: "198.51.100.1", key: "main-secret", policy: "500;w=60"},
+		{name: "unknown key is anonymous", path: "/api/v1/content/locations", ip: "198.51.100.1", key: "guess", policy: "60;w=60"},
+		{name: "no key is not default", path: "/api/v1/content/locations", ip: "198.51.100.1", policy: "60;w=60"},
+		{name: "route", path: "/api/v1/analytics/beacon", ip: "198.51.100.1", policy: "100;w=60"},
+		{name: "route wildcard segment", path: "/api/v1/jobs/designer/applications", ip: "198.51.100.1", policy: "5;w=600"},
+		{name: "wildcard stays in segment", path: "/api/v1/analytics/reports/events", ip: "198.51.100.1", policy: "60;w=60"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.ip + ":1234"
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if got := rec.Header().Get("RateLimit-Policy"); got != tt.policy {
+				t.Errorf("RateLimit-Policy = %q, want %q", got, tt.policy)
+			}
+		})
+	}
+}
+
+func TestRateLimitPolicyLimits(t *testing.T) {
+	policies, err := LoadRateLimitPolicies("", []RateLimitPolicy{
+		{Name: "shared", APIKeys: []string{"nextjs"}, Limit: 2, Window: Duration(time.Hour), Key: RateLimitKeyAPIKey},
+		{Name: "burst", Limit: 100, Window: Duration(time.Hour), Burst: 1, BurstWindow: Duration(time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := RateLimitPolicies(policies, map[string]string{"next-secret": "nextjs"}, func(string) httprate.LimitCounter { return nil })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(ip, key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// The api_key budget is shared by every address using the key
+	for i, ip := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"} {
+		want := http.StatusOK
+		if i == 2 {
+			want = http.StatusTooManyRequests
+		}
+		if got := do(ip, "next-secret"); got != want {
+			t.Errorf("shared request %d = %d, want %d", i+1, got, want)
+		}
+	}
+
+	// The burst limit applies per address on top of the main limit
+	if got := do("192.0.2.1", ""); got != http.StatusOK {
+		t.Errorf("first burst request = %d, want 200", got)
+	}
+	if got := do("192.0.2.1", ""); got != http.StatusTooManyRequests {
+		t.Errorf("second burst request = %d, want 429", got)
+	}
+	if got := do("192.0.2.2", ""); got != http.StatusOK {
+		t.Errorf("other address = %d, want 200", got)
+	}
+}
+
+func TestLoadRateLimitPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr bool
+	}{
+		{name: "valid", json: `[{"name": "global", "limit": 10, "window": "1m", "burst": 5}]`},
+		{name: "exempt without limit", json: `[{"name": "office", "cidrs": ["10.0.0.1"], "exempt": true}]`},
+		{name: "missing name", json: `[{"limit": 10, "window": "1m"}]`, wantErr: true},
+		{name: "missing limit", json: `[{"name": "global", "window": "1m"}]`, wantErr: true},
+		{name: "bad window", json: `[{"name": "global", "limit": 10, "window": "soon"}]`, wantErr: true},
+		{name: "bad route", json: `[{"name": "global", "routes": ["/["], "limit": 10, "window": "1m"}]`, wantErr: true},
+		{name: "bad cidr", json: `[{"name": "global", "cidrs": ["10.0.0.0/99"], "limit": 10, "window": "1m"}]`, wantErr: true},
+		{name: "unknown key", json: `[{"name": "global", "limit": 10, "window": "1m", "key": "cookie"}]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "policies.json")
+			if err := os.WriteFile(file, []byte(tt.json), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			policies, err := LoadRateLimitPolicies(file, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadRateLimitPolicies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !policies[0].Exempt {
+				if policies[0].Key != RateLimitKeyIP {
+					t.Errorf("Key = %q, want default %q", policies[0].Key, RateLimitKeyIP)
+				}
+				if policies[0].Burst > 0 && policies[0].BurstWindow != Duration(defaultBurstWindow) {
+					t.Errorf("BurstWindow = %v, want default", policies[0].BurstWindow)
+				}
+			}
+		})
+	}
+}
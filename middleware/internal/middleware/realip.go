@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// RealIP resolves the client address and stores it in the request context.
+// X-Forwarded-For is only honoured when the connection comes from one of
+// trustedProxies, and is read right to left, stopping at the first hop that
+// is not a trusted proxy, so clients cannot spoof their address by sending
+// the header themselves.
+func RealIP(trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := resolveClientIP(r, trustedProxies)
+			ctx := context.WithValue(r.Context(), clientIPKey{}, ip)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientIP returns the client address resolved by RealIP, or the connection's
+// peer address when RealIP did not run
+func ClientIP(r *http.Request) string {
+	if ip := clientIPFromRequest(r); ip != nil {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
+
+// KeyByClientIP is an httprate.KeyFunc for the address resolved by RealIP.
+// Like httprate.KeyByIP it counts IPv6 clients per /64, since a single host
+// usually controls a whole /64.
+func KeyByClientIP(r *http.Request) (string, error) {
+	ip := clientIPFromRequest(r)
+	if ip == nil {
+		return "", fmt.Errorf("no client address in %q", r.RemoteAddr)
+	}
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String(), nil
+	}
+	return ip.String(), nil
+}
+
+func clientIPFromRequest(r *http.Request) net.IP {
+	if ip, ok := r.Context().Value(clientIPKey{}).(net.IP); ok && ip != nil {
+		return ip
+	}
+	return parseHost(r.RemoteAddr)
+}
+
+func resolveClientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	client := parseHost(r.RemoteAddr)
+	if client == nil || !containsIP(trustedProxies, client) {
+		return client
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if len(hops) == 0 {
+		if ip := parseHost(r.Header.Get("X-Real-IP")); ip != nil {
+			return ip
+		}
+		return client
+	}
+
+	// Each trusted proxy appended the address it received the request from
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHost(hops[i])
+		if ip == nil {
+			// A malformed hop cannot be trusted further; keep the last
+			// proxy that was
+			break
+		}
+		client = ip
+		if !containsIP(trustedProxies, ip) {
+			break
+		}
+	}
+	return client
+}
+
+// parseHost parses an address with or without a port
+func parseHost(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.Trim(addr, "[]"))
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetworks parses CIDRs, treating a bare address as a single host.
+// Empty entries are skipped.
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParseNetworks([]string{"10.0.0.0/8", "192.0.2.1", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{name: "direct client", remoteAddr: "198.51.100.7:5000", want: "198.51.100.7"},
+		{name: "untrusted peer spoofing", remoteAddr: "198.51.100.7:5000", forwarded: []string{"1.2.3.4"}, want: "198.51.100.7"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.2:80", forwarded: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "client prepends a fake hop", remoteAddr: "10.0.0.2:80", forwarded: []string{"1.2.3.4, 198.51.100.7"}, want: "198.51.100.7"},
+		{name: "proxy chain", remoteAddr: "10.0.0.2:80", forwarded: []string{"198.51.100.7, 192.0.2.1, 10.1.1.1"}, want: "198.51.100.7"},
+		{name: "repeated headers", remoteAddr: "10.0.0.2:80", forwarded: []string{"198.51.100.7", "10.1.1.1"}, want: "198.51.100.7"},
+		{name: "all hops trusted", remoteAddr: "10.0.0.2:80", forwarded: []string{"10.1.1.1"}, want: "10.1.1.1"},
+		{name: "malformed hop", remoteAddr: "10.0.0.2:80", forwarded: []string{"198.51.100.7, junk, 10.1.1.1"}, want: "10.1.1.1"},
+		{name: "hop with port", remoteAddr: "10.0.0.2:80", forwarded: []string{"198.51.100.7:1234"}, want: "198.51.100.7"},
+		{name: "X-Real-IP from trusted proxy", remoteAddr: "10.0.0.2:80", realIP: "198.51.100.7", want: "198.51.100.7"},
+		{name: "X-Real-IP from untrusted peer", remoteAddr: "198.51.100.9:80", realIP: "1.2.3.4", want: "198.51.100.9"},
+		{name: "IPv6 proxy", remoteAddr: "[fd00::1]:80", forwarded: []string{"2001:db8::7"}, want: "2001:db8::7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyByClientIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		want       string
+		wantErr    bool
+	}{
+		{remoteAddr: "198.51.100.7:5000", want: "198.51.100.7"},
+		{remoteAddr: "[2001:db8:1:2:3:4:5:6]:443", want: "2001:db8:1:2::"},
+		{remoteAddr: "pipe", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		got, err := KeyByClientIP(req)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("KeyByClientIP(%q) = %q, %v, want %q", tt.remoteAddr, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	tests := []struct {
+		cidrs   []string
+		want    []string
+		wantErr bool
+	}{
+		{cidrs: []string{"10.0.0.0/8", " 192.0.2.1 ", "", "2001:db8::1"}, want: []string{"10.0.0.0/8", "192.0.2.1/32", "2001:db8::1/128"}},
+		{cidrs: []string{"10.0.0.0/33"}, wantErr: true},
+		{cidrs: []string{"not-an-ip"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		networks, err := ParseNetworks(tt.cidrs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseNetworks(%q) error = %v, wantErr %v", tt.cidrs, err, tt.wantErr)
+			continue
+		}
+		if len(networks) != len(tt.want) {
+			t.Errorf("ParseNetworks(%q) = %v, want %v", tt.cidrs, networks, tt.want)
+			continue
+		}
+		for i, network := range networks {
+			if network.String() != tt.want[i] {
+				t.Errorf("ParseNetworks(%q)[%d] = %s, want %s", tt.cidrs, i, network, tt.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+)
+
+// Security signals written for CrowdSec
+const (
+	SignalAuthFailure = "auth_failure"
+	SignalRateLimited = "rate_limited"
+)
+
+// signalLog writes one JSON object per line, parsed by the
+// clayworks/gateway-logs CrowdSec parser
+var signalLog = zerolog.Nop()
+
+// SetSignalOutput enables security signals, written to w
+func SetSignalOutput(w io.Writer) {
+	signalLog = zerolog.New(w)
+}
+
+// emitSignal records a security-relevant response for r. fields adds
+// event-specific fields and may be nil.
+func emitSignal(r *http.Request, event string, status int, fields func(*zerolog.Event)) {
+	e := signalLog.Log()
+	if e == nil {
+		return
+	}
+
+	e.
+		Str("time", time.Now().UTC().Format(time.RFC3339)).
+		Str("service", "clayworks-gateway").
+		Str("event", event).
+		Str("source_ip", ClientIP(r)).
+		Str("method", r.Method).
+		Str("path", r.URL.Path).
+		Int("status", status).
+		Str("user_agent", r.UserAgent()).
+		Str("request_id", middleware.GetReqID(r.Context()))
+	if fields != nil {
+		fields(e)
+	}
+	e.Send()
+}
@@ -12,6 +12,31 @@ type AnalyticsEvent struct {
 	UserID     string                 `json:"user_id,omitempty"`
 	Timestamp  time.Time              `json:"timestamp,omitempty"`
 	Properties map[string]interface{} `json:"properties,omitempty"`
+
+	// Client is populated by the gateway from the inbound request and is
+	// never accepted from the request body.
+	Client ClientContext `json:"-"`
+}
+
+// ClientContext carries request-level signals used for privacy enforcement
+type ClientContext struct {
+	IP        string
+	UserAgent string
+	DNT       bool
+	GPC       bool
+	Consent   Consent
+
+	// Bot is the bot classification assigned by the gateway, empty for
+	// regular browsers
+	Bot string
+}
+
+// Consent records which tracking categories the visitor has opted into.
+// Present is false when the request carried no consent signal at all.
+type Consent struct {
+	Present   bool
+	Analytics bool
+	Marketing bool
 }
 
 // AnalyticsEventBatch represents multiple events to be processed
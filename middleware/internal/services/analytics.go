@@ -1,6 +1,13 @@
 package services
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/clayworks/middleware/internal/config"
@@ -8,17 +15,36 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// ErrAnalyticsQueueFull is returned when an event is dropped because the
+// delivery queue is saturated
+var ErrAnalyticsQueueFull = errors.New("analytics queue full")
+
 // AnalyticsService provides an extensible interface for analytics integrations.
-// Currently supports basic event logging, with hooks for Google Analytics, Mixpanel, etc.
+// Events are filtered for privacy, then delivered asynchronously to every
+// enabled provider by a small worker pool.
 type AnalyticsService struct {
 	googleAnalyticsID string
 	providers         []AnalyticsProvider
+	privacy           *PrivacyFilter
+	queue             chan models.AnalyticsEvent
+	wg                sync.WaitGroup
+	store             *AnalyticsStore
+	stop              chan struct{}
+	bots              *BotFilter
+	botPolicy         string
+	botPolicies       map[string]string
+
+	// Delivery runs under baseCtx so Close can abandon in-flight provider
+	// calls once its own deadline passes
+	baseCtx         context.Context
+	cancel          context.CancelFunc
+	providerTimeout time.Duration
 }
 
 // AnalyticsProvider interface for pluggable analytics backends
 type AnalyticsProvider interface {
 	Name() string
-	Track(event models.AnalyticsEvent) error
+	Track(ctx context.Context, event models.AnalyticsEvent) error
 	IsEnabled() bool
 }
 
@@ -26,7 +52,15 @@ func NewAnalyticsService(cfg *config.Config) *AnalyticsService {
 	svc := &AnalyticsService{
 		googleAnalyticsID: cfg.GoogleAnalyticsID,
 		providers:         make([]AnalyticsProvider, 0),
+		privacy:           NewPrivacyFilter(cfg),
+		queue:             make(chan models.AnalyticsEvent, cfg.AnalyticsQueueSize),
+		stop:              make(chan struct{}),
+		bots:              NewBotFilter(cfg.AnalyticsBotListFile),
+		botPolicy:         cfg.AnalyticsBotPolicy,
+		botPolicies:       cfg.AnalyticsBotProviderPolicies,
+		providerTimeout:   cfg.AnalyticsProviderTimeout,
 	}
+	svc.baseCtx, svc.cancel = context.WithCancel(context.Background())
 
 	// Register providers based on configuration
 	if cfg.GoogleAnalyticsID != "" {
@@ -37,19 +71,166 @@ func NewAnalyticsService(cfg *config.Config) *AnalyticsService {
 	// Add console logger for development
 	svc.providers = append(svc.providers, &ConsoleProvider{})
 
+	// Each delivery is bounded by providerTimeout through its context
+	httpClient := &http.Client{}
+
+	if cfg.MixpanelToken != "" {
+		if cfg.MixpanelAPISecret == "" {
+			log.Warn().Msg("MIXPANEL_TOKEN is set without MIXPANEL_API_SECRET, Mixpanel provider disabled")
+		} else {
+			svc.providers = append(svc.providers, NewMixpanelProvider(cfg, httpClient))
+		}
+	}
+
+	if cfg.PostHogAPIKey != "" {
+		svc.providers = append(svc.providers, NewPostHogProvider(cfg, httpClient))
+	}
+
+	if cfg.AnalyticsStoreDir != "" {
+		store, err := NewAnalyticsStore(cfg.AnalyticsStoreDir, cfg.AnalyticsRawRetention)
+		if err != nil {
+			log.Error().Err(err).Str("dir", cfg.AnalyticsStoreDir).Msg("First-party analytics storage disabled")
+		} else {
+			svc.store = store
+			svc.providers = append(svc.providers, NewFirstPartyProvider(store))
+			go store.RunRollups(svc.stop)
+		}
+	}
+
+	if cfg.AnalyticsWebhookURL != "" {
+		webhook, err := NewWebhookProvider(cfg, httpClient)
+		if err != nil {
+			log.Error().Err(err).Msg("Webhook analytics provider disabled")
+		} else {
+			svc.providers = append(svc.providers, webhook)
+		}
+	}
+
+	for _, provider := range svc.providers {
+		if provider.IsEnabled() {
+			log.Info().Str("provider", provider.Name()).Msg("Analytics provider enabled")
+		}
+	}
+
+	go svc.bots.Watch(cfg.AnalyticsBotReloadInterval, svc.stop)
+
+	workers := cfg.AnalyticsWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		svc.wg.Add(1)
+		go svc.worker()
+	}
+
 	return svc
 }
 
+// ClientContext extracts consent, DNT/GPC and client details from a request
+// made from clientIP
+func (s *AnalyticsService) ClientContext(r *http.Request, clientIP string) models.ClientContext {
+	return s.privacy.ClientContext(r, clientIP)
+}
+
 func (s *AnalyticsService) TrackEvent(event models.AnalyticsEvent) error {
 	// Enrich event with timestamp
 	if event.Timestamp.IsZero() {
 		event.Timestamp = time.Now()
 	}
 
+	// Enforce consent and strip personal data before any provider sees it
+	if allowed, reason := s.privacy.Allow(event); !allowed {
+		log.Debug().Str("event", event.Name).Str("reason", reason).Msg("Analytics event dropped")
+		return nil
+	}
+	s.privacy.Apply(&event)
+
+	event.Client.Bot = s.bots.Classify(event.Client.UserAgent)
+	s.bots.recordEvent(event.Client.Bot)
+
+	select {
+	case s.queue <- event:
+		return nil
+	default:
+		log.Warn().Str("event", event.Name).Msg("Analytics queue full, dropping event")
+		return ErrAnalyticsQueueFull
+	}
+}
+
+// QueueDepth returns the number of events waiting for delivery
+func (s *AnalyticsService) QueueDepth() int {
+	return len(s.queue)
+}
+
+// BotStats reports bot traffic classified and filtered since startup
+func (s *AnalyticsService) BotStats() BotStats {
+	return s.bots.Stats()
+}
+
+// Store returns the first-party event store, or nil when it is disabled
+func (s *AnalyticsService) Store() *AnalyticsStore {
+	return s.store
+}
+
+// SaltRotation is how long a hashed user or session ID stays the same
+func (s *AnalyticsService) SaltRotation() time.Duration {
+	return s.privacy.rotation
+}
+
+// Close stops accepting events and waits for queued events to be delivered.
+// If ctx expires first, in-flight deliveries are cancelled and the remaining
+// queue is dropped.
+func (s *AnalyticsService) Close(ctx context.Context) {
+	close(s.queue)
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn().Int("pending", len(s.queue)).Msg("Analytics shutdown deadline reached, dropping queued events")
+		s.cancel()
+		<-done
+	}
+	s.cancel()
+
+	close(s.stop)
+	if s.store != nil {
+		s.store.Close()
+	}
+}
+
+func (s *AnalyticsService) worker() {
+	defer s.wg.Done()
+	for event := range s.queue {
+		if s.baseCtx.Err() != nil {
+			continue
+		}
+		s.dispatch(event)
+	}
+}
+
+func (s *AnalyticsService) dispatch(event models.AnalyticsEvent) {
 	// Send to all enabled providers
 	for _, provider := range s.providers {
 		if provider.IsEnabled() {
-			if err := provider.Track(event); err != nil {
+			out := event
+			if event.Client.Bot != BotClassNone {
+				switch s.botPolicyFor(provider.Name()) {
+				case BotPolicyAllow:
+				case BotPolicyTag:
+					out = tagBotEvent(event)
+				default:
+					s.bots.recordFiltered(provider.Name())
+					continue
+				}
+			}
+
+			if err := s.deliver(provider, out); err != nil {
 				log.Error().
 					Err(err).
 					Str("provider", provider.Name()).
@@ -58,8 +239,17 @@ func (s *AnalyticsService) TrackEvent(event models.AnalyticsEvent) error {
 			}
 		}
 	}
+}
 
-	return nil
+// deliver sends one event to provider within the per-provider timeout
+func (s *AnalyticsService) deliver(provider AnalyticsProvider, event models.AnalyticsEvent) error {
+	ctx := s.baseCtx
+	if s.providerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.providerTimeout)
+		defer cancel()
+	}
+	return provider.Track(ctx, event)
 }
 
 func (s *AnalyticsService) TrackPageView(path, referrer, userAgent, sessionID string) error {
@@ -75,6 +265,27 @@ func (s *AnalyticsService) TrackPageView(path, referrer, userAgent, sessionID st
 	})
 }
 
+func (s *AnalyticsService) botPolicyFor(provider string) string {
+	if policy, ok := s.botPolicies[provider]; ok {
+		return policy
+	}
+	return s.botPolicy
+}
+
+// tagBotEvent returns a copy of event with bot properties added, leaving the
+// shared properties map untouched
+func tagBotEvent(event models.AnalyticsEvent) models.AnalyticsEvent {
+	props := make(map[string]interface{}, len(event.Properties)+2)
+	for key, value := range event.Properties {
+		props[key] = value
+	}
+	props["is_bot"] = true
+	props["bot_class"] = event.Client.Bot
+
+	event.Properties = props
+	return event
+}
+
 // ConsoleProvider logs events to console (for development)
 type ConsoleProvider struct{}
 
@@ -82,7 +293,7 @@ func (p *ConsoleProvider) Name() string { return "console" }
 
 func (p *ConsoleProvider) IsEnabled() bool { return true }
 
-func (p *ConsoleProvider) Track(event models.AnalyticsEvent) error {
+func (p *ConsoleProvider) Track(ctx context.Context, event models.AnalyticsEvent) error {
 	log.Debug().
 		Str("event", event.Name).
 		Str("category", event.Category).
@@ -92,6 +303,38 @@ func (p *ConsoleProvider) Track(event models.AnalyticsEvent) error {
 	return nil
 }
 
+// postJSON sends a JSON body to a provider endpoint and treats any non-2xx
+// response as an error
+func postJSON(ctx context.Context, client *http.Client, endpoint string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("provider returned status %d: %s", resp.StatusCode, bytes.TrimSpace(snippet))
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
 // GoogleAnalyticsProvider - placeholder for future implementation
 type GoogleAnalyticsProvider struct {
 	measurementID string
@@ -111,7 +354,7 @@ func (p *GoogleAnalyticsProvider) IsEnabled() bool {
 	return p.measurementID != "" && p.apiSecret != ""
 }
 
-func (p *GoogleAnalyticsProvider) Track(event models.AnalyticsEvent) error {
+func (p *GoogleAnalyticsProvider) Track(ctx context.Context, event models.AnalyticsEvent) error {
 	// TODO: Implement GA4 Measurement Protocol
 	// https://developers.google.com/analytics/devguides/collection/protocol/ga4
 	return nil
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/clayworks/middleware/internal/config"
+	"github.com/clayworks/middleware/internal/models"
+)
+
+// MixpanelProvider sends events to the Mixpanel import API
+// https://developer.mixpanel.com/reference/import-events
+type MixpanelProvider struct {
+	token      string
+	apiSecret  string
+	endpoint   string
+	httpClient *http.Client
+}
+
+func NewMixpanelProvider(cfg *config.Config, httpClient *http.Client) *MixpanelProvider {
+	query := url.Values{"strict": {"1"}}
+	if cfg.MixpanelProjectID != "" {
+		query.Set("project_id", cfg.MixpanelProjectID)
+	}
+
+	return &MixpanelProvider{
+		token:      cfg.MixpanelToken,
+		apiSecret:  cfg.MixpanelAPISecret,
+		endpoint:   strings.TrimRight(cfg.MixpanelAPIURL, "/") + "/import?" + query.Encode(),
+		httpClient: httpClient,
+	}
+}
+
+func (p *MixpanelProvider) Name() string { return "mixpanel" }
+
+func (p *MixpanelProvider) IsEnabled() bool {
+	return p.token != "" && p.apiSecret != ""
+}
+
+type mixpanelEvent struct {
+	Event      string                 `json:"event"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+func (p *MixpanelProvider) Track(ctx context.Context, event models.AnalyticsEvent) error {
+	props := make(map[string]interface{}, len(event.Properties)+8)
+	for key, value := range event.Properties {
+		props[key] = value
+	}
+
+	distinctID := event.UserID
+	if distinctID == "" {
+		distinctID = event.SessionID
+	}
+
+	props["token"] = p.token
+	props["time"] = event.Timestamp.UnixMilli()
+	props["distinct_id"] = distinctID
+	if event.Category != "" {
+		props["category"] = event.Category
+	}
+	if event.Label != "" {
+		props["label"] = event.Label
+	}
+	if event.Value != 0 {
+		props["value"] = event.Value
+	}
+	if event.SessionID != "" {
+		props["session_id"] = event.SessionID
+	}
+	if event.Client.IP != "" {
+		props["ip"] = event.Client.IP
+	}
+	props["$insert_id"] = insertID(event.Name, props)
+
+	body, err := json.Marshal([]mixpanelEvent{{Event: event.Name, Properties: props}})
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(p.apiSecret+":")))
+
+	return postJSON(ctx, p.httpClient, p.endpoint, body, header)
+}
+
+// insertID derives the $insert_id Mixpanel deduplicates imports by from the
+// event name and its properties, which include the distinct ID and the
+// timestamp, so the same event imported twice is only counted once
+func insertID(name string, props map[string]interface{}) string {
+	h := sha256.New()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	// Map keys are marshalled in sorted order, so equal properties hash alike
+	json.NewEncoder(h).Encode(props)
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
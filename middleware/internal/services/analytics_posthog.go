@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+	"github.com/clayworks/middleware/internal/models"
+)
+
+// PostHogProvider sends events to the PostHog capture API
+// https://posthog.com/docs/api/capture
+type PostHogProvider struct {
+	apiKey     string
+	endpoint   string
+	httpClient *http.Client
+}
+
+func NewPostHogProvider(cfg *config.Config, httpClient *http.Client) *PostHogProvider {
+	return &PostHogProvider{
+		apiKey:     cfg.PostHogAPIKey,
+		endpoint:   strings.TrimRight(cfg.PostHogHost, "/") + "/capture/",
+		httpClient: httpClient,
+	}
+}
+
+func (p *PostHogProvider) Name() string { return "posthog" }
+
+func (p *PostHogProvider) IsEnabled() bool { return p.apiKey != "" }
+
+type postHogEvent struct {
+	APIKey     string                 `json:"api_key"`
+	Event      string                 `json:"event"`
+	DistinctID string                 `json:"distinct_id"`
+	Timestamp  string                 `json:"timestamp"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+func (p *PostHogProvider) Track(ctx context.Context, event models.AnalyticsEvent) error {
+	props := make(map[string]interface{}, len(event.Properties)+6)
+	for key, value := range event.Properties {
+		props[key] = value
+	}
+
+	distinctID := event.UserID
+	if distinctID == "" {
+		// Anonymous traffic should not create person profiles
+		distinctID = event.SessionID
+		props["$process_person_profile"] = false
+	}
+	if distinctID == "" {
+		distinctID = "anonymous"
+	}
+
+	if event.Category != "" {
+		props["category"] = event.Category
+	}
+	if event.Label != "" {
+		props["label"] = event.Label
+	}
+	if event.Value != 0 {
+		props["value"] = event.Value
+	}
+	if event.SessionID != "" {
+		props["$session_id"] = event.SessionID
+	}
+	if event.Client.IP != "" {
+		props["$ip"] = event.Client.IP
+	}
+
+	name := event.Name
+	if name == "page_view" {
+		name = "$pageview"
+	}
+
+	body, err := json.Marshal(postHogEvent{
+		APIKey:     p.apiKey,
+		Event:      name,
+		DistinctID: distinctID,
+		Timestamp:  event.Timestamp.UTC().Format(time.RFC3339Nano),
+		Properties: props,
+	})
+	if err != nil {
+		return err
+	}
+
+	return postJSON(ctx, p.httpClient, p.endpoint, body, nil)
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+	"github.com/clayworks/middleware/internal/models"
+)
+
+// capturedRequest is a request received by a providerStandIn
+type capturedRequest struct {
+	path   string
+	query  string
+	header http.Header
+	body   []byte
+}
+
+// providerStandIn starts a server that records each request and answers with
+// status
+func providerStandIn(t *testing.T, status int) (*httptest.Server, <-chan capturedRequest) {
+	t.Helper()
+	requests := make(chan capturedRequest, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- capturedRequest{path: r.URL.Path, query: r.URL.RawQuery, header: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func testEvent() models.AnalyticsEvent {
+	return models.AnalyticsEvent{
+		Name:       "page_view",
+		Category:   "content",
+		SessionID:  "s1",
+		Timestamp:  time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+		Properties: map[string]interface{}{"path": "/locations"},
+		Client:     models.ClientContext{IP: "203.0.113.0", UserAgent: "test"},
+	}
+}
+
+func TestMixpanelProvider(t *testing.T) {
+	server, requests := providerStandIn(t, http.StatusOK)
+	p := NewMixpanelProvider(&config.Config{
+		MixpanelToken:     "token",
+		MixpanelAPISecret: "secret",
+		MixpanelProjectID: "42",
+		MixpanelAPIURL:    server.URL + "/",
+	}, server.Client())
+
+	if err := p.Track(context.Background(), testEvent()); err != nil {
+		t.Fatalf("Track() error = %v", err)
+	}
+	req := <-requests
+
+	if req.path != "/import" || req.query != "project_id=42&strict=1" {
+		t.Errorf("request to %s?%s, want /import?project_id=42&strict=1", req.path, req.query)
+	}
+	wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("secret:"))
+	if got := req.header.Get("Authorization"); got != wantAuth {
+		t.Errorf("Authorization = %q, want %q", got, wantAuth)
+	}
+
+	var events []mixpanelEvent
+	if err := json.Unmarshal(req.body, &events); err != nil || len(events) != 1 {
+		t.Fatalf("body = %s, want a one-event array", req.body)
+	}
+	props := events[0].Properties
+	for key, want := range map[string]interface{}{
+		"token":       "token",
+		"distinct_id": "s1",
+		"time":        float64(testEvent().Timestamp.UnixMilli()),
+		"path":        "/locations",
+		"category":    "content",
+		"ip":          "203.0.113.0",
+	} {
+		if props[key] != want {
+			t.Errorf("properties[%q] = %v, want %v", key, props[key], want)
+		}
+	}
+
+	// The same event must get the same $insert_id so Mixpanel deduplicates it
+	if err := p.Track(context.Background(), testEvent()); err != nil {
+		t.Fatalf("Track() error = %v", err)
+	}
+	var again []mixpanelEvent
+	json.Unmarshal((<-requests).body, &again)
+	if id := props["$insert_id"]; id == nil || id != again[0].Properties["$insert_id"] {
+		t.Errorf("$insert_id = %v and %v, want equal", id, again[0].Properties["$insert_id"])
+	}
+
+	other := testEvent()
+	other.Timestamp = other.Timestamp.Add(time.Millisecond)
+	p.Track(context.Background(), other)
+	var later []mixpanelEvent
+	json.Unmarshal((<-requests).body, &later)
+	if props["$insert_id"] == later[0].Properties["$insert_id"] {
+		t.Error("$insert_id is equal for events at different times")
+	}
+}
+
+func TestPostHogProvider(t *testing.T) {
+	server, requests := providerStandIn(t, http.StatusOK)
+	p := NewPostHogProvider(&config.Config{PostHogAPIKey: "phc_key", PostHogHost: server.URL}, server.Client())
+
+	if err := p.Track(context.Background(), testEvent()); err != nil {
+		t.Fatalf("Track() error = %v", err)
+	}
+	req := <-requests
+
+	if req.path != "/capture/" {
+		t.Errorf("path = %q, want /capture/", req.path)
+	}
+	if got := req.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+
+	var event postHogEvent
+	if err := json.Unmarshal(req.body, &event); err != nil {
+		t.Fatalf("body = %s: %v", req.body, err)
+	}
+	if event.APIKey != "phc_key" || event.Event != "$pageview" || event.DistinctID != "s1" ||
+		event.Timestamp != "2026-03-01T10:00:00Z" {
+		t.Errorf("event = %+v", event)
+	}
+	if event.Properties["$process_person_profile"] != false || event.Properties["$session_id"] != "s1" {
+		t.Errorf("properties = %v", event.Properties)
+	}
+}
+
+func TestWebhookProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		secret   string
+		want     map[string]interface{}
+	}{
+		{
+			name: "default payload",
+			want: map[string]interface{}{"name": "page_view", "session_id": "s1", "ip": "203.0.113.0"},
+		},
+		{
+			name:     "template and signature",
+			template: `{"type": {{json .Name}}, "at": {{unix .Timestamp}}, "path": {{json (index .Properties "path")}}}`,
+			secret:   "hook-secret",
+			want:     map[string]interface{}{"type": "page_view", "at": float64(1772359200), "path": "/locations"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := providerStandIn(t, http.StatusNoContent)
+			p, err := NewWebhookProvider(&config.Config{
+				AnalyticsWebhookURL:      server.URL + "/hook",
+				AnalyticsWebhookSecret:   tt.secret,
+				AnalyticsWebhookTemplate: tt.template,
+			}, server.Client())
+			if err != nil {
+				t.Fatalf("NewWebhookProvider() error = %v", err)
+			}
+
+			if err := p.Track(context.Background(), testEvent()); err != nil {
+				t.Fatalf("Track() error = %v", err)
+			}
+			req := <-requests
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(req.body, &body); err != nil {
+				t.Fatalf("body = %s: %v", req.body, err)
+			}
+			for key, want := range tt.want {
+				if body[key] != want {
+					t.Errorf("body[%q] = %v, want %v", key, body[key], want)
+				}
+			}
+
+			signature := req.header.Get("X-Webhook-Signature")
+			if tt.secret == "" {
+				if signature != "" {
+					t.Errorf("unsigned webhook has signature %q", signature)
+				}
+				return
+			}
+			mac := hmac.New(sha256.New, []byte(tt.secret))
+			mac.Write([]byte(req.header.Get("X-Webhook-Timestamp") + "."))
+			mac.Write(req.body)
+			if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+				t.Errorf("X-Webhook-Signature = %q, want %q", signature, want)
+			}
+		})
+	}
+}
+
+func TestProviderErrorStatus(t *testing.T) {
+	server, requests := providerStandIn(t, http.StatusBadRequest)
+	p := NewPostHogProvider(&config.Config{PostHogAPIKey: "phc_key", PostHogHost: server.URL}, server.Client())
+
+	if err := p.Track(context.Background(), testEvent()); err == nil {
+		t.Error("Track() error = nil for a 400 response")
+	}
+	<-requests
+}
@@ -0,0 +1,420 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clayworks/middleware/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	hourLayout = "2006-01-02T15"
+	dayLayout  = "2006-01-02"
+)
+
+// AnalyticsStore persists events in append-only JSON-lines files, one per
+// hour, and rolls completed hours and days up into aggregate files:
+//
+//	<dir>/events/2006-01-02/15.jsonl
+//	<dir>/rollups/hourly/2006-01-02T15.json
+//	<dir>/rollups/daily/2006-01-02.json
+//
+// Reports are answered from rollups where they exist and from raw files for
+// the current (or not yet rolled up) hours.
+type AnalyticsStore struct {
+	dir       string
+	retention time.Duration
+
+	mu       sync.Mutex
+	file     *os.File
+	fileHour time.Time
+
+	rollupMu sync.Mutex
+}
+
+// AnalyticsRollup holds aggregate counts for a time range
+type AnalyticsRollup struct {
+	Start     time.Time        `json:"start"`
+	End       time.Time        `json:"end"`
+	PageViews map[string]int64 `json:"page_views"`
+	Referrers map[string]int64 `json:"referrers"`
+	Events    map[string]int64 `json:"events"`
+	Sessions  []string         `json:"sessions"`
+}
+
+// CountEntry is a single key/count pair in a ranked report
+type CountEntry struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+func NewAnalyticsStore(dir string, retention time.Duration) (*AnalyticsStore, error) {
+	for _, sub := range []string{"events", "rollups/hourly", "rollups/daily"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &AnalyticsStore{dir: dir, retention: retention}, nil
+}
+
+// Append writes an event to the file for the current hour. Events are
+// bucketed by receipt time rather than their client-supplied timestamp so a
+// late event never lands in an hour that has already been rolled up.
+func (s *AnalyticsStore) Append(event models.AnalyticsEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	hour := time.Now().UTC().Truncate(time.Hour)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil || !s.fileHour.Equal(hour) {
+		if s.file != nil {
+			s.file.Close()
+			s.file = nil
+		}
+
+		path := s.eventsPath(hour)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		s.file = f
+		s.fileHour = hour
+	}
+
+	_, err = s.file.Write(line)
+	return err
+}
+
+// Close closes the currently open events file
+func (s *AnalyticsStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Report aggregates all events in [from, to). Bounds are truncated to the hour.
+// It stops with ctx's error once ctx is done.
+func (s *AnalyticsStore) Report(ctx context.Context, from, to time.Time) (*AnalyticsRollup, error) {
+	from = from.UTC().Truncate(time.Hour)
+	to = to.UTC()
+	if !to.Equal(to.Truncate(time.Hour)) {
+		to = to.Truncate(time.Hour).Add(time.Hour)
+	}
+
+	total := newRollup(from, to)
+	sessions := make(map[string]bool)
+
+	for hour := from; hour.Before(to); {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		day := hour.Truncate(24 * time.Hour)
+
+		// Whole days inside the range are served from the daily rollup
+		if hour.Equal(day) && !day.Add(24*time.Hour).After(to) {
+			if daily, err := s.readRollup(s.dailyPath(day)); err == nil {
+				total.merge(daily, sessions)
+				hour = day.Add(24 * time.Hour)
+				continue
+			}
+		}
+
+		hourly, err := s.hourRollup(hour)
+		if err != nil {
+			return nil, err
+		}
+		total.merge(hourly, sessions)
+		hour = hour.Add(time.Hour)
+	}
+
+	total.Sessions = sortedKeys(sessions)
+	return total, nil
+}
+
+// RunRollups builds missing rollups immediately and then once per hour until
+// stop is closed.
+func (s *AnalyticsStore) RunRollups(stop <-chan struct{}) {
+	s.rollup(time.Now())
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.rollup(now)
+		}
+	}
+}
+
+// rollup writes hourly rollups for every completed hour and daily rollups for
+// every completed day that lack one, then applies raw event retention.
+func (s *AnalyticsStore) rollup(now time.Time) {
+	s.rollupMu.Lock()
+	defer s.rollupMu.Unlock()
+
+	currentHour := now.UTC().Truncate(time.Hour)
+	today := currentHour.Truncate(24 * time.Hour)
+
+	days, err := os.ReadDir(filepath.Join(s.dir, "events"))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list analytics event files")
+		return
+	}
+
+	for _, d := range days {
+		day, err := time.Parse(dayLayout, d.Name())
+		if err != nil || !d.IsDir() {
+			continue
+		}
+
+		files, _ := os.ReadDir(filepath.Join(s.dir, "events", d.Name()))
+		for _, f := range files {
+			hour, err := time.Parse(hourLayout, d.Name()+"T"+strings.TrimSuffix(f.Name(), ".jsonl"))
+			if err != nil || !hour.Before(currentHour) {
+				continue
+			}
+			if _, err := os.Stat(s.hourlyPath(hour)); err == nil {
+				continue
+			}
+
+			r, err := s.aggregateFile(s.eventsPath(hour), hour, hour.Add(time.Hour))
+			if err == nil {
+				err = s.writeRollup(s.hourlyPath(hour), r)
+			}
+			if err != nil {
+				log.Error().Err(err).Time("hour", hour).Msg("Failed to build hourly analytics rollup")
+			}
+		}
+
+		if day.Before(today) {
+			if _, err := os.Stat(s.dailyPath(day)); os.IsNotExist(err) {
+				if err := s.buildDaily(day); err != nil {
+					log.Error().Err(err).Time("day", day).Msg("Failed to build daily analytics rollup")
+				}
+			}
+		}
+
+		if s.retention > 0 && day.Add(24*time.Hour).Before(now.Add(-s.retention)) {
+			if _, err := os.Stat(s.dailyPath(day)); err == nil {
+				os.RemoveAll(filepath.Join(s.dir, "events", d.Name()))
+			}
+		}
+	}
+}
+
+func (s *AnalyticsStore) buildDaily(day time.Time) error {
+	daily := newRollup(day, day.Add(24*time.Hour))
+	sessions := make(map[string]bool)
+
+	for hour := day; hour.Before(daily.End); hour = hour.Add(time.Hour) {
+		r, err := s.hourRollup(hour)
+		if err != nil {
+			return err
+		}
+		daily.merge(r, sessions)
+	}
+
+	daily.Sessions = sortedKeys(sessions)
+	return s.writeRollup(s.dailyPath(day), daily)
+}
+
+// hourRollup returns the stored rollup for an hour, or aggregates its raw file
+func (s *AnalyticsStore) hourRollup(hour time.Time) (*AnalyticsRollup, error) {
+	if r, err := s.readRollup(s.hourlyPath(hour)); err == nil {
+		return r, nil
+	}
+	return s.aggregateFile(s.eventsPath(hour), hour, hour.Add(time.Hour))
+}
+
+func (s *AnalyticsStore) aggregateFile(path string, start, end time.Time) (*AnalyticsRollup, error) {
+	r := newRollup(start, end)
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sessions := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var event models.AnalyticsEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// A partially written trailing line is skipped
+			continue
+		}
+		r.add(event, sessions)
+	}
+
+	r.Sessions = sortedKeys(sessions)
+	return r, scanner.Err()
+}
+
+func (s *AnalyticsStore) readRollup(path string) (*AnalyticsRollup, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var r AnalyticsRollup
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("decode rollup %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// writeRollup writes via a temporary file so readers never see partial rollups
+func (s *AnalyticsStore) writeRollup(path string, r *AnalyticsRollup) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *AnalyticsStore) eventsPath(hour time.Time) string {
+	return filepath.Join(s.dir, "events", hour.Format(dayLayout), hour.Format("15")+".jsonl")
+}
+
+func (s *AnalyticsStore) hourlyPath(hour time.Time) string {
+	return filepath.Join(s.dir, "rollups", "hourly", hour.Format(hourLayout)+".json")
+}
+
+func (s *AnalyticsStore) dailyPath(day time.Time) string {
+	return filepath.Join(s.dir, "rollups", "daily", day.Format(dayLayout)+".json")
+}
+
+func newRollup(start, end time.Time) *AnalyticsRollup {
+	return &AnalyticsRollup{
+		Start:     start,
+		End:       end,
+		PageViews: make(map[string]int64),
+		Referrers: make(map[string]int64),
+		Events:    make(map[string]int64),
+	}
+}
+
+func (r *AnalyticsRollup) add(event models.AnalyticsEvent, sessions map[string]bool) {
+	r.Events[event.Name]++
+
+	if event.SessionID != "" {
+		sessions[event.SessionID] = true
+	}
+
+	if event.Name != "page_view" {
+		return
+	}
+
+	if path, ok := event.Properties["path"].(string); ok && path != "" {
+		r.PageViews[path]++
+	}
+
+	if referrer, ok := event.Properties["referrer"].(string); ok && referrer != "" {
+		host := referrer
+		if u, err := url.Parse(referrer); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		r.Referrers[host]++
+	}
+}
+
+func (r *AnalyticsRollup) merge(other *AnalyticsRollup, sessions map[string]bool) {
+	for k, v := range other.PageViews {
+		r.PageViews[k] += v
+	}
+	for k, v := range other.Referrers {
+		r.Referrers[k] += v
+	}
+	for k, v := range other.Events {
+		r.Events[k] += v
+	}
+	for _, id := range other.Sessions {
+		sessions[id] = true
+	}
+}
+
+// TopCounts returns the entries of counts sorted by count, highest first
+func TopCounts(counts map[string]int64, limit int) []CountEntry {
+	entries := make([]CountEntry, 0, len(counts))
+	for key, count := range counts {
+		entries = append(entries, CountEntry{Key: key, Count: count})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Key < entries[j].Key
+	})
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FirstPartyProvider records events in the gateway's own AnalyticsStore
+type FirstPartyProvider struct {
+	store *AnalyticsStore
+}
+
+func NewFirstPartyProvider(store *AnalyticsStore) *FirstPartyProvider {
+	return &FirstPartyProvider{store: store}
+}
+
+func (p *FirstPartyProvider) Name() string { return "first_party" }
+
+func (p *FirstPartyProvider) IsEnabled() bool { return p.store != nil }
+
+func (p *FirstPartyProvider) Track(ctx context.Context, event models.AnalyticsEvent) error {
+	return p.store.Append(event)
+}
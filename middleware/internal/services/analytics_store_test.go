@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/clayworks/middleware/internal/models"
+)
+
+// writeEvents stores events as the raw file of hour
+func writeEvents(t *testing.T, s *AnalyticsStore, hour time.Time, events ...models.AnalyticsEvent) {
+	t.Helper()
+	path := s.eventsPath(hour)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	for _, event := range events {
+		line, _ := json.Marshal(event)
+		data = append(append(data, line...), '\n')
+	}
+	// A partially written trailing line must be skipped
+	data = append(data, `{"name":"page_v`...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pageView(path, referrer, session string) models.AnalyticsEvent {
+	return models.AnalyticsEvent{
+		Name:       "page_view",
+		SessionID:  session,
+		Properties: map[string]interface{}{"path": path, "referrer": referrer},
+	}
+}
+
+func TestAnalyticsStoreReport(t *testing.T) {
+	s, err := NewAnalyticsStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day1 := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	writeEvents(t, s, day1.Add(9*time.Hour),
+		pageView("/", "https://www.google.com/search?q=x", "a"),
+		pageView("/locations", "", "a"),
+		models.AnalyticsEvent{Name: "signup", SessionID: "b"},
+	)
+	writeEvents(t, s, day1.Add(23*time.Hour), pageView("/", "", "c"))
+	writeEvents(t, s, day2.Add(2*time.Hour), pageView("/", "https://www.google.com/", "a"))
+
+	// Day 1 is rolled up, day 2 is still read from raw files
+	s.rollup(day2.Add(3 * time.Hour))
+	for _, path := range []string{s.hourlyPath(day1.Add(9 * time.Hour)), s.dailyPath(day1), s.hourlyPath(day2.Add(2 * time.Hour))} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("rollup %s missing: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(s.dailyPath(day2)); err == nil {
+		t.Error("daily rollup written for the current day")
+	}
+
+	tests := []struct {
+		name      string
+		from, to  time.Time
+		pageViews map[string]int64
+		referrers map[string]int64
+		events    map[string]int64
+		sessions  []string
+	}{
+		{
+			name:      "whole range",
+			from:      day1,
+			to:        day2.Add(24 * time.Hour),
+			pageViews: map[string]int64{"/": 3, "/locations": 1},
+			referrers: map[string]int64{"www.google.com": 2},
+			events:    map[string]int64{"page_view": 4, "signup": 1},
+			sessions:  []string{"a", "b", "c"},
+		},
+		{
+			name:      "partial day from hourly rollups",
+			from:      day1.Add(9 * time.Hour),
+			to:        day1.Add(10 * time.Hour),
+			pageViews: map[string]int64{"/": 1, "/locations": 1},
+			referrers: map[string]int64{"www.google.com": 1},
+			events:    map[string]int64{"page_view": 2, "signup": 1},
+			sessions:  []string{"a", "b"},
+		},
+		{
+			name:      "bounds truncated to the hour",
+			from:      day1.Add(23*time.Hour + 30*time.Minute),
+			to:        day2.Add(time.Minute),
+			pageViews: map[string]int64{"/": 1},
+			referrers: map[string]int64{},
+			events:    map[string]int64{"page_view": 1},
+			sessions:  []string{"c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := s.Report(context.Background(), tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("Report() error = %v", err)
+			}
+			if !reflect.DeepEqual(r.PageViews, tt.pageViews) {
+				t.Errorf("PageViews = %v, want %v", r.PageViews, tt.pageViews)
+			}
+			if !reflect.DeepEqual(r.Referrers, tt.referrers) {
+				t.Errorf("Referrers = %v, want %v", r.Referrers, tt.referrers)
+			}
+			if !reflect.DeepEqual(r.Events, tt.events) {
+				t.Errorf("Events = %v, want %v", r.Events, tt.events)
+			}
+			if !reflect.DeepEqual(r.Sessions, tt.sessions) {
+				t.Errorf("Sessions = %v, want %v", r.Sessions, tt.sessions)
+			}
+		})
+	}
+}
+
+func TestAnalyticsStoreReportCancelled(t *testing.T) {
+	s, err := NewAnalyticsStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	to := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := s.Report(ctx, to.AddDate(-1, 0, 0), to); !errors.Is(err, context.Canceled) {
+		t.Errorf("Report() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestAnalyticsStoreRetention(t *testing.T) {
+	s, err := NewAnalyticsStore(t.TempDir(), 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	writeEvents(t, s, old.Add(time.Hour), pageView("/", "", "a"))
+	s.rollup(old.AddDate(0, 0, 5))
+
+	if _, err := os.Stat(filepath.Dir(s.eventsPath(old))); !os.IsNotExist(err) {
+		t.Errorf("raw events past retention kept: %v", err)
+	}
+	r, err := s.Report(context.Background(), old, old.Add(24*time.Hour))
+	if err != nil || r.PageViews["/"] != 1 {
+		t.Errorf("Report() after retention = %v, %v, want the daily rollup", r, err)
+	}
+}
+
+func TestTopCounts(t *testing.T) {
+	counts := map[string]int64{"/b": 2, "/a": 2, "/c": 5, "/d": 1}
+
+	tests := []struct {
+		limit int
+		want  []CountEntry
+	}{
+		{0, []CountEntry{{"/c", 5}, {"/a", 2}, {"/b", 2}, {"/d", 1}}},
+		{2, []CountEntry{{"/c", 5}, {"/a", 2}}},
+		{10, []CountEntry{{"/c", 5}, {"/a", 2}, {"/b", 2}, {"/d", 1}}},
+	}
+
+	for _, tt := range tests {
+		if got := TopCounts(counts, tt.limit); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TopCounts(%d) = %v, want %v", tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+	"github.com/clayworks/middleware/internal/models"
+)
+
+// WebhookProvider posts each event to an arbitrary HTTP endpoint. The body is
+// rendered from a configurable text/template and, when a secret is set,
+// signed with HMAC-SHA256 over "<timestamp>.<body>".
+type WebhookProvider struct {
+	url        string
+	secret     []byte
+	tmpl       *template.Template
+	httpClient *http.Client
+}
+
+// webhookPayload is the data passed to the webhook template
+type webhookPayload struct {
+	Name       string                 `json:"name"`
+	Category   string                 `json:"category,omitempty"`
+	Label      string                 `json:"label,omitempty"`
+	Value      float64                `json:"value,omitempty"`
+	SessionID  string                 `json:"session_id,omitempty"`
+	UserID     string                 `json:"user_id,omitempty"`
+	Timestamp  time.Time              `json:"timestamp"`
+	IP         string                 `json:"ip,omitempty"`
+	UserAgent  string                 `json:"user_agent,omitempty"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+func NewWebhookProvider(cfg *config.Config, httpClient *http.Client) (*WebhookProvider, error) {
+	p := &WebhookProvider{
+		url:        cfg.AnalyticsWebhookURL,
+		secret:     []byte(cfg.AnalyticsWebhookSecret),
+		httpClient: httpClient,
+	}
+
+	text := cfg.AnalyticsWebhookTemplate
+	if cfg.AnalyticsWebhookTemplateFile != "" {
+		data, err := os.ReadFile(cfg.AnalyticsWebhookTemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("read webhook template: %w", err)
+		}
+		text = string(data)
+	}
+
+	if text != "" {
+		tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+			"unix": func(t time.Time) int64 { return t.Unix() },
+		}).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("parse webhook template: %w", err)
+		}
+		p.tmpl = tmpl
+	}
+
+	return p, nil
+}
+
+func (p *WebhookProvider) Name() string { return "webhook" }
+
+func (p *WebhookProvider) IsEnabled() bool { return p.url != "" }
+
+func (p *WebhookProvider) Track(ctx context.Context, event models.AnalyticsEvent) error {
+	payload := webhookPayload{
+		Name:       event.Name,
+		Category:   event.Category,
+		Label:      event.Label,
+		Value:      event.Value,
+		SessionID:  event.SessionID,
+		UserID:     event.UserID,
+		Timestamp:  event.Timestamp,
+		IP:         event.Client.IP,
+		UserAgent:  event.Client.UserAgent,
+		Properties: event.Properties,
+	}
+
+	var body []byte
+	if p.tmpl != nil {
+		var buf bytes.Buffer
+		if err := p.tmpl.Execute(&buf, payload); err != nil {
+			return fmt.Errorf("render webhook template: %w", err)
+		}
+		if !json.Valid(buf.Bytes()) {
+			return fmt.Errorf("webhook template rendered invalid JSON")
+		}
+		body = buf.Bytes()
+	} else {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return err
+		}
+	}
+
+	return postJSON(ctx, p.httpClient, p.url, body, webhookSignature(p.secret, body))
+}
+
+// webhookSignature returns the X-Webhook-Timestamp and X-Webhook-Signature
+// headers for body: an HMAC-SHA256 of "<timestamp>.<body>". It is empty
+// without a secret.
+func webhookSignature(secret, body []byte) http.Header {
+	header := http.Header{}
+	if len(secret) == 0 {
+		return header
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	header.Set("X-Webhook-Timestamp", timestamp)
+	header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return header
+}
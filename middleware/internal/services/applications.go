@@ -0,0 +1,358 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+	"github.com/clayworks/middleware/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// applicationContentType is the Strapi collection storing job applications
+const applicationContentType = "applications"
+
+// ErrListingClosed means the job listing exists but is not accepting
+// applications
+var ErrListingClosed = errors.New("job listing is not accepting applications")
+
+// errResumeTooLarge stops a resume upload once it passes the size limit
+var errResumeTooLarge = errors.New("resume too large")
+
+// resumeSniffLen is how much of a resume is read ahead to check its type
+const resumeSniffLen = 512
+
+// resumeType is an accepted resume format: its media type and the bytes
+// every such file starts with
+type resumeType struct {
+	mime  string
+	magic []byte
+}
+
+// resumeTypes are the formats JOBS_RESUME_TYPES may allow, by extension
+var resumeTypes = map[string]resumeType{
+	"pdf":  {mime: "application/pdf", magic: []byte("%PDF-")},
+	"doc":  {mime: "application/msword", magic: []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}},
+	"docx": {mime: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", magic: []byte("PK\x03\x04")},
+	"odt":  {mime: "application/vnd.oasis.opendocument.text", magic: []byte("PK\x03\x04")},
+	"rtf":  {mime: "application/rtf", magic: []byte(`{\rtf`)},
+}
+
+// applicationFields are the applicant's details, validated like form fields
+var applicationFields = []FormField{
+	{Name: "fullName", Type: FieldText, Required: true},
+	{Name: "email", Type: FieldEmail, Required: true},
+	{Name: "phone", Type: FieldPhone},
+	{Name: "linkedIn", Type: FieldText, MaxLength: 255, Pattern: `^https?://\S+$`},
+	{Name: "portfolio", Type: FieldText, MaxLength: 255, Pattern: `^https?://\S+$`},
+	{Name: "coverLetter", Type: FieldTextarea},
+}
+
+// Resume is an uploaded resume. File is read once, as it is stored; its
+// size is only known once it has been read.
+type Resume struct {
+	Filename string
+	File     io.Reader
+}
+
+// resumeStream reads a resume for upload, failing with errResumeTooLarge
+// once more than max bytes have been read
+type resumeStream struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func newResumeStream(r io.Reader, max int64) *resumeStream {
+	return &resumeStream{r: io.LimitReader(r, max+1), max: max}
+}
+
+func (s *resumeStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.read += int64(n)
+	if s.read > s.max {
+		return n, errResumeTooLarge
+	}
+	return n, err
+}
+
+// ApplicationRequest is an application to a job listing. Values holds the
+// applicant's details along with the spam check fields.
+type ApplicationRequest struct {
+	Values    map[string]string
+	Resume    *Resume
+	UserAgent string
+	ClientIP  string
+}
+
+// strapiConnect links a relation to entries by document ID
+type strapiConnect struct {
+	Connect []string `json:"connect"`
+}
+
+// JobApplication is a stored application, in the shape of the Strapi
+// application content type
+type JobApplication struct {
+	JobListing  strapiConnect `json:"jobListing"`
+	FullName    string        `json:"fullName"`
+	Email       string        `json:"email"`
+	Phone       string        `json:"phone,omitempty"`
+	LinkedIn    string        `json:"linkedIn,omitempty"`
+	Portfolio   string        `json:"portfolio,omitempty"`
+	CoverLetter string        `json:"coverLetter,omitempty"`
+	Resume      int           `json:"resume"`
+	Status      string        `json:"status"`
+	PageURL     string        `json:"pageUrl,omitempty"`
+	UserAgent   string        `json:"userAgent,omitempty"`
+	SubmittedAt time.Time     `json:"submittedAt"`
+
+	// DocumentID is assigned by Strapi
+	DocumentID string `json:"-"`
+}
+
+// ApplicationService accepts job applications for active listings, storing
+// the resume through Strapi's upload plugin and the application as an entry
+// linked to the listing and the file
+type ApplicationService struct {
+	strapi *StrapiService
+	spam   spamChecker
+	fields []FormField
+
+	resumeTypes    []string
+	maxResumeBytes int64
+}
+
+func NewApplicationService(cfg *config.Config, strapi *StrapiService) (*ApplicationService, error) {
+	for _, ext := range cfg.JobsResumeTypes {
+		if _, ok := resumeTypes[ext]; !ok {
+			return nil, fmt.Errorf("JOBS_RESUME_TYPES: unsupported type %q", ext)
+		}
+	}
+	if len(cfg.JobsResumeTypes) == 0 || cfg.JobsResumeMaxBytes <= 0 {
+		return nil, errors.New("JOBS_RESUME_TYPES and JOBS_RESUME_MAX_BYTES must be set")
+	}
+
+	fields := append([]FormField(nil), applicationFields...)
+	for i := range fields {
+		if err := fields[i].compile(cfg.FormsHoneypotField); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ApplicationService{
+		strapi:         strapi,
+		spam:           newSpamChecker(cfg),
+		fields:         fields,
+		resumeTypes:    cfg.JobsResumeTypes,
+		maxResumeBytes: cfg.JobsResumeMaxBytes,
+	}, nil
+}
+
+// MaxResumeBytes is the largest resume accepted
+func (s *ApplicationService) MaxResumeBytes() int64 {
+	return s.maxResumeBytes
+}
+
+// Apply stores an application to the listing with the given slug, streaming
+// the resume to Strapi once the details, the resume's type and the listing
+// have been checked. It returns ErrSpam, a *FormValidationError,
+// ErrNotFound for unknown listings, ErrListingClosed, ErrUploadRead if the
+// resume could not be read from the client, or a StrapiService error.
+func (s *ApplicationService) Apply(ctx context.Context, slug string, req ApplicationRequest) (JobApplication, error) {
+	if reason := s.spam.reason(req.Values); reason != "" {
+		metrics.JobApplications.WithLabelValues("spam").Inc()
+		log.Info().Str("reason", reason).Str("ip", req.ClientIP).Msg("Dropped spam job application")
+		return JobApplication{}, ErrSpam
+	}
+
+	app, ext, resume, err := s.validate(req)
+	if err != nil {
+		if !errors.Is(err, ErrUploadRead) {
+			metrics.JobApplications.WithLabelValues("invalid").Inc()
+		}
+		return JobApplication{}, err
+	}
+
+	listing, err := s.openListing(ctx, slug)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrListingClosed) {
+			metrics.JobApplications.WithLabelValues("closed").Inc()
+		} else {
+			metrics.JobApplications.WithLabelValues("failed").Inc()
+		}
+		return JobApplication{}, err
+	}
+
+	file, err := s.strapi.Upload(ctx, resumeFilename(slug, app.FullName, ext), resumeTypes[ext].mime, resume)
+	switch {
+	case errors.Is(err, errResumeTooLarge):
+		metrics.JobApplications.WithLabelValues("invalid").Inc()
+		return JobApplication{}, &FormValidationError{Fields: []FieldError{
+			{Field: "resume", Message: fmt.Sprintf("must be at most %d bytes", s.maxResumeBytes)},
+		}}
+	case err != nil:
+		metrics.JobApplications.WithLabelValues("failed").Inc()
+		return JobApplication{}, err
+	}
+	app.Resume = file.ID
+	app.JobListing = strapiConnect{Connect: []string{listing}}
+
+	resp, err := s.strapi.CreateEntry(ctx, applicationContentType, app)
+	if err != nil {
+		metrics.JobApplications.WithLabelValues("failed").Inc()
+		s.removeResume(ctx, file)
+		return JobApplication{}, err
+	}
+
+	var created struct {
+		Data struct {
+			DocumentID string `json:"documentId"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &created); err == nil {
+		app.DocumentID = created.Data.DocumentID
+	}
+
+	metrics.JobApplications.WithLabelValues("accepted").Inc()
+	log.Info().Str("listing", slug).Str("application", app.DocumentID).Msg("Job application stored")
+	return app, nil
+}
+
+// validate checks the applicant's details and resume, returning the
+// application without its relations, the resume's type and the resume to
+// upload
+func (s *ApplicationService) validate(req ApplicationRequest) (JobApplication, string, io.Reader, error) {
+	app := JobApplication{
+		Status:      "new",
+		PageURL:     truncate(req.Values[FormPageField], 2048),
+		UserAgent:   truncate(req.UserAgent, 512),
+		SubmittedAt: time.Now().UTC(),
+	}
+
+	var invalid []FieldError
+	values := make(map[string]string, len(s.fields))
+	for _, field := range s.fields {
+		value, err := field.normalize(req.Values[field.Name])
+		if err != nil {
+			invalid = append(invalid, FieldError{Field: field.Name, Message: err.Error()})
+			continue
+		}
+		values[field.Name] = value
+	}
+	app.FullName = values["fullName"]
+	app.Email = values["email"]
+	app.Phone = values["phone"]
+	app.LinkedIn = values["linkedIn"]
+	app.Portfolio = values["portfolio"]
+	app.CoverLetter = values["coverLetter"]
+
+	ext, resume, err := s.checkResume(req.Resume)
+	if errors.Is(err, ErrUploadRead) {
+		return JobApplication{}, "", nil, err
+	}
+	if err != nil {
+		invalid = append(invalid, FieldError{Field: "resume", Message: err.Error()})
+	}
+
+	if len(invalid) > 0 {
+		return JobApplication{}, "", nil, &FormValidationError{Fields: invalid}
+	}
+	return app, ext, resume, nil
+}
+
+// checkResume checks the extension and leading bytes of the resume,
+// reading no more than its first resumeSniffLen bytes, and returns its
+// extension and a reader for the whole file that enforces the size limit.
+// Errors reading the file are returned as ErrUploadRead.
+func (s *ApplicationService) checkResume(resume *Resume) (string, io.Reader, error) {
+	if resume == nil {
+		return "", nil, errors.New("is required")
+	}
+
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(resume.Filename), "."))
+	if !contains(s.resumeTypes, ext) {
+		types := strings.ToUpper(strings.Join(s.resumeTypes, ", "))
+		if i := strings.LastIndex(types, ", "); i >= 0 {
+			types = types[:i] + " or " + types[i+2:]
+		}
+		return "", nil, fmt.Errorf("must be a %s file", types)
+	}
+
+	file := bufio.NewReaderSize(resume.File, resumeSniffLen)
+	head, err := file.Peek(resumeSniffLen)
+	switch {
+	case len(head) == 0 && err == io.EOF:
+		return "", nil, errors.New("is empty")
+	case err != nil && err != io.EOF:
+		return "", nil, fmt.Errorf("%w: %w", ErrUploadRead, err)
+	}
+
+	// The extension is the client's claim; the content has to agree
+	if !bytes.HasPrefix(head, resumeTypes[ext].magic) {
+		return "", nil, fmt.Errorf("is not a valid %s file", strings.ToUpper(ext))
+	}
+	return ext, newResumeStream(file, s.maxResumeBytes), nil
+}
+
+// openListing returns the document ID of the listing with the given slug if
+// it accepts applications. Listings without isActive are open.
+func (s *ApplicationService) openListing(ctx context.Context, slug string) (string, error) {
+	listings, _ := LookupContentType("job-listings")
+	data, _, err := s.strapi.GetBySlug(ctx, listings, slug, "")
+	if err != nil {
+		return "", err
+	}
+
+	var entry struct {
+		Data struct {
+			DocumentID string `json:"documentId"`
+			IsActive   *bool  `json:"isActive"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Data.DocumentID == "" {
+		return "", fmt.Errorf("%w: decoding job listing", ErrUpstreamUnavailable)
+	}
+	if entry.Data.IsActive != nil && !*entry.Data.IsActive {
+		return "", ErrListingClosed
+	}
+	return entry.Data.DocumentID, nil
+}
+
+// removeResume deletes a resume whose application could not be stored, so
+// no unreferenced personal data is left in the media library
+func (s *ApplicationService) removeResume(ctx context.Context, file UploadedFile) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	if err := s.strapi.DeleteFile(ctx, file.ID); err != nil {
+		log.Error().Err(err).Int("file", file.ID).Msg("Failed to remove resume of unsaved application")
+	}
+}
+
+// resumeFilename names a stored resume after the listing and applicant,
+// e.g. "senior-designer-jane-doe.pdf", in lowercase ASCII
+func resumeFilename(slug, name, ext string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(slug + " " + name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		}
+	}
+
+	base := strings.TrimSuffix(truncate(b.String(), 100), "-")
+	if base == "" {
+		base = "resume"
+	}
+	return base + "." + ext
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// backgroundJobs runs work that outlives the request that started it, such
+// as notifications, each bounded by timeout and cancelled on shutdown
+type backgroundJobs struct {
+	name    string
+	timeout time.Duration
+	baseCtx context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func newBackgroundJobs(name string, timeout time.Duration) *backgroundJobs {
+	baseCtx, cancel := context.WithCancel(context.Background())
+	return &backgroundJobs{name: name, timeout: timeout, baseCtx: baseCtx, cancel: cancel}
+}
+
+// Go runs fn in a new goroutine with a context limited to the job timeout
+func (j *backgroundJobs) Go(fn func(ctx context.Context)) {
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+
+		ctx, cancel := context.WithTimeout(j.baseCtx, j.timeout)
+		defer cancel()
+		fn(ctx)
+	}()
+}
+
+// Close waits for running jobs until ctx expires, then cancels them
+func (j *backgroundJobs) Close(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		j.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn().Str("jobs", j.name).Msg("Shutdown deadline reached, cancelling background jobs")
+		j.cancel()
+		<-done
+	}
+	j.cancel()
+}
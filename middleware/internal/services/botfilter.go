@@ -0,0 +1,216 @@
+package services
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Bot classifications attached to analytics events
+const (
+	BotClassNone     = ""
+	BotClassBot      = "bot"
+	BotClassHeadless = "headless"
+)
+
+// Per-provider handling of bot traffic
+const (
+	BotPolicyDrop  = "drop"
+	BotPolicyTag   = "tag"
+	BotPolicyAllow = "allow"
+)
+
+// defaultBotSignatures are case-insensitive user agent substrings used when no
+// signature file is configured
+var defaultBotSignatures = []string{
+	"bot", "crawler", "spider", "slurp", "crawl", "archiver",
+	"facebookexternalhit", "embedly", "quora link preview", "whatsapp",
+	"bingpreview", "lighthouse", "pagespeed", "gtmetrix",
+	"uptimerobot", "pingdom", "statuscake", "site24x7", "freshping",
+	"betteruptime", "updown.io", "newrelicpinger", "datadog synthetics",
+	"curl/", "wget/", "python-requests", "python-urllib", "go-http-client",
+	"okhttp", "java/", "libwww-perl", "axios/", "node-fetch", "httpclient",
+}
+
+// headlessSignatures identify automated browsers that otherwise look like
+// real ones
+var headlessSignatures = []string{
+	"headlesschrome", "phantomjs", "puppeteer", "playwright", "selenium",
+	"webdriver", "slimerjs", "splash",
+}
+
+// BotFilter classifies analytics traffic by user agent. Signatures can be
+// loaded from a file with one substring per line ("#" starts a comment); the
+// file is re-read when its modification time changes.
+type BotFilter struct {
+	path string
+
+	mu         sync.RWMutex
+	signatures []string
+	modTime    time.Time
+
+	botEvents      atomic.Int64
+	headlessEvents atomic.Int64
+	filteredMu     sync.Mutex
+	filtered       map[string]int64
+}
+
+func NewBotFilter(path string) *BotFilter {
+	f := &BotFilter{
+		path:       path,
+		signatures: defaultBotSignatures,
+		filtered:   make(map[string]int64),
+	}
+
+	if path != "" {
+		if err := f.Reload(); err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to load bot signatures, using defaults")
+		}
+	}
+
+	return f
+}
+
+// Classify returns the bot class for a user agent
+func (f *BotFilter) Classify(userAgent string) string {
+	ua := strings.ToLower(strings.TrimSpace(userAgent))
+	if ua == "" {
+		return BotClassBot
+	}
+
+	for _, sig := range headlessSignatures {
+		if strings.Contains(ua, sig) {
+			return BotClassHeadless
+		}
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, sig := range f.signatures {
+		if strings.Contains(ua, sig) {
+			return BotClassBot
+		}
+	}
+
+	return BotClassNone
+}
+
+// Reload reads the signature file if it changed since the last load
+func (f *BotFilter) Reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.mu.RLock()
+	unchanged := info.ModTime().Equal(f.modTime)
+	f.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var signatures []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line != "" {
+			signatures = append(signatures, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.signatures = signatures
+	f.modTime = info.ModTime()
+	f.mu.Unlock()
+
+	log.Info().Str("path", f.path).Int("signatures", len(signatures)).Msg("Bot signatures loaded")
+	return nil
+}
+
+// Watch reloads the signature file every interval until stop is closed
+func (f *BotFilter) Watch(interval time.Duration, stop <-chan struct{}) {
+	if f.path == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := f.Reload(); err != nil {
+				log.Error().Err(err).Str("path", f.path).Msg("Failed to reload bot signatures")
+			}
+		}
+	}
+}
+
+func (f *BotFilter) recordEvent(class string) {
+	switch class {
+	case BotClassBot:
+		f.botEvents.Add(1)
+	case BotClassHeadless:
+		f.headlessEvents.Add(1)
+	}
+}
+
+func (f *BotFilter) recordFiltered(provider string) {
+	f.filteredMu.Lock()
+	f.filtered[provider]++
+	f.filteredMu.Unlock()
+}
+
+// BotStats summarizes bot traffic seen since startup
+type BotStats struct {
+	BotEvents      int64            `json:"bot_events"`
+	HeadlessEvents int64            `json:"headless_events"`
+	Filtered       map[string]int64 `json:"filtered_by_provider"`
+	Signatures     int              `json:"signatures"`
+	LoadedAt       *time.Time       `json:"signatures_loaded_at,omitempty"`
+}
+
+func (f *BotFilter) Stats() BotStats {
+	stats := BotStats{
+		BotEvents:      f.botEvents.Load(),
+		HeadlessEvents: f.headlessEvents.Load(),
+		Filtered:       make(map[string]int64),
+	}
+
+	f.filteredMu.Lock()
+	for provider, count := range f.filtered {
+		stats.Filtered[provider] = count
+	}
+	f.filteredMu.Unlock()
+
+	f.mu.RLock()
+	stats.Signatures = len(f.signatures)
+	if !f.modTime.IsZero() {
+		loaded := f.modTime
+		stats.LoadedAt = &loaded
+	}
+	f.mu.RUnlock()
+
+	return stats
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/clayworks/middleware/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrCircuitOpen is returned without contacting the upstream while its
+// circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerState is the state of a CircuitBreaker
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker stops calls to an upstream after consecutive failures. Once
+// openTimeout has passed it lets a limited number of probe requests through
+// (half-open); a successful probe closes the breaker, a failed one re-opens it.
+type CircuitBreaker struct {
+	name           string
+	threshold      int
+	openTimeout    time.Duration
+	halfOpenProbes int
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+func NewCircuitBreaker(name string, threshold int, openTimeout time.Duration, halfOpenProbes int) *CircuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	if halfOpenProbes < 1 {
+		halfOpenProbes = 1
+	}
+
+	b := &CircuitBreaker{
+		name:           name,
+		threshold:      threshold,
+		openTimeout:    openTimeout,
+		halfOpenProbes: halfOpenProbes,
+	}
+	metrics.BreakerState.WithLabelValues(name).Set(float64(BreakerClosed))
+	return b
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be
+// followed by Success, Failure or Release.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return ErrCircuitOpen
+		}
+		b.transition(BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probes >= b.halfOpenProbes {
+			return ErrCircuitOpen
+		}
+		b.probes++
+	}
+
+	return nil
+}
+
+// Success records a successful call
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state == BreakerHalfOpen {
+		b.transition(BreakerClosed)
+	}
+}
+
+// Failure records a failed call
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerHalfOpen:
+		b.transition(BreakerOpen)
+	case BreakerClosed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.transition(BreakerOpen)
+		}
+	}
+}
+
+// Release ends a call that says nothing about the upstream's health, such as
+// one the caller cancelled, freeing its half-open probe slot without
+// changing state
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// State returns the current state, reporting an open breaker whose timeout
+// has elapsed as half-open
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.openTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// transition must be called with mu held
+func (b *CircuitBreaker) transition(to BreakerState) {
+	if b.state == to {
+		return
+	}
+
+	log.Warn().
+		Str("upstream", b.name).
+		Str("from", b.state.String()).
+		Str("to", to.String()).
+		Msg("Circuit breaker state changed")
+
+	b.state = to
+	b.probes = 0
+	if to == BreakerOpen {
+		b.openedAt = time.Now()
+	}
+	if to == BreakerClosed {
+		b.failures = 0
+	}
+
+	metrics.BreakerState.WithLabelValues(b.name).Set(float64(to))
+	metrics.BreakerTransitions.WithLabelValues(b.name, to.String()).Inc()
+}
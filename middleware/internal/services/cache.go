@@ -2,18 +2,33 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/clayworks/middleware/internal/config"
+	"github.com/clayworks/middleware/internal/metrics"
+	"github.com/clayworks/middleware/internal/tracing"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// CacheService stores content in Redis. Entries live for ttl plus staleTTL;
+// during the final staleTTL window they are still served but reported as
+// stale so callers can refresh them in the background.
+//
+// Every operation is bounded by opTimeout in addition to the caller's context.
 type CacheService struct {
-	client *redis.Client
-	ttl    time.Duration
-	ctx    context.Context
+	client    *redis.Client
+	ttl       time.Duration
+	staleTTL  time.Duration
+	opTimeout time.Duration
 }
 
 func NewCacheService(cfg *config.Config) *CacheService {
@@ -23,30 +38,48 @@ func NewCacheService(cfg *config.Config) *CacheService {
 		DB:       0,
 	})
 
-	ctx := context.Background()
+	client.AddHook(metrics.RedisHook{})
+
+	svc := &CacheService{
+		client:    client,
+		ttl:       cfg.CacheTTL,
+		staleTTL:  cfg.CacheStaleTTL,
+		opTimeout: cfg.RedisTimeout,
+	}
 
 	// Test connection
+	ctx, cancel := svc.withTimeout(context.Background())
+	defer cancel()
+
 	_, err := client.Ping(ctx).Result()
 	if err != nil {
 		log.Warn().Err(err).Msg("Redis connection failed, caching disabled")
-		return &CacheService{client: nil, ttl: cfg.CacheTTL, ctx: ctx}
+		client.Close()
+		svc.client = nil
+		return svc
 	}
 
 	log.Info().Str("addr", cfg.RedisURL).Msg("Redis connected")
 
-	return &CacheService{
-		client: client,
-		ttl:    cfg.CacheTTL,
-		ctx:    ctx,
+	return svc
+}
+
+func (s *CacheService) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.opTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, s.opTimeout)
 }
 
-func (s *CacheService) Get(key string) ([]byte, bool) {
+func (s *CacheService) Get(ctx context.Context, key string) ([]byte, bool) {
 	if s.client == nil {
 		return nil, false
 	}
 
-	val, err := s.client.Get(s.ctx, key).Bytes()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	val, err := s.client.Get(ctx, key).Bytes()
 	if err != nil {
 		return nil, false
 	}
@@ -54,58 +87,405 @@ func (s *CacheService) Get(key string) ([]byte, bool) {
 	return val, true
 }
 
-func (s *CacheService) Set(key string, value interface{}) error {
+// Lookup returns a cached value together with its state: metrics.CacheHit,
+// metrics.CacheStale or metrics.CacheMiss
+func (s *CacheService) Lookup(ctx context.Context, key string) (_ []byte, state string) {
+	ctx, span := tracing.Tracer().Start(ctx, "CacheService.Lookup",
+		trace.WithAttributes(attribute.String("cache.key", key)))
+	defer func() {
+		span.SetAttributes(attribute.String("cache.result", state))
+		span.End()
+	}()
+
 	if s.client == nil {
-		return nil
+		return nil, metrics.CacheMiss
+	}
+
+	if s.staleTTL <= 0 {
+		if val, found := s.Get(ctx, key); found {
+			return val, metrics.CacheHit
+		}
+		return nil, metrics.CacheMiss
 	}
 
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	pipe := s.client.Pipeline()
+	get := pipe.Get(ctx, key)
+	pttl := pipe.PTTL(ctx, key)
+	pipe.Exec(ctx)
+
+	val, err := get.Bytes()
+	if err != nil {
+		return nil, metrics.CacheMiss
+	}
+
+	if remaining := pttl.Val(); remaining > 0 && remaining <= s.staleTTL {
+		return val, metrics.CacheStale
+	}
+
+	return val, metrics.CacheHit
+}
+
+func (s *CacheService) Set(ctx context.Context, key string, value interface{}) error {
 	data, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
 
-	return s.client.Set(s.ctx, key, data, s.ttl).Err()
+	return s.SetRaw(ctx, key, data)
+}
+
+func (s *CacheService) SetRaw(ctx context.Context, key string, data []byte) error {
+	return s.SetRawTTL(ctx, key, data, s.ttl+s.staleTTL)
 }
 
-func (s *CacheService) SetRaw(key string, data []byte) error {
+// SetRawTTL stores data for exactly ttl, bypassing the stale window
+func (s *CacheService) SetRawTTL(ctx context.Context, key string, data []byte, ttl time.Duration) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "CacheService.SetRaw", trace.WithAttributes(
+		attribute.String("cache.key", key),
+		attribute.Int("cache.bytes", len(data)),
+		attribute.String("cache.ttl", ttl.String()),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
 	if s.client == nil {
 		return nil
 	}
 
-	return s.client.Set(s.ctx, key, data, s.ttl).Err()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.client.Set(ctx, key, data, ttl).Err()
+}
+
+// unlockScript deletes a lock key only while it still holds the caller's
+// token, so an expired lock taken over by another replica is left alone
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// lockRetryInterval is how often Lock retries a held lock
+const lockRetryInterval = 25 * time.Millisecond
+
+// Lock is a lock taken with CacheService.Lock
+type Lock struct {
+	cache *CacheService
+	key   string
+	value string
 }
 
-func (s *CacheService) Delete(key string) error {
+// Lock takes a lock shared by all replicas under key, waiting until ctx is
+// done if another holder has it. The lock expires after ttl in case its
+// holder dies. Without Redis it succeeds at once, so callers must still
+// serialise within the process.
+func (s *CacheService) Lock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
 	if s.client == nil {
-		return nil
+		return &Lock{}, nil
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	value := hex.EncodeToString(token)
+
+	for {
+		opCtx, cancel := s.withTimeout(ctx)
+		ok, err := s.client.SetNX(opCtx, key, value, ttl).Result()
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+
+		timer := time.NewTimer(lockRetryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return &Lock{cache: s, key: key, value: value}, nil
+}
+
+// Held reports whether the lock has not expired or been taken over. A lock
+// taken without Redis is always held.
+func (l *Lock) Held(ctx context.Context) (bool, error) {
+	if l.cache == nil {
+		return true, nil
+	}
+
+	ctx, cancel := l.cache.withTimeout(ctx)
+	defer cancel()
+
+	value, err := l.cache.client.Get(ctx, l.key).Result()
+	if err == redis.Nil {
+		return false, nil
 	}
+	if err != nil {
+		return false, err
+	}
+	return value == l.value, nil
+}
 
-	return s.client.Del(s.ctx, key).Err()
+// Unlock releases the lock if it is still held
+func (l *Lock) Unlock() {
+	if l.cache == nil {
+		return
+	}
+
+	ctx, cancel := l.cache.withTimeout(context.Background())
+	defer cancel()
+	if err := unlockScript.Run(ctx, l.cache.client, []string{l.key}, l.value).Err(); err != nil {
+		log.Warn().Err(err).Str("key", l.key).Msg("Failed to release lock")
+	}
 }
 
-func (s *CacheService) DeletePattern(pattern string) error {
+// Delete removes key and returns the number of keys removed (0 or 1)
+func (s *CacheService) Delete(ctx context.Context, key string) (int, error) {
 	if s.client == nil {
+		return 0, nil
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	n, err := s.client.Del(ctx, key).Result()
+	return int(n), err
+}
+
+// DeletePattern deletes every key matching a glob pattern and returns how many
+// were removed. Keys are found with SCAN so Redis is never blocked.
+func (s *CacheService) DeletePattern(ctx context.Context, pattern string) (int, error) {
+	if s.client == nil {
+		return 0, nil
+	}
+
+	deleted := 0
+	err := s.scan(ctx, pattern, func(keys []string) error {
+		ctx, cancel := s.withTimeout(ctx)
+		defer cancel()
+
+		n, err := s.client.Del(ctx, keys...).Result()
+		deleted += int(n)
+		return err
+	})
+	return deleted, err
+}
+
+// scan calls fn with each batch of keys matching pattern. Each SCAN round
+// trip gets its own opTimeout.
+func (s *CacheService) scan(ctx context.Context, pattern string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		scanCtx, cancel := s.withTimeout(ctx)
+		keys, next, err := s.client.Scan(scanCtx, cursor, pattern, scanBatch).Result()
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// scanBatch is the COUNT hint for SCAN
+const scanBatch = 250
+
+// CacheEntryInfo describes one cached key
+type CacheEntryInfo struct {
+	Key string `json:"key"`
+	// TTL is the remaining lifetime; -1 if the key has no expiry
+	TTL  time.Duration `json:"-"`
+	Size int64         `json:"size"`
+}
+
+// Keys lists up to limit keys matching pattern with their TTL and size
+func (s *CacheService) Keys(ctx context.Context, pattern string, limit int) ([]CacheEntryInfo, error) {
+	entries := make([]CacheEntryInfo, 0)
+	if s.client == nil {
+		return entries, nil
+	}
+
+	errLimit := errors.New("limit reached")
+	err := s.scan(ctx, pattern, func(keys []string) error {
+		if limit > 0 && len(entries)+len(keys) > limit {
+			keys = keys[:limit-len(entries)]
+		}
+
+		infos, err := s.describe(ctx, keys)
+		entries = append(entries, infos...)
+		if err != nil {
+			return err
+		}
+		if limit > 0 && len(entries) >= limit {
+			return errLimit
+		}
 		return nil
+	})
+	if errors.Is(err, errLimit) {
+		err = nil
+	}
+
+	return entries, err
+}
+
+// describe reads the TTL and size of keys in one pipeline, skipping keys that
+// expired in the meantime
+func (s *CacheService) describe(ctx context.Context, keys []string) ([]CacheEntryInfo, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	pipe := s.client.Pipeline()
+	ttls := make([]*redis.DurationCmd, len(keys))
+	sizes := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		ttls[i] = pipe.PTTL(ctx, key)
+		sizes[i] = pipe.StrLen(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	infos := make([]CacheEntryInfo, 0, len(keys))
+	for i, key := range keys {
+		ttl := ttls[i].Val()
+		if ttl == -2 {
+			continue
+		}
+		infos = append(infos, CacheEntryInfo{Key: key, TTL: ttl, Size: sizes[i].Val()})
+	}
+	return infos, nil
+}
+
+// Entry returns a single key's value together with its TTL and size
+func (s *CacheService) Entry(ctx context.Context, key string) (CacheEntryInfo, []byte, bool, error) {
+	if s.client == nil {
+		return CacheEntryInfo{}, nil, false, nil
+	}
+
+	infos, err := s.describe(ctx, []string{key})
+	if err != nil || len(infos) == 0 {
+		return CacheEntryInfo{}, nil, false, err
+	}
+
+	value, found := s.Get(ctx, key)
+	if !found {
+		return CacheEntryInfo{}, nil, false, nil
+	}
+	return infos[0], value, true, nil
+}
+
+// CacheStats summarises the keys under each prefix and Redis' own counters
+type CacheStats struct {
+	Connected  bool                   `json:"connected"`
+	Keys       int                    `json:"keys"`
+	Bytes      int64                  `json:"bytes"`
+	Prefixes   map[string]PrefixStats `json:"prefixes"`
+	UsedMemory int64                  `json:"redis_used_memory"`
+	Hits       int64                  `json:"redis_keyspace_hits"`
+	Misses     int64                  `json:"redis_keyspace_misses"`
+}
+
+type PrefixStats struct {
+	Keys  int   `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Stats scans the keys matching each of patterns and reads Redis INFO
+func (s *CacheService) Stats(ctx context.Context, patterns []string) (CacheStats, error) {
+	stats := CacheStats{Prefixes: make(map[string]PrefixStats)}
+	if s.client == nil {
+		return stats, nil
+	}
+	stats.Connected = true
+
+	for _, pattern := range patterns {
+		err := s.scan(ctx, pattern, func(keys []string) error {
+			infos, err := s.describe(ctx, keys)
+			for _, info := range infos {
+				prefix, _, _ := strings.Cut(info.Key, ":")
+				p := stats.Prefixes[prefix]
+				p.Keys++
+				p.Bytes += info.Size
+				stats.Prefixes[prefix] = p
+
+				stats.Keys++
+				stats.Bytes += info.Size
+			}
+			return err
+		})
+		if err != nil {
+			return stats, err
+		}
 	}
 
-	keys, err := s.client.Keys(s.ctx, pattern).Result()
+	infoCtx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// Redis counters are informational; servers that restrict INFO still
+	// get the key summary
+	info, err := s.client.Info(infoCtx, "memory", "stats").Result()
 	if err != nil {
-		return err
+		log.Warn().Err(err).Msg("Redis INFO unavailable")
+		return stats, nil
 	}
+	fields := parseRedisInfo(info)
+	stats.UsedMemory, _ = strconv.ParseInt(fields["used_memory"], 10, 64)
+	stats.Hits, _ = strconv.ParseInt(fields["keyspace_hits"], 10, 64)
+	stats.Misses, _ = strconv.ParseInt(fields["keyspace_misses"], 10, 64)
+
+	return stats, nil
+}
 
-	if len(keys) > 0 {
-		return s.client.Del(s.ctx, keys...).Err()
+// parseRedisInfo reads the "field:value" lines of an INFO reply
+func parseRedisInfo(info string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		if key, value, found := strings.Cut(strings.TrimSpace(line), ":"); found {
+			fields[key] = value
+		}
 	}
+	return fields
+}
 
-	return nil
+// Enabled reports whether Redis was reachable at startup; without it every
+// operation is a no-op
+func (s *CacheService) Enabled() bool {
+	return s.client != nil
 }
 
-func (s *CacheService) IsConnected() bool {
+func (s *CacheService) IsConnected(ctx context.Context) bool {
 	if s.client == nil {
 		return false
 	}
 
-	_, err := s.client.Ping(s.ctx).Result()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.client.Ping(ctx).Result()
 	return err == nil
 }
 
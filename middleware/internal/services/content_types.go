@@ -0,0 +1,236 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ContentType describes a Strapi content type the gateway knows about. Name is
+// the plural API ID used in /api/{name}; Model is the singular ID Strapi uses
+// in webhook payloads.
+type ContentType struct {
+	Name      string
+	Model     string
+	SlugField string
+	// Presets are selectable with ?preset=; DefaultPreset applies when a
+	// request names none and sets no populate or fields of its own
+	Presets map[string]Preset
+}
+
+// DefaultPreset is used for slug lookups and for requests without their own
+// populate or fields parameters
+const DefaultPreset = "default"
+
+// Preset selects the fields and relations Strapi returns. A nil Fields
+// returns every scalar field; a Populate entry with an empty Preset loads the
+// relation or media with all its fields, and the key "*" populates every
+// first-level relation.
+type Preset struct {
+	Fields   []string
+	Populate map[string]Preset
+}
+
+// mediaFields is the projection used for images in card and list presets
+var mediaFields = Preset{Fields: []string{"url", "alternativeText", "width", "height", "formats"}}
+
+// contentTypes mirrors the Strapi schemas under strapi/src/api
+var contentTypes = []ContentType{
+	{Name: "blog-posts", Model: "blog-post", SlugField: "slug", Presets: map[string]Preset{
+		DefaultPreset: {Populate: map[string]Preset{"featuredImage": {}}},
+		"list": {
+			Fields:   []string{"title", "slug", "excerpt", "author", "publishedAt", "categories", "tags", "featured"},
+			Populate: map[string]Preset{"featuredImage": mediaFields},
+		},
+	}},
+	{Name: "case-studies", Model: "case-study", SlugField: "slug", Presets: map[string]Preset{
+		DefaultPreset: {Populate: map[string]Preset{"featuredImage": {}}},
+		"card": {
+			Fields:   []string{"companyName", "slug", "description", "linkText", "industryTags", "featured", "order"},
+			Populate: map[string]Preset{"featuredImage": mediaFields},
+		},
+	}},
+	{Name: "job-listings", Model: "job-listing", SlugField: "slug", Presets: map[string]Preset{
+		DefaultPreset: {},
+		"list": {
+			Fields: []string{"title", "slug", "department", "location", "locationType", "category", "type", "description", "isActive"},
+		},
+	}},
+	{Name: "locations", Model: "location", SlugField: "slug", Presets: map[string]Preset{
+		DefaultPreset: {Populate: map[string]Preset{"featuredImage": {}, "gallery": {}}},
+		"card": {
+			Fields:   []string{"name", "slug", "title", "subtitle", "travelTime", "distance", "seats", "metroDistance", "tags", "featured", "order"},
+			Populate: map[string]Preset{"featuredImage": mediaFields},
+		},
+		// Every scalar field, including amenities, plus all images
+		"detail": {Populate: map[string]Preset{"featuredImage": {}, "gallery": {}}},
+		// Card fields plus what the nearby search filters and measures on
+		"nearby": {
+			Fields: []string{"name", "slug", "title", "subtitle", "address", "travelTime", "distance", "seats", "metroDistance",
+				"hasPowerBackup", "latitude", "longitude", "amenities", "tags", "featured", "order"},
+			Populate: map[string]Preset{"featuredImage": mediaFields},
+		},
+	}},
+	{Name: "faq-categories", Model: "faq-category", SlugField: "slug", Presets: map[string]Preset{
+		DefaultPreset: {Populate: map[string]Preset{"faqs": {}}},
+		"detail": {Populate: map[string]Preset{
+			"faqs": {Fields: []string{"question", "answer", "order"}},
+		}},
+	}},
+	{Name: "faqs", Model: "faq", Presets: map[string]Preset{
+		DefaultPreset: {Populate: map[string]Preset{"category": {Fields: []string{"name", "slug"}}}},
+	}},
+	{Name: "hero-sections", Model: "hero-section", Presets: map[string]Preset{
+		DefaultPreset: {Populate: map[string]Preset{"backgroundImage": {}}},
+	}},
+	{Name: "partners", Model: "partner", Presets: map[string]Preset{
+		DefaultPreset: {Populate: map[string]Preset{"logo": mediaFields}},
+	}},
+	{Name: "team-members", Model: "team-member", Presets: map[string]Preset{
+		DefaultPreset: {Populate: map[string]Preset{"image": mediaFields}},
+	}},
+	{Name: "testimonials", Model: "testimonial", Presets: map[string]Preset{
+		DefaultPreset: {Populate: map[string]Preset{"avatar": mediaFields}},
+	}},
+	{Name: "site-setting", Model: "site-setting", Presets: map[string]Preset{
+		DefaultPreset: {Populate: map[string]Preset{"logo": {}, "favicon": {}}},
+	}},
+	{Name: "pages", Model: "page", SlugField: "slug", Presets: map[string]Preset{
+		DefaultPreset: {Populate: map[string]Preset{"*": {}}},
+	}},
+}
+
+var (
+	contentTypesByName  = make(map[string]ContentType, len(contentTypes))
+	contentTypesByModel = make(map[string]ContentType, len(contentTypes))
+)
+
+func init() {
+	for _, ct := range contentTypes {
+		contentTypesByName[ct.Name] = ct
+		contentTypesByModel[ct.Model] = ct
+	}
+}
+
+// LookupContentType returns the registered content type with API ID name
+func LookupContentType(name string) (ContentType, bool) {
+	ct, ok := contentTypesByName[name]
+	return ct, ok
+}
+
+// LookupContentModel returns the registered content type for a Strapi model
+// name as sent in webhook payloads
+func LookupContentModel(model string) (ContentType, bool) {
+	ct, ok := contentTypesByModel[model]
+	return ct, ok
+}
+
+// HasSlug reports whether entries can be looked up by slug
+func (ct ContentType) HasSlug() bool {
+	return ct.SlugField != ""
+}
+
+// Preset returns the named preset. name may carry the model as a prefix,
+// e.g. "location:card".
+func (ct ContentType) Preset(name string) (Preset, bool) {
+	if model, preset, found := strings.Cut(name, ":"); found {
+		if model != ct.Model {
+			return Preset{}, false
+		}
+		name = preset
+	}
+	preset, ok := ct.Presets[name]
+	return preset, ok
+}
+
+// apply adds the preset as Strapi fields/populate parameters under prefix,
+// which is empty at the top level and e.g. "populate[gallery]" when nested
+func (p Preset) apply(query url.Values, prefix string) {
+	param := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return prefix + "[" + name + "]"
+	}
+
+	for i, field := range p.Fields {
+		query.Set(fmt.Sprintf("%s[%d]", param("fields"), i), field)
+	}
+
+	for _, name := range presetKeys(p.Populate) {
+		nested := p.Populate[name]
+		switch {
+		case name == "*":
+			query.Set(param("populate"), "*")
+		case len(nested.Fields) == 0 && len(nested.Populate) == 0:
+			query.Set(param("populate")+"["+name+"]", "true")
+		default:
+			nested.apply(query, param("populate")+"["+name+"]")
+		}
+	}
+}
+
+func presetKeys(m map[string]Preset) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// expandPreset replaces the preset parameter in query with the Strapi
+// parameters it stands for. Without one, the default preset applies unless
+// the client sent its own populate or fields. Unregistered content types are
+// passed through unchanged.
+func expandPreset(contentType string, query url.Values) (url.Values, string, error) {
+	name := query.Get("preset")
+	ct, known := LookupContentType(contentType)
+
+	if name == "" {
+		if !known || hasProjection(query) {
+			return query, "", nil
+		}
+		name = DefaultPreset
+	}
+
+	if !known {
+		return nil, "", fmt.Errorf("%w %q for %s", ErrUnknownPreset, name, contentType)
+	}
+	preset, ok := ct.Preset(name)
+	if !ok && name != DefaultPreset {
+		return nil, "", fmt.Errorf("%w %q for %s", ErrUnknownPreset, name, contentType)
+	}
+
+	expanded := url.Values{}
+	for key, values := range query {
+		if key == "preset" || isProjectionParam(key) {
+			continue
+		}
+		expanded[key] = values
+	}
+	preset.apply(expanded, "")
+
+	return expanded, name, nil
+}
+
+func hasProjection(query url.Values) bool {
+	for key := range query {
+		if isProjectionParam(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// isProjectionParam reports whether key is a Strapi populate or fields
+// parameter, which a preset replaces
+func isProjectionParam(key string) bool {
+	for _, name := range []string{"populate", "fields"} {
+		if key == name || strings.HasPrefix(key, name+"[") {
+			return true
+		}
+	}
+	return false
+}
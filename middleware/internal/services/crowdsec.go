@@ -0,0 +1,420 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+	"github.com/clayworks/middleware/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// CrowdSec remediations returned by Remediation
+const (
+	RemediationNone    = ""
+	RemediationBan     = "ban"
+	RemediationCaptcha = "captcha"
+)
+
+// CrowdSec bouncer modes
+const (
+	// CrowdSecModeStream keeps every decision in memory, pulled from the LAPI
+	// decision stream
+	CrowdSecModeStream = "stream"
+	// CrowdSecModeLive asks the LAPI about each new address and caches the
+	// answer
+	CrowdSecModeLive = "live"
+)
+
+// crowdSecFailOpenTTL is how long live mode allows uncached addresses without
+// asking the LAPI after a lookup failed, so an unreachable LAPI does not hold
+// every new client up for the full request timeout
+const crowdSecFailOpenTTL = 10 * time.Second
+
+// crowdSecDecision is a decision as returned by the CrowdSec Local API
+type crowdSecDecision struct {
+	ID       int64  `json:"id"`
+	Origin   string `json:"origin"`
+	Type     string `json:"type"`
+	Scope    string `json:"scope"`
+	Value    string `json:"value"`
+	Duration string `json:"duration"`
+	Scenario string `json:"scenario"`
+}
+
+type crowdSecStream struct {
+	New     []crowdSecDecision `json:"new"`
+	Deleted []crowdSecDecision `json:"deleted"`
+}
+
+// activeDecision is a decision held locally until it expires
+type activeDecision struct {
+	remediation string
+	expires     time.Time
+}
+
+// decisionSet holds the decisions on one address or range by decision ID
+type decisionSet struct {
+	network   *net.IPNet
+	decisions map[int64]activeDecision
+}
+
+// liveEntry caches a live-mode answer, including "no decision"
+type liveEntry struct {
+	remediation string
+	expires     time.Time
+}
+
+// CrowdSecBouncer answers whether a client address is banned or must be
+// challenged, based on decisions from the CrowdSec Local API. Errors talking
+// to the LAPI never block traffic.
+type CrowdSecBouncer struct {
+	lapiURL    string
+	apiKey     string
+	mode       string
+	interval   time.Duration
+	cacheTTL   time.Duration
+	httpClient *http.Client
+
+	mu     sync.RWMutex
+	ips    map[string]*decisionSet
+	ranges map[string]*decisionSet
+	live   map[string]liveEntry
+	synced bool
+	// liveFailOpenUntil skips live lookups after one failed
+	liveFailOpenUntil time.Time
+}
+
+func NewCrowdSecBouncer(cfg *config.Config) *CrowdSecBouncer {
+	b := &CrowdSecBouncer{
+		lapiURL:    strings.TrimRight(cfg.CrowdSecLAPIURL, "/"),
+		apiKey:     cfg.CrowdSecAPIKey,
+		mode:       cfg.CrowdSecMode,
+		interval:   cfg.CrowdSecUpdateInterval,
+		cacheTTL:   cfg.CrowdSecCacheTTL,
+		httpClient: &http.Client{Timeout: cfg.CrowdSecTimeout},
+		ips:        make(map[string]*decisionSet),
+		ranges:     make(map[string]*decisionSet),
+		live:       make(map[string]liveEntry),
+	}
+	if b.mode != CrowdSecModeLive {
+		b.mode = CrowdSecModeStream
+	}
+	return b
+}
+
+// Enabled reports whether a Local API is configured
+func (b *CrowdSecBouncer) Enabled() bool {
+	return b.lapiURL != ""
+}
+
+// Run pulls the decision stream every interval until ctx is done. In live
+// mode it evicts expired cached answers instead.
+func (b *CrowdSecBouncer) Run(ctx context.Context) {
+	if !b.Enabled() {
+		return
+	}
+	if b.mode == CrowdSecModeLive {
+		b.sweepLive(ctx)
+		return
+	}
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.pull(ctx); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("Failed to pull CrowdSec decisions")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pull fetches the decision stream, asking for the full list until the first
+// pull has succeeded
+func (b *CrowdSecBouncer) pull(ctx context.Context) error {
+	b.mu.RLock()
+	startup := !b.synced
+	b.mu.RUnlock()
+
+	query := url.Values{}
+	query.Set("startup", fmt.Sprint(startup))
+	query.Set("scopes", "ip,range")
+
+	var stream crowdSecStream
+	if err := b.get(ctx, "/v1/decisions/stream", query, &stream); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	b.mu.Lock()
+	if startup {
+		b.ips = make(map[string]*decisionSet)
+		b.ranges = make(map[string]*decisionSet)
+	}
+	for _, d := range stream.Deleted {
+		b.remove(d)
+	}
+	for _, d := range stream.New {
+		b.add(d, now)
+	}
+	b.synced = true
+	count := len(b.ips) + len(b.ranges)
+	b.mu.Unlock()
+
+	metrics.CrowdSecDecisions.Set(float64(count))
+	if startup || len(stream.New) > 0 || len(stream.Deleted) > 0 {
+		log.Info().
+			Bool("startup", startup).
+			Int("new", len(stream.New)).
+			Int("deleted", len(stream.Deleted)).
+			Int("active", count).
+			Msg("CrowdSec decisions updated")
+	}
+	return nil
+}
+
+// add stores a stream decision; the caller holds mu
+func (b *CrowdSecBouncer) add(d crowdSecDecision, now time.Time) {
+	_, set := b.decisionSet(d, true)
+	if set == nil {
+		log.Debug().Str("scope", d.Scope).Str("value", d.Value).Msg("Ignoring CrowdSec decision")
+		return
+	}
+	set.decisions[d.ID] = activeDecision{
+		remediation: remediationFor(d.Type),
+		expires:     decisionExpiry(d.Duration, now),
+	}
+}
+
+// remove deletes a stream decision; the caller holds mu
+func (b *CrowdSecBouncer) remove(d crowdSecDecision) {
+	key, set := b.decisionSet(d, false)
+	if set == nil {
+		return
+	}
+	delete(set.decisions, d.ID)
+	if len(set.decisions) > 0 {
+		return
+	}
+	if set.network != nil {
+		delete(b.ranges, key)
+	} else {
+		delete(b.ips, key)
+	}
+}
+
+// decisionSet finds, and with create makes, the set for a decision's scope
+// and value
+func (b *CrowdSecBouncer) decisionSet(d crowdSecDecision, create bool) (string, *decisionSet) {
+	switch strings.ToLower(d.Scope) {
+	case "ip":
+		ip := net.ParseIP(d.Value)
+		if ip == nil {
+			return "", nil
+		}
+		key := ip.String()
+		set, ok := b.ips[key]
+		if !ok && create {
+			set = &decisionSet{decisions: make(map[int64]activeDecision)}
+			b.ips[key] = set
+		}
+		return key, set
+	case "range":
+		_, network, err := net.ParseCIDR(d.Value)
+		if err != nil {
+			return "", nil
+		}
+		key := network.String()
+		set, ok := b.ranges[key]
+		if !ok && create {
+			set = &decisionSet{network: network, decisions: make(map[int64]activeDecision)}
+			b.ranges[key] = set
+		}
+		return key, set
+	default:
+		return "", nil
+	}
+}
+
+// Remediation returns the strongest active remediation for ip, or
+// RemediationNone
+func (b *CrowdSecBouncer) Remediation(ctx context.Context, ip string) string {
+	if !b.Enabled() {
+		return RemediationNone
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return RemediationNone
+	}
+
+	if b.mode == CrowdSecModeLive {
+		return b.liveRemediation(ctx, parsed)
+	}
+
+	now := time.Now()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	remediation := RemediationNone
+	if set, ok := b.ips[parsed.String()]; ok {
+		remediation = strongest(remediation, set.active(now))
+	}
+	for _, set := range b.ranges {
+		if set.network.Contains(parsed) {
+			remediation = strongest(remediation, set.active(now))
+		}
+	}
+	return remediation
+}
+
+// liveRemediation asks the LAPI about ip unless a cached answer is still
+// fresh. Decisions are cached no longer than they last. After a failed
+// lookup, uncached addresses are allowed without asking for
+// crowdSecFailOpenTTL.
+func (b *CrowdSecBouncer) liveRemediation(ctx context.Context, ip net.IP) string {
+	key := ip.String()
+	now := time.Now()
+
+	b.mu.RLock()
+	entry, ok := b.live[key]
+	failOpen := now.Before(b.liveFailOpenUntil)
+	b.mu.RUnlock()
+	if ok && now.Before(entry.expires) {
+		return entry.remediation
+	}
+	if failOpen {
+		return RemediationNone
+	}
+
+	query := url.Values{}
+	query.Set("ip", key)
+
+	// The LAPI answers "null" when there are no decisions
+	var decisions []crowdSecDecision
+	if err := b.get(ctx, "/v1/decisions", query, &decisions); err != nil {
+		if ctx.Err() != nil {
+			// The client left; the LAPI may be fine
+			return RemediationNone
+		}
+		log.Warn().Err(err).Str("ip", key).Dur("fail_open", crowdSecFailOpenTTL).Msg("CrowdSec decision lookup failed, allowing traffic")
+		b.mu.Lock()
+		b.liveFailOpenUntil = time.Now().Add(crowdSecFailOpenTTL)
+		b.mu.Unlock()
+		return RemediationNone
+	}
+
+	entry = liveEntry{remediation: RemediationNone, expires: now.Add(b.cacheTTL)}
+	for _, d := range decisions {
+		entry.remediation = strongest(entry.remediation, remediationFor(d.Type))
+		if expires := decisionExpiry(d.Duration, now); expires.Before(entry.expires) {
+			entry.expires = expires
+		}
+	}
+
+	b.mu.Lock()
+	b.live[key] = entry
+	b.mu.Unlock()
+
+	return entry.remediation
+}
+
+// sweepLive drops expired live answers every cache TTL until ctx is done, so
+// the cache only holds recently seen clients
+func (b *CrowdSecBouncer) sweepLive(ctx context.Context) {
+	interval := b.cacheTTL
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.mu.Lock()
+			for key, entry := range b.live {
+				if now.After(entry.expires) {
+					delete(b.live, key)
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+func (b *CrowdSecBouncer) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.lapiURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", b.apiKey)
+	req.Header.Set("User-Agent", "clayworks-gateway-bouncer")
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("crowdsec returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// active returns the strongest unexpired remediation in the set
+func (s *decisionSet) active(now time.Time) string {
+	remediation := RemediationNone
+	for _, d := range s.decisions {
+		if now.Before(d.expires) {
+			remediation = strongest(remediation, d.remediation)
+		}
+	}
+	return remediation
+}
+
+// remediationFor maps a decision type to a remediation; types this gateway
+// cannot apply are treated as bans
+func remediationFor(decisionType string) string {
+	if strings.EqualFold(decisionType, RemediationCaptcha) {
+		return RemediationCaptcha
+	}
+	return RemediationBan
+}
+
+func strongest(a, b string) string {
+	if a == RemediationBan || b == RemediationBan {
+		return RemediationBan
+	}
+	if a == RemediationCaptcha || b == RemediationCaptcha {
+		return RemediationCaptcha
+	}
+	return RemediationNone
+}
+
+// decisionExpiry turns a remaining duration such as "3h59m12.5s" into a
+// deadline. Unparseable durations are kept until the stream deletes them.
+func decisionExpiry(duration string, now time.Time) time.Time {
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return now.Add(100 * 365 * 24 * time.Hour)
+	}
+	return now.Add(d)
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+)
+
+func TestCrowdSecStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "bouncer-key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(crowdSecStream{New: []crowdSecDecision{
+			{ID: 1, Type: "ban", Scope: "Ip", Value: "198.51.100.7", Duration: "4h"},
+			{ID: 2, Type: "captcha", Scope: "Range", Value: "203.0.113.0/24", Duration: "1h"},
+			{ID: 3, Type: "ban", Scope: "Ip", Value: "198.51.100.8", Duration: "-1s"},
+			{ID: 4, Type: "ban", Scope: "Country", Value: "XX", Duration: "1h"},
+		}})
+	}))
+	defer server.Close()
+
+	b := NewCrowdSecBouncer(&config.Config{
+		CrowdSecLAPIURL: server.URL,
+		CrowdSecAPIKey:  "bouncer-key",
+		CrowdSecTimeout: time.Second,
+	})
+	if err := b.pull(context.Background()); err != nil {
+		t.Fatalf("pull() error = %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"198.51.100.7", RemediationBan},
+		{"203.0.113.99", RemediationCaptcha},
+		{"198.51.100.8", RemediationNone},
+		{"192.0.2.1", RemediationNone},
+		{"not-an-ip", RemediationNone},
+	}
+	for _, tt := range tests {
+		if got := b.Remediation(context.Background(), tt.ip); got != tt.want {
+			t.Errorf("Remediation(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestCrowdSecLive(t *testing.T) {
+	var lookups atomic.Int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("ip") == "198.51.100.7" {
+			json.NewEncoder(w).Encode([]crowdSecDecision{{ID: 1, Type: "ban", Scope: "Ip", Value: "198.51.100.7", Duration: "4h"}})
+			return
+		}
+		w.Write([]byte("null"))
+	}))
+	defer server.Close()
+
+	b := NewCrowdSecBouncer(&config.Config{
+		CrowdSecLAPIURL:  server.URL,
+		CrowdSecMode:     CrowdSecModeLive,
+		CrowdSecCacheTTL: time.Minute,
+		CrowdSecTimeout:  time.Second,
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if got := b.Remediation(ctx, "198.51.100.7"); got != RemediationBan {
+			t.Fatalf("Remediation() = %q, want ban", got)
+		}
+		if got := b.Remediation(ctx, "192.0.2.1"); got != RemediationNone {
+			t.Fatalf("Remediation() = %q, want none", got)
+		}
+	}
+	if n := lookups.Load(); n != 2 {
+		t.Errorf("LAPI lookups = %d, want 2 (answers cached)", n)
+	}
+
+	// After a failed lookup, new addresses are allowed without asking
+	failing.Store(true)
+	for _, ip := range []string{"192.0.2.2", "192.0.2.3", "192.0.2.4"} {
+		if got := b.Remediation(ctx, ip); got != RemediationNone {
+			t.Errorf("Remediation(%q) while failing = %q, want none", ip, got)
+		}
+	}
+	if n := lookups.Load(); n != 3 {
+		t.Errorf("LAPI lookups = %d, want 3 (one failed, then fail open)", n)
+	}
+	// Cached answers still apply
+	if got := b.Remediation(ctx, "198.51.100.7"); got != RemediationBan {
+		t.Errorf("cached Remediation() while failing = %q, want ban", got)
+	}
+
+	// Once the window has passed, lookups resume
+	b.mu.Lock()
+	b.liveFailOpenUntil = time.Now().Add(-time.Second)
+	b.mu.Unlock()
+	failing.Store(false)
+	b.Remediation(ctx, "192.0.2.5")
+	if n := lookups.Load(); n != 4 {
+		t.Errorf("LAPI lookups = %d, want 4 after the fail-open window", n)
+	}
+}
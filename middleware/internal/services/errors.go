@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors returned by StrapiService, matched with errors.Is. Upstream response
+// bodies are never part of the message.
+var (
+	ErrNotFound            = errors.New("content not found")
+	ErrForbidden           = errors.New("content access forbidden")
+	ErrValidation          = errors.New("invalid content request")
+	ErrUpstreamUnavailable = errors.New("content service unavailable")
+	ErrUpstreamTimeout     = errors.New("content service timed out")
+	ErrDuplicateSlug       = errors.New("slug matches more than one entry")
+	ErrUnknownPreset       = errors.New("unknown preset")
+	ErrUnknownContentType  = errors.New("unknown content type")
+	ErrUploadRead          = errors.New("upload could not be read")
+)
+
+// upstreamStatusError is a non-200 response from Strapi
+type upstreamStatusError struct {
+	status int
+	// message is Strapi's own error message, used for validation errors
+	message string
+}
+
+func newUpstreamStatusError(status int, body []byte) *upstreamStatusError {
+	// Strapi v5 error body: {"error":{"status":400,"name":"ValidationError","message":"..."}}
+	var envelope struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &envelope)
+
+	return &upstreamStatusError{status: status, message: envelope.Error.Message}
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("strapi returned status %d", e.status)
+}
+
+// Unwrap maps the status to one of the exported error kinds
+func (e *upstreamStatusError) Unwrap() error {
+	switch {
+	case e.status == http.StatusNotFound:
+		return ErrNotFound
+	case e.status == http.StatusUnauthorized || e.status == http.StatusForbidden:
+		return ErrForbidden
+	case e.status == http.StatusBadRequest:
+		return ErrValidation
+	case e.status == http.StatusGatewayTimeout:
+		return ErrUpstreamTimeout
+	default:
+		return ErrUpstreamUnavailable
+	}
+}
+
+// ValidationMessage returns Strapi's explanation for a rejected request, if
+// err is a validation error that carries one
+func ValidationMessage(err error) string {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) && errors.Is(err, ErrValidation) {
+		return statusErr.message
+	}
+	return ""
+}
+
+// classifyTransportError wraps an error from the HTTP client or breaker with
+// the matching exported kind. Cancellation by the caller is left as is.
+func classifyTransportError(err error) error {
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return err
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrUpstreamTimeout, err)
+	case errors.As(err, new(*upstreamStatusError)):
+		return err
+	default:
+		return fmt.Errorf("%w: %w", ErrUpstreamUnavailable, err)
+	}
+}
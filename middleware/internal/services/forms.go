@@ -0,0 +1,561 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/clayworks/middleware/internal/config"
+	"github.com/clayworks/middleware/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// Form field types
+const (
+	FieldText     = "text"
+	FieldTextarea = "textarea"
+	FieldEmail    = "email"
+	FieldPhone    = "phone"
+	FieldSelect   = "select"
+	FieldCheckbox = "checkbox"
+	FieldNumber   = "number"
+)
+
+// Submission fields the gateway reads itself; they are never stored
+const (
+	// FormTokenField carries the render token issued when the form was
+	// shown, see FormService.Token
+	FormTokenField = "_token"
+	// FormPageField is the URL of the page the form was submitted from
+	FormPageField = "_page"
+)
+
+// leadContentType is the Strapi collection storing submissions
+const leadContentType = "leads"
+
+var (
+	ErrUnknownForm = errors.New("unknown form")
+	// ErrSpam marks a submission caught by the honeypot or timing checks.
+	// Clients are told it was accepted.
+	ErrSpam = errors.New("submission looks like spam")
+)
+
+// defaultMaxLengths apply to fields without max_length, in characters
+var defaultMaxLengths = map[string]int{
+	FieldText:     200,
+	FieldTextarea: 5000,
+	FieldEmail:    254,
+	FieldPhone:    32,
+	FieldSelect:   200,
+	FieldNumber:   32,
+	FieldCheckbox: 5,
+}
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9 ().-]+$`)
+
+// FormField is one accepted field of a form. LeadField copies the value into
+// that column of the Strapi lead ("name", "email", "phone", "company" or
+// "message"); every value is also kept in the lead's data.
+type FormField struct {
+	Name      string   `json:"name"`
+	Label     string   `json:"label,omitempty"`
+	Type      string   `json:"type"`
+	Required  bool     `json:"required,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"`
+	Options   []string `json:"options,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	LeadField string   `json:"lead_field,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// FormSchema describes a form accepted at /api/v1/forms/{id}
+type FormSchema struct {
+	ID     string      `json:"id"`
+	Title  string      `json:"title"`
+	Fields []FormField `json:"fields"`
+	// Notify replaces FORMS_NOTIFY_EMAIL as the recipients for this form
+	Notify []string `json:"notify,omitempty"`
+}
+
+// defaultForms mirror the forms on the Next.js site
+var defaultForms = []FormSchema{
+	{ID: "contact", Title: "Contact", Fields: []FormField{
+		{Name: "fullName", Label: "Full name", Type: FieldText, Required: true, LeadField: "name"},
+		{Name: "email", Label: "Email", Type: FieldEmail, Required: true, LeadField: "email"},
+		{Name: "phone", Label: "Phone", Type: FieldPhone, LeadField: "phone"},
+		{Name: "requirement", Label: "Requirement", Type: FieldSelect, Options: []string{
+			"Day Pass", "Meeting Room", "Virtual Office", "Co-working Space", "Other",
+		}},
+		{Name: "numberOfSeats", Label: "Seats", Type: FieldSelect, Options: []string{"1", "2", "3", "4", "5+"}},
+		{Name: "message", Label: "Message", Type: FieldTextarea, LeadField: "message"},
+		{Name: "agreeToPolicy", Label: "Agreed to privacy policy", Type: FieldCheckbox, Required: true},
+	}},
+	{ID: "partner", Title: "Partner enquiry", Fields: []FormField{
+		{Name: "fullName", Label: "Full name", Type: FieldText, Required: true, LeadField: "name"},
+		{Name: "email", Label: "Email", Type: FieldEmail, Required: true, LeadField: "email"},
+		{Name: "phone", Label: "Phone", Type: FieldPhone, LeadField: "phone"},
+		{Name: "companyName", Label: "Company", Type: FieldText, LeadField: "company"},
+		{Name: "message", Label: "Message", Type: FieldTextarea, LeadField: "message"},
+		{Name: "agreeToPolicy", Label: "Agreed to privacy policy", Type: FieldCheckbox, Required: true},
+	}},
+	{ID: "newsletter", Title: "Newsletter signup", Fields: []FormField{
+		{Name: "email", Label: "Email", Type: FieldEmail, Required: true, LeadField: "email"},
+	}},
+}
+
+// FieldError is one invalid field of a submission
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// FormValidationError lists the fields of a submission that failed
+// validation
+type FormValidationError struct {
+	Fields []FieldError
+}
+
+func (e *FormValidationError) Error() string {
+	return fmt.Sprintf("%d invalid form fields", len(e.Fields))
+}
+
+// FormSubmission is a decoded form post
+type FormSubmission struct {
+	Values    map[string]string
+	UserAgent string
+	ClientIP  string
+}
+
+// Lead is a stored submission, in the shape of the Strapi lead content type
+type Lead struct {
+	Form        string            `json:"form"`
+	Name        string            `json:"name,omitempty"`
+	Email       string            `json:"email,omitempty"`
+	Phone       string            `json:"phone,omitempty"`
+	Company     string            `json:"company,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Data        map[string]string `json:"data"`
+	PageURL     string            `json:"pageUrl,omitempty"`
+	UserAgent   string            `json:"userAgent,omitempty"`
+	SubmittedAt time.Time         `json:"submittedAt"`
+
+	// DocumentID is assigned by Strapi
+	DocumentID string `json:"-"`
+}
+
+// FormService validates form submissions, stores them as Strapi leads and
+// notifies the team
+type FormService struct {
+	strapi *StrapiService
+	forms  map[string]FormSchema
+
+	spam      spamChecker
+	notifiers []LeadNotifier
+	jobs      *backgroundJobs
+}
+
+// spamChecker applies the honeypot and timing checks shared by every public
+// submission endpoint. The timing check reads a render token signed with
+// secret, so clients can neither skip it nor choose the render time.
+type spamChecker struct {
+	honeypot    string
+	minFillTime time.Duration
+	maxAge      time.Duration
+	secret      []byte
+}
+
+func newSpamChecker(cfg *config.Config) spamChecker {
+	return spamChecker{
+		honeypot:    cfg.FormsHoneypotField,
+		minFillTime: cfg.FormsMinFillTime,
+		maxAge:      cfg.FormsMaxAge,
+		secret:      formTokenSecret(cfg),
+	}
+}
+
+var (
+	randomTokenSecret     []byte
+	randomTokenSecretOnce sync.Once
+)
+
+// formTokenSecret returns FORMS_TOKEN_SECRET, or a random secret shared by
+// every service of this process when it is not set
+func formTokenSecret(cfg *config.Config) []byte {
+	if cfg.FormsTokenSecret != "" {
+		return []byte(cfg.FormsTokenSecret)
+	}
+
+	randomTokenSecretOnce.Do(func() {
+		// Tokens then only verify on the replica that issued them, and not
+		// after a restart
+		randomTokenSecret = make([]byte, 32)
+		if _, err := rand.Read(randomTokenSecret); err != nil {
+			log.Fatal().Err(err).Msg("Failed to generate form token secret")
+		}
+		log.Warn().Msg("FORMS_TOKEN_SECRET not set, using a random per-process secret")
+	})
+	return randomTokenSecret
+}
+
+func NewFormService(cfg *config.Config, strapi *StrapiService, mailer *Mailer) (*FormService, error) {
+	forms, err := LoadForms(cfg.FormsFile, cfg.FormsHoneypotField)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FormService{
+		strapi: strapi,
+		forms:  make(map[string]FormSchema, len(forms)),
+		spam:   newSpamChecker(cfg),
+		jobs:   newBackgroundJobs("form notifications", cfg.FormsNotifyTimeout),
+	}
+	for _, form := range forms {
+		s.forms[form.ID] = form
+	}
+
+	if mailer.Enabled() {
+		s.notifiers = append(s.notifiers, NewSMTPNotifier(cfg, mailer))
+	}
+	if cfg.FormsWebhookURL != "" {
+		s.notifiers = append(s.notifiers, NewLeadWebhookNotifier(cfg))
+	}
+
+	for _, n := range s.notifiers {
+		log.Info().Str("notifier", n.Name()).Msg("Lead notifier enabled")
+	}
+
+	return s, nil
+}
+
+// LoadForms reads a JSON array of form schemas from file, or returns the
+// built-in forms when file is empty
+func LoadForms(file, honeypot string) ([]FormSchema, error) {
+	forms := defaultForms
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		forms = nil
+		if err := json.Unmarshal(data, &forms); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", file, err)
+		}
+	}
+
+	compiled := make([]FormSchema, len(forms))
+	seen := make(map[string]bool, len(forms))
+	for i, form := range forms {
+		if form.ID == "" || seen[form.ID] {
+			return nil, fmt.Errorf("form %d: missing or duplicate id %q", i, form.ID)
+		}
+		seen[form.ID] = true
+
+		form.Fields = append([]FormField(nil), form.Fields...)
+		for j := range form.Fields {
+			if err := form.Fields[j].compile(honeypot); err != nil {
+				return nil, fmt.Errorf("form %s: %w", form.ID, err)
+			}
+		}
+		compiled[i] = form
+	}
+	return compiled, nil
+}
+
+func (f *FormField) compile(honeypot string) error {
+	if f.Name == "" || strings.HasPrefix(f.Name, "_") || f.Name == honeypot {
+		return fmt.Errorf("field %q: names must be set, must not start with _ and must not be the honeypot", f.Name)
+	}
+	if f.Type == "" {
+		f.Type = FieldText
+	}
+	if _, ok := defaultMaxLengths[f.Type]; !ok {
+		return fmt.Errorf("field %s: unknown type %q", f.Name, f.Type)
+	}
+	if f.Type == FieldSelect && len(f.Options) == 0 {
+		return fmt.Errorf("field %s: select fields need options", f.Name)
+	}
+	if f.MaxLength <= 0 {
+		f.MaxLength = defaultMaxLengths[f.Type]
+	}
+	switch f.LeadField {
+	case "", "name", "email", "phone", "company", "message":
+	default:
+		return fmt.Errorf("field %s: unknown lead_field %q", f.Name, f.LeadField)
+	}
+	if f.Pattern != "" {
+		pattern, err := regexp.Compile(f.Pattern)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		f.pattern = pattern
+	}
+	return nil
+}
+
+// Form returns the schema for id
+func (s *FormService) Form(id string) (FormSchema, bool) {
+	form, ok := s.forms[id]
+	return form, ok
+}
+
+// Submit validates a submission to form id, stores it as a Strapi lead and
+// starts notifications. It returns ErrUnknownForm, ErrSpam, a
+// *FormValidationError or a StrapiService error.
+func (s *FormService) Submit(ctx context.Context, id string, submission FormSubmission) error {
+	form, ok := s.forms[id]
+	if !ok {
+		return ErrUnknownForm
+	}
+
+	if reason := s.spam.reason(submission.Values); reason != "" {
+		metrics.FormSubmissions.WithLabelValues(id, "spam").Inc()
+		log.Info().
+			Str("form", id).
+			Str("reason", reason).
+			Str("ip", submission.ClientIP).
+			Msg("Dropped spam form submission")
+		return ErrSpam
+	}
+
+	lead, err := s.buildLead(form, submission)
+	if err != nil {
+		metrics.FormSubmissions.WithLabelValues(id, "invalid").Inc()
+		return err
+	}
+
+	resp, err := s.strapi.CreateEntry(ctx, leadContentType, lead)
+	if err != nil {
+		metrics.FormSubmissions.WithLabelValues(id, "failed").Inc()
+		return err
+	}
+
+	var created struct {
+		Data struct {
+			DocumentID string `json:"documentId"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &created); err == nil {
+		lead.DocumentID = created.Data.DocumentID
+	}
+
+	metrics.FormSubmissions.WithLabelValues(id, "accepted").Inc()
+	log.Info().Str("form", id).Str("lead", lead.DocumentID).Msg("Lead stored")
+
+	s.notify(form, lead)
+	return nil
+}
+
+// Token returns a render token for a form shown now, to be submitted in
+// FormTokenField. It is valid for every form, tour booking and job
+// application.
+func (s *FormService) Token() string {
+	return s.spam.issue(time.Now())
+}
+
+// issue returns a render token for the time at: the time in unix
+// milliseconds and its HMAC
+func (s spamChecker) issue(at time.Time) string {
+	ms := strconv.FormatInt(at.UnixMilli(), 10)
+	return ms + "." + s.sign(ms)
+}
+
+func (s spamChecker) sign(ms string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("form-token:" + ms))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// reason returns why a submission looks automated, or "" when it passes the
+// honeypot and timing checks
+func (s spamChecker) reason(values map[string]string) string {
+	if s.honeypot != "" && strings.TrimSpace(values[s.honeypot]) != "" {
+		return "honeypot"
+	}
+
+	token := values[FormTokenField]
+	if token == "" {
+		return "missing token"
+	}
+	raw, sig, _ := strings.Cut(token, ".")
+	ms, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(s.sign(raw))) {
+		return "invalid token"
+	}
+	elapsed := time.Since(time.UnixMilli(ms))
+	switch {
+	case elapsed < s.minFillTime:
+		return "filled too fast"
+	case s.maxAge > 0 && elapsed > s.maxAge:
+		return "form too old"
+	}
+	return ""
+}
+
+// buildLead validates the submission against form and maps it to a lead.
+// Values for fields the form does not define are dropped.
+func (s *FormService) buildLead(form FormSchema, submission FormSubmission) (Lead, error) {
+	lead := Lead{
+		Form:        form.ID,
+		Data:        make(map[string]string, len(form.Fields)),
+		PageURL:     truncate(submission.Values[FormPageField], 2048),
+		UserAgent:   truncate(submission.UserAgent, 512),
+		SubmittedAt: time.Now().UTC(),
+	}
+
+	var invalid []FieldError
+	for _, field := range form.Fields {
+		value, err := field.normalize(submission.Values[field.Name])
+		if err != nil {
+			invalid = append(invalid, FieldError{Field: field.Name, Message: err.Error()})
+			continue
+		}
+		if value == "" {
+			continue
+		}
+
+		lead.Data[field.Name] = value
+		switch field.LeadField {
+		case "name":
+			lead.Name = value
+		case "email":
+			lead.Email = value
+		case "phone":
+			lead.Phone = value
+		case "company":
+			lead.Company = value
+		case "message":
+			lead.Message = value
+		}
+	}
+
+	if len(invalid) > 0 {
+		return Lead{}, &FormValidationError{Fields: invalid}
+	}
+	return lead, nil
+}
+
+// normalize validates one submitted value and returns it in canonical form
+func (f FormField) normalize(value string) (string, error) {
+	value = strings.TrimSpace(value)
+
+	if f.Type == FieldCheckbox {
+		switch strings.ToLower(value) {
+		case "true", "on", "yes", "1":
+			value = "true"
+		case "", "false", "off", "no", "0":
+			value = ""
+		default:
+			return "", errors.New("must be true or false")
+		}
+	}
+
+	if value == "" {
+		if f.Required {
+			return "", errors.New("is required")
+		}
+		return "", nil
+	}
+
+	if utf8.RuneCountInString(value) > f.MaxLength {
+		return "", fmt.Errorf("must be at most %d characters", f.MaxLength)
+	}
+	if strings.ContainsFunc(value, isDisallowedControl(f.Type)) {
+		return "", errors.New("contains control characters")
+	}
+
+	switch f.Type {
+	case FieldEmail:
+		addr, err := mail.ParseAddress(value)
+		if err != nil || addr.Address != value {
+			return "", errors.New("must be an email address")
+		}
+	case FieldPhone:
+		if !phonePattern.MatchString(value) || countDigits(value) < 6 {
+			return "", errors.New("must be a phone number")
+		}
+	case FieldSelect:
+		if !contains(f.Options, value) {
+			return "", errors.New("must be one of the listed options")
+		}
+	case FieldNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", errors.New("must be a number")
+		}
+	}
+
+	if f.pattern != nil && !f.pattern.MatchString(value) {
+		return "", errors.New("has an invalid format")
+	}
+	return value, nil
+}
+
+// isDisallowedControl rejects control characters, except line breaks and
+// tabs in multi-line fields
+func isDisallowedControl(fieldType string) func(rune) bool {
+	return func(r rune) bool {
+		if fieldType == FieldTextarea && (r == '\n' || r == '\r' || r == '\t') {
+			return false
+		}
+		return r < 0x20 || r == 0x7f
+	}
+}
+
+func countDigits(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	return n
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max])
+}
+
+// notify sends lead to every notifier in the background
+func (s *FormService) notify(form FormSchema, lead Lead) {
+	for _, n := range s.notifiers {
+		n := n
+		s.jobs.Go(func(ctx context.Context) {
+			if err := n.Notify(ctx, form, lead); err != nil {
+				metrics.LeadNotifications.WithLabelValues(n.Name(), "error").Inc()
+				log.Error().Err(err).Str("notifier", n.Name()).Str("form", form.ID).Msg("Lead notification failed")
+				return
+			}
+			metrics.LeadNotifications.WithLabelValues(n.Name(), "success").Inc()
+		})
+	}
+}
+
+// Close waits for pending notifications until ctx expires, then cancels them
+func (s *FormService) Close(ctx context.Context) {
+	s.jobs.Close(ctx)
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+)
+
+// LeadNotifier tells the team about a stored lead
+type LeadNotifier interface {
+	Name() string
+	Notify(ctx context.Context, form FormSchema, lead Lead) error
+}
+
+// SMTPNotifier emails a plain-text summary of each lead
+type SMTPNotifier struct {
+	mailer     *Mailer
+	recipients []string
+}
+
+func NewSMTPNotifier(cfg *config.Config, mailer *Mailer) *SMTPNotifier {
+	return &SMTPNotifier{mailer: mailer, recipients: cfg.FormsNotifyEmails}
+}
+
+func (n *SMTPNotifier) Name() string { return "smtp" }
+
+func (n *SMTPNotifier) Notify(ctx context.Context, form FormSchema, lead Lead) error {
+	recipients := n.recipients
+	if len(form.Notify) > 0 {
+		recipients = form.Notify
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	from, err := n.mailer.From()
+	if err != nil {
+		return err
+	}
+	return n.mailer.Send(ctx, recipients, n.message(from, recipients, form, lead))
+}
+
+// message renders the email, listing fields in schema order. Submitted
+// values only appear in the body, apart from the validated email address
+// used as Reply-To.
+func (n *SMTPNotifier) message(from *mail.Address, recipients []string, form FormSchema, lead Lead) []byte {
+	var buf bytes.Buffer
+
+	subject := "New " + strings.ToLower(form.Title) + " submission"
+	if lead.Name != "" {
+		subject += " from " + lead.Name
+	}
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(recipients, ", "))
+	if lead.Email != "" {
+		fmt.Fprintf(&buf, "Reply-To: %s\r\n", (&mail.Address{Name: lead.Name, Address: lead.Email}).String())
+	}
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+
+	for _, field := range form.Fields {
+		value, ok := lead.Data[field.Name]
+		if !ok {
+			continue
+		}
+		label := field.Label
+		if label == "" {
+			label = field.Name
+		}
+		value = strings.ReplaceAll(value, "\n", "\r\n  ")
+		fmt.Fprintf(&buf, "%s: %s\r\n", label, value)
+	}
+
+	buf.WriteString("\r\n")
+	if lead.PageURL != "" {
+		fmt.Fprintf(&buf, "Page: %s\r\n", lead.PageURL)
+	}
+	fmt.Fprintf(&buf, "Submitted: %s\r\n", lead.SubmittedAt.Format(time.RFC3339))
+	if lead.DocumentID != "" {
+		fmt.Fprintf(&buf, "Lead: %s\r\n", lead.DocumentID)
+	}
+	return buf.Bytes()
+}
+
+// LeadWebhookNotifier posts each lead as JSON, signed like the analytics
+// webhook when a secret is set
+type LeadWebhookNotifier struct {
+	url        string
+	secret     []byte
+	httpClient *http.Client
+}
+
+// leadWebhookPayload is the body sent by LeadWebhookNotifier
+type leadWebhookPayload struct {
+	Event      string `json:"event"`
+	Form       string `json:"form"`
+	DocumentID string `json:"documentId,omitempty"`
+	Lead       Lead   `json:"lead"`
+}
+
+func NewLeadWebhookNotifier(cfg *config.Config) *LeadWebhookNotifier {
+	return &LeadWebhookNotifier{
+		url:        cfg.FormsWebhookURL,
+		secret:     []byte(cfg.FormsWebhookSecret),
+		httpClient: &http.Client{},
+	}
+}
+
+func (n *LeadWebhookNotifier) Name() string { return "webhook" }
+
+func (n *LeadWebhookNotifier) Notify(ctx context.Context, form FormSchema, lead Lead) error {
+	body, err := json.Marshal(leadWebhookPayload{
+		Event:      "lead.created",
+		Form:       form.ID,
+		DocumentID: lead.DocumentID,
+		Lead:       lead,
+	})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, n.httpClient, n.url, body, webhookSignature(n.secret, body))
+}
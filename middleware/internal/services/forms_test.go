@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+)
+
+// newTestStrapi returns a StrapiService backed by handler, with caching
+// disabled
+func newTestStrapi(t *testing.T, handler http.HandlerFunc) *StrapiService {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return NewStrapiService(&config.Config{StrapiURL: srv.URL, StrapiTimeout: 5 * time.Second}, &CacheService{})
+}
+
+// strapiAttributeTypes reads the attribute types of the Strapi content type
+// api/<name>
+func strapiAttributeTypes(t *testing.T, name string) map[string]string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "strapi", "src", "api", name, "content-types", name, "schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Attributes map[string]struct {
+			Type string `json:"type"`
+		} `json:"attributes"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]string, len(schema.Attributes))
+	for attr, def := range schema.Attributes {
+		types[attr] = def.Type
+	}
+	return types
+}
+
+func TestSpamCheckerReason(t *testing.T) {
+	checker := spamChecker{honeypot: "website", minFillTime: 3 * time.Second, maxAge: time.Hour, secret: []byte("test")}
+	other := checker
+	other.secret = []byte("other")
+	issued := func(c spamChecker, ago time.Duration) string {
+		return c.issue(time.Now().Add(-ago))
+	}
+	human := issued(checker, time.Minute)
+	ms, sig, _ := strings.Cut(human, ".")
+	backdated := strconv.FormatInt(time.Now().Add(-time.Hour).UnixMilli()+1000, 10) + "." + sig
+
+	tests := []struct {
+		name   string
+		values map[string]string
+		want   string
+	}{
+		{"human", map[string]string{FormTokenField: human}, ""},
+		{"no token", map[string]string{}, "missing token"},
+		{"old timestamp field only", map[string]string{"_ts": ms}, "missing token"},
+		{"honeypot", map[string]string{"website": "http://spam.example", FormTokenField: human}, "honeypot"},
+		{"blank honeypot", map[string]string{"website": "  ", FormTokenField: human}, ""},
+		{"unsigned", map[string]string{FormTokenField: ms}, "invalid token"},
+		{"not a time", map[string]string{FormTokenField: "yesterday." + sig}, "invalid token"},
+		{"backdated", map[string]string{FormTokenField: backdated}, "invalid token"},
+		{"other secret", map[string]string{FormTokenField: issued(other, time.Minute)}, "invalid token"},
+		{"filled too fast", map[string]string{FormTokenField: issued(checker, time.Second)}, "filled too fast"},
+		{"form too old", map[string]string{FormTokenField: issued(checker, 2*time.Hour)}, "form too old"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checker.reason(tt.values); got != tt.want {
+				t.Errorf("reason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormServiceSubmit(t *testing.T) {
+	var stored Lead
+	strapi := newTestStrapi(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Data Lead `json:"data"`
+		}
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("lead body: %v", err)
+		}
+		stored = body.Data
+		w.Write([]byte(`{"data":{"documentId":"lead1"}}`))
+	})
+	cfg := &config.Config{FormsHoneypotField: "website"}
+	forms, err := NewFormService(cfg, strapi, NewMailer(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pageURL := "https://www.clayworks.space/locations?" + strings.Repeat("q", 260)
+	userAgent := strings.Repeat("Mozilla/5.0 ", 30)
+	valid := func(extra map[string]string) map[string]string {
+		values := map[string]string{
+			"fullName":      "Asha Rao",
+			"email":         "asha@example.com",
+			"agreeToPolicy": "on",
+			FormPageField:   pageURL,
+			FormTokenField:  forms.Token(),
+		}
+		for key, value := range extra {
+			values[key] = value
+		}
+		return values
+	}
+
+	tests := []struct {
+		name    string
+		form    string
+		values  map[string]string
+		wantErr error
+	}{
+		{"long page URL", "contact", valid(nil), nil},
+		{"unknown form", "careers", valid(nil), ErrUnknownForm},
+		{"honeypot", "contact", valid(map[string]string{"website": "x"}), ErrSpam},
+		{"no token", "contact", valid(map[string]string{FormTokenField: ""}), ErrSpam},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored = Lead{}
+			err := forms.Submit(context.Background(), tt.form, FormSubmission{Values: tt.values, UserAgent: userAgent})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Submit() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if stored.PageURL != pageURL || stored.UserAgent != userAgent {
+				t.Errorf("stored pageUrl %d / userAgent %d chars, want %d / %d", len(stored.PageURL), len(stored.UserAgent), len(pageURL), len(userAgent))
+			}
+			if stored.Name != "Asha Rao" || stored.Data["agreeToPolicy"] != "true" {
+				t.Errorf("stored lead = %+v", stored)
+			}
+		})
+	}
+
+	var invalid *FormValidationError
+	err = forms.Submit(context.Background(), "contact", FormSubmission{Values: map[string]string{"email": "nope", FormTokenField: forms.Token()}})
+	if !errors.As(err, &invalid) || len(invalid.Fields) != 3 {
+		t.Errorf("Submit() error = %v, want 3 invalid fields", err)
+	}
+}
+
+func TestLeadSchemaFitsLongValues(t *testing.T) {
+	// pageUrl and userAgent are kept up to 2048 and 512 characters, beyond
+	// a Strapi string's 255
+	types := strapiAttributeTypes(t, "lead")
+	for _, attr := range []string{"pageUrl", "userAgent"} {
+		if types[attr] != "text" {
+			t.Errorf("lead %s type = %q, want text", attr, types[attr])
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+	"github.com/rs/zerolog/log"
+)
+
+// earthRadiusKm is the mean Earth radius used for haversine distances
+const earthRadiusKm = 6371.0088
+
+// locationIndexPageSize is the Strapi page size used to load the index
+const locationIndexPageSize = 100
+
+// NearbyQuery selects locations around a point. Zero filters match every
+// location.
+type NearbyQuery struct {
+	Lat      float64
+	Lng      float64
+	RadiusKm float64
+	Limit    int
+
+	// Amenities and Tags must all be present, compared case-insensitively
+	Amenities   []string
+	Tags        []string
+	PowerBackup *bool
+	MinSeats    int
+}
+
+// indexedLocation is a location with coordinates and the values the nearby
+// search filters on
+type indexedLocation struct {
+	entry       map[string]interface{}
+	lat, lng    float64
+	amenities   map[string]bool
+	tags        map[string]bool
+	powerBackup bool
+	seats       int
+}
+
+type locationSnapshot struct {
+	locations  []indexedLocation
+	generation uint64
+	builtAt    time.Time
+}
+
+// LocationIndex keeps the published locations in memory for nearby
+// searches. It is loaded through StrapiService's cached collection reads,
+// rebuilt every refresh interval, and marked stale by Invalidate so the next
+// search rebuilds it.
+type LocationIndex struct {
+	strapi   *StrapiService
+	interval time.Duration
+
+	current atomic.Pointer[locationSnapshot]
+	// generation is bumped by Invalidate; snapshots built from an older
+	// generation are stale
+	generation atomic.Uint64
+	// building serialises rebuilds
+	building sync.Mutex
+	trigger  chan struct{}
+}
+
+func NewLocationIndex(cfg *config.Config, strapi *StrapiService) *LocationIndex {
+	return &LocationIndex{
+		strapi:   strapi,
+		interval: cfg.LocationsIndexRefresh,
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// Nearby returns the locations matching q within q.RadiusKm of the point,
+// nearest first and at most q.Limit of them, each with a "distanceKm" field,
+// along with the number of matches before the limit
+func (x *LocationIndex) Nearby(ctx context.Context, q NearbyQuery) ([]map[string]interface{}, int, error) {
+	snapshot, err := x.snapshot(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	type match struct {
+		location *indexedLocation
+		distance float64
+	}
+	var matches []match
+	for i := range snapshot.locations {
+		location := &snapshot.locations[i]
+		if !location.matches(q) {
+			continue
+		}
+		distance := haversineKm(q.Lat, q.Lng, location.lat, location.lng)
+		if q.RadiusKm > 0 && distance > q.RadiusKm {
+			continue
+		}
+		matches = append(matches, match{location: location, distance: distance})
+	}
+
+	// Stable, so equidistant locations keep their CMS order
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].distance < matches[j].distance })
+
+	total := len(matches)
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+
+	results := make([]map[string]interface{}, len(matches))
+	for i, m := range matches {
+		result := make(map[string]interface{}, len(m.location.entry)+1)
+		for key, value := range m.location.entry {
+			result[key] = value
+		}
+		result["distanceKm"] = math.Round(m.distance*100) / 100
+		results[i] = result
+	}
+	return results, total, nil
+}
+
+func (l *indexedLocation) matches(q NearbyQuery) bool {
+	if q.PowerBackup != nil && l.powerBackup != *q.PowerBackup {
+		return false
+	}
+	if q.MinSeats > 0 && l.seats < q.MinSeats {
+		return false
+	}
+	for _, amenity := range q.Amenities {
+		if !l.amenities[strings.ToLower(amenity)] {
+			return false
+		}
+	}
+	for _, tag := range q.Tags {
+		if !l.tags[strings.ToLower(tag)] {
+			return false
+		}
+	}
+	return true
+}
+
+// haversineKm returns the great-circle distance between two points in
+// degrees
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Size returns the number of indexed locations
+func (x *LocationIndex) Size() int {
+	if snapshot := x.current.Load(); snapshot != nil {
+		return len(snapshot.locations)
+	}
+	return 0
+}
+
+// Age returns the time since the index was built, or 0 before the first build
+func (x *LocationIndex) Age() time.Duration {
+	if snapshot := x.current.Load(); snapshot != nil {
+		return time.Since(snapshot.builtAt)
+	}
+	return 0
+}
+
+// snapshot returns the current index, rebuilding it first when it is stale.
+// If the rebuild fails, a stale index is served rather than an error.
+func (x *LocationIndex) snapshot(ctx context.Context) (*locationSnapshot, error) {
+	snapshot := x.current.Load()
+	if snapshot != nil && snapshot.generation == x.generation.Load() {
+		return snapshot, nil
+	}
+
+	x.building.Lock()
+	defer x.building.Unlock()
+
+	// Another search may have rebuilt it meanwhile
+	snapshot = x.current.Load()
+	if snapshot != nil && snapshot.generation == x.generation.Load() {
+		return snapshot, nil
+	}
+
+	// Waiting searches share this build, so one client leaving must not
+	// cancel it
+	fresh, err := x.load(context.WithoutCancel(ctx))
+	if err != nil {
+		if snapshot != nil {
+			log.Warn().Err(err).Msg("Location index rebuild failed, serving stale index")
+			return snapshot, nil
+		}
+		return nil, err
+	}
+	return fresh, nil
+}
+
+// rebuild loads the index, keeping the previous one on failure
+func (x *LocationIndex) rebuild(ctx context.Context) error {
+	x.building.Lock()
+	defer x.building.Unlock()
+
+	_, err := x.load(ctx)
+	return err
+}
+
+// load reads every published location and installs the new index. Callers
+// hold building.
+func (x *LocationIndex) load(ctx context.Context) (*locationSnapshot, error) {
+	generation := x.generation.Load()
+
+	var locations []indexedLocation
+	skipped := 0
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("preset", "nearby")
+		query.Set("sort[0]", "order:asc")
+		query.Set("pagination[page]", strconv.Itoa(page))
+		query.Set("pagination[pageSize]", strconv.Itoa(locationIndexPageSize))
+
+		data, _, err := x.strapi.GetCollection(ctx, "locations", query)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Data []map[string]interface{} `json:"data"`
+			strapiPagination
+		}
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("%w: decoding locations: %w", ErrUpstreamUnavailable, err)
+		}
+		for _, entry := range result.Data {
+			location, ok := indexLocation(entry)
+			if !ok {
+				skipped++
+				continue
+			}
+			locations = append(locations, location)
+		}
+		if page >= result.Meta.Pagination.PageCount {
+			break
+		}
+	}
+
+	snapshot := &locationSnapshot{locations: locations, generation: generation, builtAt: time.Now()}
+	x.current.Store(snapshot)
+	log.Info().Int("locations", len(locations)).Int("without_coordinates", skipped).Msg("Location index built")
+	return snapshot, nil
+}
+
+// indexLocation reads the filter values of a location entry. Entries without
+// valid coordinates cannot be placed and are left out.
+func indexLocation(entry map[string]interface{}) (indexedLocation, bool) {
+	lat, latOK := entry["latitude"].(float64)
+	lng, lngOK := entry["longitude"].(float64)
+	if !latOK || !lngOK || math.Abs(lat) > 90 || math.Abs(lng) > 180 {
+		return indexedLocation{}, false
+	}
+
+	location := indexedLocation{
+		entry:     entry,
+		lat:       lat,
+		lng:       lng,
+		amenities: stringSet(entry["amenities"]),
+		tags:      stringSet(entry["tags"]),
+	}
+	location.powerBackup, _ = entry["hasPowerBackup"].(bool)
+	if seats, ok := entry["seats"].(float64); ok {
+		location.seats = int(seats)
+	}
+	return location, true
+}
+
+// stringSet lowercases the strings of a JSON array
+func stringSet(value interface{}) map[string]bool {
+	values, _ := value.([]interface{})
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			set[strings.ToLower(strings.TrimSpace(s))] = true
+		}
+	}
+	return set
+}
+
+// Invalidate marks the index stale after locations changed in Strapi. The
+// next search rebuilds it, and a background rebuild is scheduled.
+func (x *LocationIndex) Invalidate() {
+	x.generation.Add(1)
+	select {
+	case x.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run builds the index, then rebuilds it every refresh interval and after
+// each Invalidate until ctx is done
+func (x *LocationIndex) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if x.interval > 0 {
+		ticker := time.NewTicker(x.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		if err := x.rebuild(ctx); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("Location index refresh failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-x.trigger:
+		}
+	}
+}
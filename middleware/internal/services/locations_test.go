@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHaversineKm(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lng1, lat2, lng2 float64
+		want                   float64
+	}{
+		{"same point", 12.9716, 77.5946, 12.9716, 77.5946, 0},
+		{"one degree of the equator", 0, 0, 0, 1, 111.195},
+		{"one degree of a meridian", 10, 77, 11, 77, 111.195},
+		{"Bengaluru to Mumbai", 12.9716, 77.5946, 19.0760, 72.8777, 845.3},
+		{"across the antimeridian", 0, 179.5, 0, -179.5, 111.195},
+		{"pole to pole", 90, 0, -90, 0, math.Pi * earthRadiusKm},
+		{"antipodes", 0, 0, 0, 180, math.Pi * earthRadiusKm},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := haversineKm(tt.lat1, tt.lng1, tt.lat2, tt.lng2)
+			if math.Abs(got-tt.want) > 0.5 {
+				t.Errorf("haversineKm() = %.3f, want %.3f", got, tt.want)
+			}
+			if back := haversineKm(tt.lat2, tt.lng2, tt.lat1, tt.lng1); math.Abs(back-got) > 1e-9 {
+				t.Errorf("haversineKm() is not symmetric: %.6f and %.6f", got, back)
+			}
+		})
+	}
+}
+
+func TestIndexLocation(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+		ok    bool
+		want  indexedLocation
+	}{
+		{
+			name:  "full",
+			entry: `{"latitude":12.97,"longitude":77.64,"amenities":[" WiFi ","Parking",3],"tags":["Premium"],"hasPowerBackup":true,"seats":120}`,
+			ok:    true,
+			want:  indexedLocation{lat: 12.97, lng: 77.64, amenities: map[string]bool{"wifi": true, "parking": true}, tags: map[string]bool{"premium": true}, powerBackup: true, seats: 120},
+		},
+		{
+			name:  "coordinates only",
+			entry: `{"latitude":-33.86,"longitude":151.2}`,
+			ok:    true,
+			want:  indexedLocation{lat: -33.86, lng: 151.2, amenities: map[string]bool{}, tags: map[string]bool{}},
+		},
+		{name: "no coordinates", entry: `{"name":"Remote"}`},
+		{name: "null longitude", entry: `{"latitude":12.97,"longitude":null}`},
+		{name: "string coordinates", entry: `{"latitude":"12.97","longitude":"77.64"}`},
+		{name: "latitude out of range", entry: `{"latitude":95,"longitude":77.64}`},
+		{name: "longitude out of range", entry: `{"latitude":12.97,"longitude":-181}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entry map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.entry), &entry); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := indexLocation(entry)
+			if ok != tt.ok {
+				t.Fatalf("indexLocation() ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			got.entry = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("indexLocation() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testLocations are served over two pages by newTestLocationIndex
+var testLocations = [][]map[string]interface{}{
+	{
+		{"slug": "indiranagar", "latitude": 12.9784, "longitude": 77.6408, "amenities": []string{"WiFi", "Parking"}, "tags": []string{"premium"}, "hasPowerBackup": true, "seats": 120},
+		{"slug": "koramangala", "latitude": 12.9352, "longitude": 77.6245, "amenities": []string{"wifi"}, "seats": 60},
+		{"slug": "remote", "name": "No coordinates"},
+	},
+	{
+		{"slug": "whitefield", "latitude": 12.9698, "longitude": 77.7500, "amenities": []string{"WiFi", "Cafeteria"}, "hasPowerBackup": true, "seats": 200},
+		{"slug": "bkc", "latitude": 19.0607, "longitude": 72.8633},
+	},
+}
+
+func newTestLocationIndex(t *testing.T, fail *atomic.Bool, loads *atomic.Int32) *LocationIndex {
+	t.Helper()
+	strapi := newTestStrapi(t, func(w http.ResponseWriter, r *http.Request) {
+		if fail != nil && fail.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		page := 1
+		if r.URL.Query().Get("pagination[page]") == "2" {
+			page = 2
+		} else if loads != nil {
+			loads.Add(1)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": testLocations[page-1],
+			"meta": map[string]interface{}{"pagination": map[string]int{"page": page, "pageCount": len(testLocations)}},
+		})
+	})
+	return &LocationIndex{strapi: strapi, trigger: make(chan struct{}, 1)}
+}
+
+func TestLocationIndexNearby(t *testing.T) {
+	index := newTestLocationIndex(t, nil, nil)
+	yes, no := true, false
+	// MG Road, Bengaluru
+	origin := NearbyQuery{Lat: 12.9756, Lng: 77.6050}
+	with := func(change func(q *NearbyQuery)) NearbyQuery {
+		q := origin
+		change(&q)
+		return q
+	}
+
+	tests := []struct {
+		name  string
+		query NearbyQuery
+		want  []string
+		total int
+	}{
+		{"everything, nearest first", origin, []string{"indiranagar", "koramangala", "whitefield", "bkc"}, 4},
+		{"radius", with(func(q *NearbyQuery) { q.RadiusKm = 10 }), []string{"indiranagar", "koramangala"}, 2},
+		{"limit", with(func(q *NearbyQuery) { q.Limit = 2 }), []string{"indiranagar", "koramangala"}, 4},
+		{"amenity, any case", with(func(q *NearbyQuery) { q.Amenities = []string{"WIFI"} }), []string{"indiranagar", "koramangala", "whitefield"}, 3},
+		{"all amenities", with(func(q *NearbyQuery) { q.Amenities = []string{"wifi", "parking"} }), []string{"indiranagar"}, 1},
+		{"tag", with(func(q *NearbyQuery) { q.Tags = []string{"Premium"} }), []string{"indiranagar"}, 1},
+		{"power backup", with(func(q *NearbyQuery) { q.PowerBackup = &yes }), []string{"indiranagar", "whitefield"}, 2},
+		{"no power backup", with(func(q *NearbyQuery) { q.PowerBackup = &no }), []string{"koramangala", "bkc"}, 2},
+		{"min seats", with(func(q *NearbyQuery) { q.MinSeats = 100 }), []string{"indiranagar", "whitefield"}, 2},
+		{"nothing in range", with(func(q *NearbyQuery) { q.Lat, q.Lng, q.RadiusKm = 28.6139, 77.2090, 50 }), []string{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, total, err := index.Nearby(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("Nearby() error = %v", err)
+			}
+			slugs := make([]string, len(results))
+			for i, result := range results {
+				slugs[i], _ = result["slug"].(string)
+				distance, _ := result["distanceKm"].(float64)
+				if distance != math.Round(distance*100)/100 || (tt.query.RadiusKm > 0 && distance > tt.query.RadiusKm) {
+					t.Errorf("%s distanceKm = %v", slugs[i], distance)
+				}
+			}
+			if !reflect.DeepEqual(slugs, tt.want) || total != tt.total {
+				t.Errorf("Nearby() = %v (total %d), want %v (total %d)", slugs, total, tt.want, tt.total)
+			}
+		})
+	}
+
+	if index.Size() != 4 {
+		t.Errorf("Size() = %d, want 4 locations with coordinates", index.Size())
+	}
+	for _, location := range index.current.Load().locations {
+		if _, ok := location.entry["distanceKm"]; ok {
+			t.Fatal("Nearby() wrote distanceKm into the index")
+		}
+	}
+}
+
+func TestLocationIndexInvalidate(t *testing.T) {
+	var fail atomic.Bool
+	var loads atomic.Int32
+	index := newTestLocationIndex(t, &fail, &loads)
+	ctx := context.Background()
+
+	steps := []struct {
+		name       string
+		invalidate bool
+		fail       bool
+		wantLoads  int32
+	}{
+		{name: "first search builds", wantLoads: 1},
+		{name: "fresh index is reused", wantLoads: 1},
+		{name: "invalidated index is rebuilt", invalidate: true, wantLoads: 2},
+		{name: "stale index served when Strapi fails", invalidate: true, fail: true, wantLoads: 2},
+	}
+
+	for _, step := range steps {
+		if step.invalidate {
+			index.Invalidate()
+		}
+		fail.Store(step.fail)
+		results, _, err := index.Nearby(ctx, NearbyQuery{Lat: 12.9756, Lng: 77.6050})
+		if err != nil || len(results) != 4 {
+			t.Errorf("%s: Nearby() = %d results, %v", step.name, len(results), err)
+		}
+		if got := loads.Load(); got != step.wantLoads {
+			t.Errorf("%s: %d loads, want %d", step.name, got, step.wantLoads)
+		}
+	}
+}
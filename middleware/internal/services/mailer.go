@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+
+	"github.com/clayworks/middleware/internal/config"
+)
+
+// Mailer delivers prepared messages over SMTP. STARTTLS is used when the
+// server offers it, and credentials are only sent when set.
+type Mailer struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+func NewMailer(cfg *config.Config) *Mailer {
+	return &Mailer{
+		addr:     net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+		host:     cfg.SMTPHost,
+		username: cfg.SMTPUsername,
+		password: cfg.SMTPPassword,
+		from:     cfg.SMTPFrom,
+	}
+}
+
+// Enabled reports whether an SMTP host is configured
+func (m *Mailer) Enabled() bool {
+	return m.host != ""
+}
+
+// From returns the parsed sender address
+func (m *Mailer) From() (*mail.Address, error) {
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_FROM: %w", err)
+	}
+	return from, nil
+}
+
+// Send delivers msg, a complete message with headers, to recipients. The
+// connection is bounded by ctx.
+func (m *Mailer) Send(ctx context.Context, recipients []string, msg []byte) error {
+	from, err := m.From()
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return err
+		}
+	}
+	if m.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, rcpt := range recipients {
+		if err := client.Rcpt(strings.TrimSpace(rcpt)); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+	"github.com/clayworks/middleware/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+// PrivacyFilter enforces visitor consent and strips or pseudonymizes personal
+// data before analytics events reach any provider.
+type PrivacyFilter struct {
+	consentRequired     bool
+	consentHeader       string
+	consentCookie       string
+	marketingCategories map[string]bool
+	honorDNT            bool
+	ipv4Mask            net.IPMask
+	ipv6Mask            net.IPMask
+	secret              []byte
+	rotation            time.Duration
+	piiKeys             map[string]bool
+}
+
+func NewPrivacyFilter(cfg *config.Config) *PrivacyFilter {
+	secret := []byte(cfg.AnalyticsHashSecret)
+	if len(secret) == 0 {
+		// A per-process secret still pseudonymizes IDs, but hashes will not
+		// match across replicas or restarts
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatal().Err(err).Msg("Failed to generate analytics hash secret")
+		}
+		log.Warn().Msg("ANALYTICS_HASH_SECRET not set, using a random per-process secret")
+	}
+
+	rotation := cfg.AnalyticsSaltRotation
+	if rotation <= 0 {
+		rotation = 24 * time.Hour
+	}
+
+	return &PrivacyFilter{
+		consentRequired:     cfg.AnalyticsConsentRequired,
+		consentHeader:       cfg.AnalyticsConsentHeader,
+		consentCookie:       cfg.AnalyticsConsentCookie,
+		marketingCategories: toSet(cfg.AnalyticsMarketingCategories),
+		honorDNT:            cfg.AnalyticsHonorDNT,
+		ipv4Mask:            net.CIDRMask(cfg.AnalyticsIPv4MaskBits, 32),
+		ipv6Mask:            net.CIDRMask(cfg.AnalyticsIPv6MaskBits, 128),
+		secret:              secret,
+		rotation:            rotation,
+		piiKeys:             toSet(cfg.AnalyticsPIIKeys),
+	}
+}
+
+// ClientContext extracts the privacy-relevant signals from an inbound request
+// made from clientIP, which the caller resolves
+func (p *PrivacyFilter) ClientContext(r *http.Request, clientIP string) models.ClientContext {
+	consent := r.Header.Get(p.consentHeader)
+	if consent == "" && p.consentCookie != "" {
+		if cookie, err := r.Cookie(p.consentCookie); err == nil {
+			consent = cookie.Value
+		}
+	}
+
+	return models.ClientContext{
+		IP:        clientIP,
+		UserAgent: r.UserAgent(),
+		DNT:       r.Header.Get("DNT") == "1",
+		GPC:       r.Header.Get("Sec-GPC") == "1",
+		Consent:   ParseConsent(consent),
+	}
+}
+
+// ParseConsent parses a consent signal such as "analytics,marketing",
+// "analytics=granted;marketing=denied", "all" or "none".
+func ParseConsent(value string) models.Consent {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return models.Consent{}
+	}
+
+	consent := models.Consent{Present: true}
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '|'
+	})
+
+	for _, field := range fields {
+		name, state, hasState := strings.Cut(field, "=")
+		if !hasState {
+			name, state, hasState = strings.Cut(field, ":")
+		}
+
+		granted := true
+		if hasState {
+			switch strings.ToLower(strings.TrimSpace(state)) {
+			case "granted", "true", "yes", "1", "on":
+				granted = true
+			default:
+				granted = false
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "analytics", "statistics":
+			consent.Analytics = granted
+		case "marketing", "advertising":
+			consent.Marketing = granted
+		case "all":
+			consent.Analytics = granted
+			consent.Marketing = granted
+		case "none":
+			consent.Analytics = false
+			consent.Marketing = false
+		}
+	}
+
+	return consent
+}
+
+// Allow reports whether an event may be tracked, and if not, why
+func (p *PrivacyFilter) Allow(event models.AnalyticsEvent) (bool, string) {
+	client := event.Client
+	marketing := p.marketingCategories[strings.ToLower(event.Category)]
+
+	if p.honorDNT {
+		if client.DNT {
+			return false, "dnt"
+		}
+		if client.GPC && marketing {
+			return false, "gpc"
+		}
+	}
+
+	if client.Consent.Present {
+		if marketing && !client.Consent.Marketing {
+			return false, "consent_denied"
+		}
+		if !marketing && !client.Consent.Analytics {
+			return false, "consent_denied"
+		}
+		return true, ""
+	}
+
+	if p.consentRequired {
+		return false, "no_consent"
+	}
+
+	return true, ""
+}
+
+// Apply anonymizes the client IP, pseudonymizes user and session IDs and
+// removes configured PII property keys in place. The salt period follows the
+// time the event is received, not its client-supplied timestamp, so a
+// client cannot pin one salt and link IDs across periods.
+func (p *PrivacyFilter) Apply(event *models.AnalyticsEvent) {
+	event.Client.IP = p.AnonymizeIP(event.Client.IP)
+
+	received := time.Now()
+	if event.UserID != "" {
+		event.UserID = p.hashID(event.UserID, received)
+	}
+	if event.SessionID != "" {
+		event.SessionID = p.hashID(event.SessionID, received)
+	}
+
+	if len(event.Properties) > 0 {
+		event.Properties = p.stripPII(event.Properties)
+	}
+}
+
+// AnonymizeIP truncates an address to the configured network prefix
+func (p *PrivacyFilter) AnonymizeIP(value string) string {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return ""
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(p.ipv4Mask).String()
+	}
+
+	return ip.Mask(p.ipv6Mask).String()
+}
+
+// hashID derives a keyed hash of id using a salt that rotates every
+// rotation period, so IDs cannot be linked across periods.
+func (p *PrivacyFilter) hashID(id string, at time.Time) string {
+	var period [8]byte
+	binary.BigEndian.PutUint64(period[:], uint64(at.UTC().UnixNano()/int64(p.rotation)))
+
+	saltMac := hmac.New(sha256.New, p.secret)
+	saltMac.Write(period[:])
+	salt := saltMac.Sum(nil)
+
+	idMac := hmac.New(sha256.New, salt)
+	idMac.Write([]byte(id))
+	return hex.EncodeToString(idMac.Sum(nil))[:32]
+}
+
+func (p *PrivacyFilter) stripPII(props map[string]interface{}) map[string]interface{} {
+	clean := make(map[string]interface{}, len(props))
+	for key, value := range props {
+		if p.piiKeys[strings.ToLower(key)] {
+			continue
+		}
+		clean[key] = p.stripValue(value)
+	}
+	return clean
+}
+
+// stripValue removes PII keys from objects nested in value, including
+// objects inside arrays
+func (p *PrivacyFilter) stripValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return p.stripPII(v)
+	case []interface{}:
+		clean := make([]interface{}, len(v))
+		for i, item := range v {
+			clean[i] = p.stripValue(item)
+		}
+		return clean
+	}
+	return value
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
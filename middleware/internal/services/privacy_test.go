@@ -0,0 +1,192 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+	"github.com/clayworks/middleware/internal/models"
+)
+
+func testPrivacyFilter(cfg config.Config) *PrivacyFilter {
+	cfg.AnalyticsHashSecret = "test-secret"
+	cfg.AnalyticsSaltRotation = 24 * time.Hour
+	if cfg.AnalyticsIPv4MaskBits == 0 {
+		cfg.AnalyticsIPv4MaskBits = 24
+	}
+	if cfg.AnalyticsIPv6MaskBits == 0 {
+		cfg.AnalyticsIPv6MaskBits = 48
+	}
+	if cfg.AnalyticsPIIKeys == nil {
+		cfg.AnalyticsPIIKeys = []string{"email", "phone"}
+	}
+	return NewPrivacyFilter(&cfg)
+}
+
+func TestStripPII(t *testing.T) {
+	p := testPrivacyFilter(config.Config{})
+
+	tests := []struct {
+		name  string
+		props map[string]interface{}
+		want  map[string]interface{}
+	}{
+		{
+			name:  "top level",
+			props: map[string]interface{}{"Email": "x@y.com", "path": "/"},
+			want:  map[string]interface{}{"path": "/"},
+		},
+		{
+			name:  "nested object",
+			props: map[string]interface{}{"user": map[string]interface{}{"phone": "123", "plan": "pro"}},
+			want:  map[string]interface{}{"user": map[string]interface{}{"plan": "pro"}},
+		},
+		{
+			name: "objects in array",
+			props: map[string]interface{}{"items": []interface{}{
+				map[string]interface{}{"email": "x@y.com", "sku": "desk"},
+				map[string]interface{}{"sku": "cabin"},
+			}},
+			want: map[string]interface{}{"items": []interface{}{
+				map[string]interface{}{"sku": "desk"},
+				map[string]interface{}{"sku": "cabin"},
+			}},
+		},
+		{
+			name: "nested arrays",
+			props: map[string]interface{}{"rows": []interface{}{
+				[]interface{}{map[string]interface{}{"phone": "123", "n": 1.0}},
+				"plain",
+			}},
+			want: map[string]interface{}{"rows": []interface{}{
+				[]interface{}{map[string]interface{}{"n": 1.0}},
+				"plain",
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.stripPII(tt.props); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stripPII() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	p := testPrivacyFilter(config.Config{})
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"203.0.113.77", "203.0.113.0"},
+		{"2001:db8:abcd:12::1", "2001:db8:abcd::"},
+		{"::ffff:203.0.113.77", "203.0.113.0"},
+		{"not-an-ip", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := p.AnonymizeIP(tt.ip); got != tt.want {
+			t.Errorf("AnonymizeIP(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestParseConsent(t *testing.T) {
+	tests := []struct {
+		value string
+		want  models.Consent
+	}{
+		{"", models.Consent{}},
+		{"analytics", models.Consent{Present: true, Analytics: true}},
+		{"analytics,marketing", models.Consent{Present: true, Analytics: true, Marketing: true}},
+		{"analytics=granted;marketing=denied", models.Consent{Present: true, Analytics: true}},
+		{"statistics:true|advertising:false", models.Consent{Present: true, Analytics: true}},
+		{"all", models.Consent{Present: true, Analytics: true, Marketing: true}},
+		{"none", models.Consent{Present: true}},
+	}
+
+	for _, tt := range tests {
+		if got := ParseConsent(tt.value); got != tt.want {
+			t.Errorf("ParseConsent(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPrivacyAllow(t *testing.T) {
+	p := testPrivacyFilter(config.Config{
+		AnalyticsHonorDNT:            true,
+		AnalyticsMarketingCategories: []string{"ads"},
+	})
+	strict := testPrivacyFilter(config.Config{AnalyticsConsentRequired: true})
+
+	tests := []struct {
+		name     string
+		filter   *PrivacyFilter
+		category string
+		client   models.ClientContext
+		allowed  bool
+		reason   string
+	}{
+		{"no signal", p, "", models.ClientContext{}, true, ""},
+		{"dnt", p, "", models.ClientContext{DNT: true}, false, "dnt"},
+		{"gpc blocks marketing", p, "ads", models.ClientContext{GPC: true}, false, "gpc"},
+		{"gpc allows analytics", p, "", models.ClientContext{GPC: true}, true, ""},
+		{"analytics consent", p, "", models.ClientContext{Consent: models.Consent{Present: true, Analytics: true}}, true, ""},
+		{"marketing denied", p, "ads", models.ClientContext{Consent: models.Consent{Present: true, Analytics: true}}, false, "consent_denied"},
+		{"consent required", strict, "", models.ClientContext{}, false, "no_consent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, reason := tt.filter.Allow(models.AnalyticsEvent{Category: tt.category, Client: tt.client})
+			if allowed != tt.allowed || reason != tt.reason {
+				t.Errorf("Allow() = %v, %q, want %v, %q", allowed, reason, tt.allowed, tt.reason)
+			}
+		})
+	}
+}
+
+func TestHashIDRotates(t *testing.T) {
+	p := testPrivacyFilter(config.Config{})
+	day := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	if p.hashID("s1", day) != p.hashID("s1", day.Add(time.Hour)) {
+		t.Error("hashID changed within a rotation period")
+	}
+	if p.hashID("s1", day) == p.hashID("s1", day.Add(24*time.Hour)) {
+		t.Error("hashID did not change across rotation periods")
+	}
+	if p.hashID("s1", day) == p.hashID("s2", day) {
+		t.Error("hashID collided for different IDs")
+	}
+}
+
+func TestApplyIgnoresClientTimestamp(t *testing.T) {
+	p := testPrivacyFilter(config.Config{})
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+	}{
+		{"unset", time.Time{}},
+		{"current", now},
+		{"pinned in the past", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"far future", now.AddDate(5, 0, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := models.AnalyticsEvent{UserID: "u1", SessionID: "s1", Timestamp: tt.timestamp}
+			p.Apply(&event)
+			if event.UserID != p.hashID("u1", now) || event.SessionID != p.hashID("s1", now) {
+				t.Errorf("Apply() hashed IDs in the salt period of %s, want the current one", tt.timestamp)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/clayworks/middleware/internal/metrics"
+	"github.com/go-chi/httprate"
+	"github.com/rs/zerolog/log"
+)
+
+// rateLimitFallbackCooldown is how long a limiter counts locally after a
+// Redis error before trying Redis again
+const rateLimitFallbackCooldown = 5 * time.Second
+
+// RedisLimitCounter is an httprate.LimitCounter that keeps the per-window
+// counts in Redis, so every gateway replica shares them and they survive
+// deploys. While Redis is unavailable it counts in process memory instead.
+type RedisLimitCounter struct {
+	cache  *CacheService
+	name   string
+	window time.Duration
+	local  httprate.LimitCounter
+
+	// downUntil is the unix nano time until which Redis is skipped
+	downUntil atomic.Int64
+}
+
+var _ httprate.LimitCounter = (*RedisLimitCounter)(nil)
+
+// NewRedisLimitCounter returns a counter for the named limiter. Without a
+// Redis connection it only ever counts locally.
+func NewRedisLimitCounter(cache *CacheService, name string) *RedisLimitCounter {
+	return &RedisLimitCounter{cache: cache, name: name}
+}
+
+func (c *RedisLimitCounter) Config(requestLimit int, windowLength time.Duration) {
+	c.window = windowLength
+	c.local = httprate.NewLocalLimitCounter(windowLength)
+}
+
+func (c *RedisLimitCounter) Increment(key string, currentWindow time.Time) error {
+	return c.IncrementBy(key, currentWindow, 1)
+}
+
+// IncrementBy always updates the local counter too, so a fallback starts from
+// this replica's recent traffic rather than from zero
+func (c *RedisLimitCounter) IncrementBy(key string, currentWindow time.Time, amount int) error {
+	c.local.IncrementBy(key, currentWindow, amount)
+
+	if !c.useRedis() {
+		return nil
+	}
+
+	ctx, cancel := c.cache.withTimeout(context.Background())
+	defer cancel()
+
+	redisKey := c.key(key, currentWindow)
+	pipe := c.cache.client.TxPipeline()
+	pipe.IncrBy(ctx, redisKey, int64(amount))
+	// The previous window is still read for the sliding estimate
+	pipe.Expire(ctx, redisKey, 2*c.window+time.Second)
+	if _, err := pipe.Exec(ctx); err != nil {
+		c.fallback(err)
+	}
+
+	return nil
+}
+
+func (c *RedisLimitCounter) Get(key string, currentWindow, previousWindow time.Time) (int, int, error) {
+	if c.useRedis() {
+		ctx, cancel := c.cache.withTimeout(context.Background())
+		defer cancel()
+
+		values, err := c.cache.client.MGet(ctx, c.key(key, currentWindow), c.key(key, previousWindow)).Result()
+		if err == nil {
+			return counterValue(values[0]), counterValue(values[1]), nil
+		}
+		c.fallback(err)
+	}
+
+	return c.local.Get(key, currentWindow, previousWindow)
+}
+
+func (c *RedisLimitCounter) key(key string, window time.Time) string {
+	return fmt.Sprintf("ratelimit:%s:%s:%d", c.name, key, window.Unix())
+}
+
+func (c *RedisLimitCounter) useRedis() bool {
+	return c.cache.client != nil && time.Now().UnixNano() >= c.downUntil.Load()
+}
+
+// fallback switches to local counting for rateLimitFallbackCooldown
+func (c *RedisLimitCounter) fallback(err error) {
+	until := time.Now().Add(rateLimitFallbackCooldown).UnixNano()
+	if previous := c.downUntil.Swap(until); previous < time.Now().UnixNano() {
+		log.Warn().Err(err).Str("limiter", c.name).Msg("Rate limiter falling back to local counting")
+		metrics.RateLimitFallbacks.WithLabelValues(c.name).Inc()
+	}
+}
+
+// counterValue reads an MGET result, where a missing key is nil
+func counterValue(value interface{}) int {
+	s, ok := value.(string)
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -1,14 +1,33 @@
 package services
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/clayworks/middleware/internal/config"
+	"github.com/clayworks/middleware/internal/metrics"
+	"github.com/clayworks/middleware/internal/tracing"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type StrapiService struct {
@@ -16,129 +35,583 @@ type StrapiService struct {
 	token      string
 	httpClient *http.Client
 	cache      *CacheService
+	refreshing sync.Map
+
+	breaker        *CircuitBreaker
+	maxRetries     int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+
+	attemptTimeout time.Duration
+	requestBudget  time.Duration
+	uploadTimeout  time.Duration
+	healthTimeout  time.Duration
+
+	negativeTTL time.Duration
 }
 
 func NewStrapiService(cfg *config.Config, cache *CacheService) *StrapiService {
 	return &StrapiService{
 		baseURL: cfg.StrapiURL,
 		token:   cfg.StrapiToken,
-		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
-		cache: cache,
+		// Deadlines come from the request context and per-attempt budgets
+		httpClient: &http.Client{},
+		cache:      cache,
+		breaker: NewCircuitBreaker("strapi",
+			cfg.StrapiBreakerThreshold,
+			cfg.StrapiBreakerOpenTimeout,
+			cfg.StrapiBreakerHalfOpenMax,
+		),
+		maxRetries:     cfg.StrapiMaxRetries,
+		retryBaseDelay: cfg.StrapiRetryBaseDelay,
+		retryMaxDelay:  cfg.StrapiRetryMaxDelay,
+		attemptTimeout: cfg.StrapiTimeout,
+		requestBudget:  cfg.StrapiRequestBudget,
+		uploadTimeout:  cfg.StrapiUploadTimeout,
+		healthTimeout:  cfg.HealthCheckTimeout,
+		negativeTTL:    cfg.CacheNegativeTTL,
 	}
 }
 
-func (s *StrapiService) GetCollection(contentType string, query url.Values) ([]byte, bool, error) {
+// GetCollection lists contentType. A preset parameter in query is expanded
+// into Strapi populate/fields parameters; see expandPreset.
+//
+// GetCollection, GetSingle and GetPreview only read registered content
+// types and return ErrUnknownContentType for others, so the proxy cannot
+// reach collections the gateway writes, such as tour bookings, with the
+// shared token.
+func (s *StrapiService) GetCollection(ctx context.Context, contentType string, query url.Values) ([]byte, bool, error) {
+	if err := checkContentType(contentType); err != nil {
+		return nil, false, err
+	}
+	query, _, err := expandPreset(contentType, query)
+	if err != nil {
+		return nil, false, err
+	}
+
 	cacheKey := fmt.Sprintf("collection:%s:%s", contentType, query.Encode())
 
-	// Check cache first
-	if cached, found := s.cache.Get(cacheKey); found {
-		log.Debug().Str("type", contentType).Msg("Cache hit")
-		return cached, true, nil
+	endpoint := fmt.Sprintf("%s/api/%s", s.baseURL, contentType)
+	if len(query) > 0 {
+		endpoint = fmt.Sprintf("%s?%s", endpoint, query.Encode())
 	}
 
-	// Fetch from Strapi
-	endpoint := fmt.Sprintf("%s/api/%s", s.baseURL, contentType)
+	return s.cachedFetch(ctx, contentType, cacheKey, endpoint, nil)
+}
+
+func (s *StrapiService) GetSingle(ctx context.Context, contentType string, id string, query url.Values) ([]byte, bool, error) {
+	if err := checkContentType(contentType); err != nil {
+		return nil, false, err
+	}
+	query, _, err := expandPreset(contentType, query)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cacheKey := fmt.Sprintf("single:%s:%s:%s", contentType, id, query.Encode())
+
+	endpoint := fmt.Sprintf("%s/api/%s/%s", s.baseURL, contentType, id)
 	if len(query) > 0 {
 		endpoint = fmt.Sprintf("%s?%s", endpoint, query.Encode())
 	}
 
-	data, err := s.fetch(endpoint, false)
+	return s.cachedFetch(ctx, contentType, cacheKey, endpoint, nil)
+}
+
+func (s *StrapiService) GetPreview(ctx context.Context, contentType string, id string) ([]byte, error) {
+	if err := checkContentType(contentType); err != nil {
+		return nil, err
+	}
+	// Previews are never cached
+	endpoint := fmt.Sprintf("%s/api/%s/%s?publicationState=preview", s.baseURL, contentType, id)
+	return s.fetch(ctx, endpoint, true)
+}
+
+func checkContentType(contentType string) error {
+	if _, ok := LookupContentType(contentType); !ok {
+		return fmt.Errorf("%w %q", ErrUnknownContentType, contentType)
+	}
+	return nil
+}
+
+// GetPageBySlug returns the single page with slug as {"data": {...}, "meta": {}}
+func (s *StrapiService) GetPageBySlug(ctx context.Context, slug, preset string) ([]byte, bool, error) {
+	pages, _ := LookupContentType("pages")
+	return s.GetBySlug(ctx, pages, slug, preset)
+}
+
+// GetBySlug returns the single entry of ct with slug as {"data": {...}, "meta": {}},
+// shaped by the named preset or the type's default. It returns ErrNotFound
+// when no entry matches and ErrDuplicateSlug when more than one does.
+func (s *StrapiService) GetBySlug(ctx context.Context, ct ContentType, slug, preset string) ([]byte, bool, error) {
+	query := url.Values{"preset": {preset}}
+	query, preset, err := expandPreset(ct.Name, query)
 	if err != nil {
 		return nil, false, err
 	}
+	query.Set(fmt.Sprintf("filters[%s][$eq]", ct.SlugField), slug)
 
-	// Cache the response
-	s.cache.SetRaw(cacheKey, data)
+	cacheKey := fmt.Sprintf("slug:%s:%s:%s", ct.Name, preset, slug)
+	endpoint := fmt.Sprintf("%s/api/%s?%s", s.baseURL, ct.Name, query.Encode())
 
-	return data, false, nil
+	return s.cachedFetch(ctx, ct.Name, cacheKey, endpoint, unwrapSlugMatch(ct.Name, slug))
 }
 
-func (s *StrapiService) GetSingle(contentType string, id string, query url.Values) ([]byte, bool, error) {
-	cacheKey := fmt.Sprintf("single:%s:%s:%s", contentType, id, query.Encode())
+// unwrapSlugMatch turns a filtered collection response into a single-entry
+// response
+func unwrapSlugMatch(contentType, slug string) func([]byte) ([]byte, error) {
+	return func(body []byte) ([]byte, error) {
+		var collection struct {
+			Data []json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(body, &collection); err != nil {
+			return nil, fmt.Errorf("%w: decoding slug lookup: %w", ErrUpstreamUnavailable, err)
+		}
 
-	// Check cache first
-	if cached, found := s.cache.Get(cacheKey); found {
-		log.Debug().Str("type", contentType).Str("id", id).Msg("Cache hit")
-		return cached, true, nil
+		switch len(collection.Data) {
+		case 0:
+			return nil, ErrNotFound
+		case 1:
+			return json.Marshal(map[string]interface{}{
+				"data": collection.Data[0],
+				"meta": struct{}{},
+			})
+		default:
+			log.Warn().Str("type", contentType).Str("slug", slug).Int("matches", len(collection.Data)).Msg("Duplicate slug in Strapi")
+			return nil, ErrDuplicateSlug
+		}
 	}
+}
 
-	// Fetch from Strapi
-	endpoint := fmt.Sprintf("%s/api/%s/%s", s.baseURL, contentType, id)
-	if len(query) > 0 {
-		endpoint = fmt.Sprintf("%s?%s", endpoint, query.Encode())
+// cacheMetricType is the content_type label for contentType. Unregistered
+// types come straight from the URL, so they share one label rather than
+// creating a series each.
+func cacheMetricType(contentType string) string {
+	if _, known := LookupContentType(contentType); known {
+		return contentType
+	}
+	return "other"
+}
+
+// cachedFetch serves endpoint from the cache when possible. Stale entries are
+// returned immediately while a single background refresh updates them.
+// unwrap, if set, transforms the upstream response before it is cached.
+func (s *StrapiService) cachedFetch(ctx context.Context, contentType, cacheKey, endpoint string, unwrap func([]byte) ([]byte, error)) ([]byte, bool, error) {
+	cached, state := s.cache.Lookup(ctx, cacheKey)
+	metrics.CacheResults.WithLabelValues(cacheMetricType(contentType), state).Inc()
+
+	// An empty entry records a recent not-found; it expires on its own
+	if state != metrics.CacheMiss && len(cached) == 0 {
+		return nil, true, ErrNotFound
 	}
 
-	data, err := s.fetch(endpoint, false)
+	switch state {
+	case metrics.CacheHit:
+		log.Debug().Str("key", cacheKey).Msg("Cache hit")
+		return cached, true, nil
+	case metrics.CacheStale:
+		log.Debug().Str("key", cacheKey).Msg("Cache stale, refreshing")
+		go s.refresh(context.WithoutCancel(ctx), cacheKey, endpoint, unwrap)
+		return cached, true, nil
+	}
+
+	// Cache the result even if the client has gone away meanwhile; the
+	// upstream work is already done
+	data, err := s.load(ctx, context.WithoutCancel(ctx), cacheKey, endpoint, unwrap)
 	if err != nil {
 		return nil, false, err
 	}
 
-	// Cache the response
-	s.cache.SetRaw(cacheKey, data)
-
 	return data, false, nil
 }
 
-func (s *StrapiService) GetPreview(contentType string, id string) ([]byte, error) {
-	// Previews are never cached
-	endpoint := fmt.Sprintf("%s/api/%s/%s?publicationState=preview", s.baseURL, contentType, id)
-	return s.fetch(endpoint, true)
+func (s *StrapiService) refresh(ctx context.Context, cacheKey, endpoint string, unwrap func([]byte) ([]byte, error)) {
+	if _, inFlight := s.refreshing.LoadOrStore(cacheKey, struct{}{}); inFlight {
+		return
+	}
+	defer s.refreshing.Delete(cacheKey)
+
+	if _, err := s.load(ctx, ctx, cacheKey, endpoint, unwrap); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Warn().Err(err).Str("key", cacheKey).Msg("Background cache refresh failed")
+	}
 }
 
-func (s *StrapiService) GetPageBySlug(slug string) ([]byte, bool, error) {
-	cacheKey := fmt.Sprintf("page:%s", slug)
+// load fetches endpoint and caches the result under cacheKey using cacheCtx.
+// Not-found results are cached as an empty entry for negativeTTL.
+func (s *StrapiService) load(ctx, cacheCtx context.Context, cacheKey, endpoint string, unwrap func([]byte) ([]byte, error)) ([]byte, error) {
+	data, err := s.fetch(ctx, endpoint, false)
+	if err == nil && unwrap != nil {
+		data, err = unwrap(data)
+	}
 
-	// Check cache first
-	if cached, found := s.cache.Get(cacheKey); found {
-		log.Debug().Str("slug", slug).Msg("Cache hit")
-		return cached, true, nil
+	if errors.Is(err, ErrNotFound) && s.negativeTTL > 0 {
+		s.cache.SetRawTTL(cacheCtx, cacheKey, []byte{}, s.negativeTTL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.SetRaw(cacheCtx, cacheKey, data)
+	return data, nil
+}
+
+// fetch GETs endpoint through the circuit breaker, retrying connection
+// errors and 502/503/504 responses with jittered exponential backoff. Errors
+// match one of the Err* kinds in errors.go, or context.Canceled.
+func (s *StrapiService) fetch(ctx context.Context, endpoint string, isPreview bool) ([]byte, error) {
+	data, err := s.fetchWithRetry(ctx, endpoint, isPreview)
+	return data, classifyTransportError(err)
+}
+
+func (s *StrapiService) fetchWithRetry(ctx context.Context, endpoint string, isPreview bool) (_ []byte, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "StrapiService.fetch",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", http.MethodGet),
+			attribute.String("url.full", endpoint),
+			attribute.Bool("strapi.preview", isPreview),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	// The overall budget covers every attempt and the backoff between them
+	if s.requestBudget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.requestBudget)
+		defer cancel()
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := s.breaker.Allow(); err != nil {
+			return nil, err
+		}
+
+		data, err := s.fetchOnce(ctx, endpoint)
+		s.recordOutcome(err)
+
+		if err == nil || !isRetryable(ctx, err) || attempt >= s.maxRetries {
+			span.SetAttributes(attribute.Int("strapi.attempts", attempt+1))
+			return data, err
+		}
+
+		delay := backoff(attempt, s.retryBaseDelay, s.retryMaxDelay)
+		log.Debug().Err(err).Str("endpoint", endpoint).Dur("delay", delay).Msg("Retrying Strapi request")
+		metrics.StrapiRetries.Inc()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *StrapiService) fetchOnce(ctx context.Context, endpoint string) ([]byte, error) {
+	return s.send(ctx, http.MethodGet, endpoint, nil, "", s.attemptTimeout)
+}
+
+// ListEntries GETs contentType with query, bypassing the cache. It is meant
+// for entries the gateway writes itself, whose reads must not be stale.
+func (s *StrapiService) ListEntries(ctx context.Context, contentType string, query url.Values) ([]byte, error) {
+	return s.fetch(ctx, fmt.Sprintf("%s/api/%s?%s", s.baseURL, contentType, query.Encode()), false)
+}
+
+// GetEntry GETs one entry of contentType by document ID, bypassing the cache
+func (s *StrapiService) GetEntry(ctx context.Context, contentType, documentID string) ([]byte, error) {
+	return s.fetch(ctx, fmt.Sprintf("%s/api/%s/%s", s.baseURL, contentType, url.PathEscape(documentID)), false)
+}
+
+// CreateEntry creates an entry of the collection type with API ID
+// contentType from data, which becomes the request's "data" object, and
+// returns Strapi's response. Writes go through the circuit breaker but are
+// never retried, since a timed-out create may still have happened.
+func (s *StrapiService) CreateEntry(ctx context.Context, contentType string, data interface{}) ([]byte, error) {
+	return s.writeJSON(ctx, http.MethodPost, fmt.Sprintf("%s/api/%s", s.baseURL, contentType), data)
+}
+
+// UpdateEntry changes the fields in data on one entry of contentType, like
+// CreateEntry
+func (s *StrapiService) UpdateEntry(ctx context.Context, contentType, documentID string, data interface{}) ([]byte, error) {
+	return s.writeJSON(ctx, http.MethodPut, fmt.Sprintf("%s/api/%s/%s", s.baseURL, contentType, url.PathEscape(documentID)), data)
+}
+
+// UploadedFile is a file stored by Strapi's upload plugin
+type UploadedFile struct {
+	ID         int    `json:"id"`
+	DocumentID string `json:"documentId"`
+	Name       string `json:"name"`
+	Mime       string `json:"mime"`
+	URL        string `json:"url"`
+}
+
+// Upload streams file to Strapi's upload plugin as name without buffering
+// it, within STRAPI_UPLOAD_TIMEOUT rather than the per-attempt timeout. Like
+// CreateEntry, it is never retried. If reading file fails, Upload returns
+// ErrUploadRead wrapping the read error. Neither that nor a failure before
+// file was read to the end, which a slow client may cause, counts against
+// Strapi's circuit breaker.
+func (s *StrapiService) Upload(ctx context.Context, name, contentType string, file io.Reader) (UploadedFile, error) {
+	if s.uploadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.uploadTimeout)
+		defer cancel()
 	}
 
-	// Fetch from Strapi using filters
-	endpoint := fmt.Sprintf("%s/api/pages?filters[slug][$eq]=%s&populate=*", s.baseURL, url.QueryEscape(slug))
+	source := &uploadSource{r: file, ctx: ctx}
+	pr, pw := io.Pipe()
+	// Unblocks the writer if the request ends before the file is sent
+	defer pr.Close()
+
+	form := multipart.NewWriter(pw)
+	go func() {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "files", "filename": name}))
+		header.Set("Content-Type", contentType)
+		part, err := form.CreatePart(header)
+		if err == nil {
+			_, err = io.Copy(part, source)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
 
-	data, err := s.fetch(endpoint, false)
+	resp, err := s.write(ctx, http.MethodPost, s.baseURL+"/api/upload", uploadBody{pr, source}, form.FormDataContentType(), 0)
+	var srcErr *uploadSourceError
+	if errors.As(err, &srcErr) {
+		return UploadedFile{}, fmt.Errorf("%w: %w", ErrUploadRead, srcErr.err)
+	}
 	if err != nil {
-		return nil, false, err
+		return UploadedFile{}, err
+	}
+
+	var files []UploadedFile
+	if err := json.Unmarshal(resp, &files); err != nil || len(files) == 0 {
+		return UploadedFile{}, fmt.Errorf("%w: unexpected upload response", ErrUpstreamUnavailable)
 	}
+	return files[0], nil
+}
 
-	// Cache the response
-	s.cache.SetRaw(cacheKey, data)
+// uploadSource marks errors reading an upload's file, which reach Upload
+// through the HTTP client, and records whether the file was read to the end
+// before ctx, the upload's, was done
+type uploadSource struct {
+	r    io.Reader
+	ctx  context.Context
+	done atomic.Bool
+}
 
-	return data, false, nil
+func (u *uploadSource) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	if err == io.EOF {
+		u.done.Store(u.ctx.Err() == nil)
+	} else if err != nil {
+		err = &uploadSourceError{err}
+	}
+	return n, err
+}
+
+// uploadBody is the request body of an upload, streamed from source
+type uploadBody struct {
+	io.Reader
+	source *uploadSource
+}
+
+// unfinishedUpload reports whether body is an upload whose file was not
+// read to the end
+func unfinishedUpload(body io.Reader) bool {
+	upload, ok := body.(uploadBody)
+	return ok && !upload.source.done.Load()
+}
+
+type uploadSourceError struct {
+	err error
 }
 
-func (s *StrapiService) fetch(endpoint string, isPreview bool) ([]byte, error) {
-	req, err := http.NewRequest("GET", endpoint, nil)
+func (e *uploadSourceError) Error() string { return "reading upload: " + e.err.Error() }
+
+func (e *uploadSourceError) Unwrap() error { return e.err }
+
+// DeleteFile removes a file from Strapi's upload plugin
+func (s *StrapiService) DeleteFile(ctx context.Context, id int) error {
+	_, err := s.write(ctx, http.MethodDelete, fmt.Sprintf("%s/api/upload/files/%d", s.baseURL, id), nil, "", s.attemptTimeout)
+	return err
+}
+
+func (s *StrapiService) writeJSON(ctx context.Context, method, endpoint string, data interface{}) ([]byte, error) {
+	body, err := json.Marshal(map[string]interface{}{"data": data})
 	if err != nil {
 		return nil, err
 	}
+	return s.write(ctx, method, endpoint, bytes.NewReader(body), "application/json", s.attemptTimeout)
+}
+
+// write sends a request that changes data in Strapi through the circuit
+// breaker, without retries, within timeout unless it is 0
+func (s *StrapiService) write(ctx context.Context, method, endpoint string, body io.Reader, contentType string, timeout time.Duration) (_ []byte, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "StrapiService.write",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", method),
+			attribute.String("url.full", endpoint),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	if err := s.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	resp, err := s.send(ctx, method, endpoint, body, contentType, timeout)
+	if errors.Is(err, context.DeadlineExceeded) && unfinishedUpload(body) {
+		// The deadline may have run out waiting for the client to send its
+		// file, which says nothing about Strapi
+		s.breaker.Release()
+	} else {
+		s.recordOutcome(err)
+	}
+	return resp, classifyTransportError(err)
+}
 
-	req.Header.Set("Content-Type", "application/json")
+// recordOutcome reports a call the breaker allowed. A call that ended
+// without an answer from Strapi through no fault of its own, because the
+// caller went away or an upload's file could not be read, only frees its
+// probe slot: it must not close a half-open breaker.
+func (s *StrapiService) recordOutcome(err error) {
+	switch {
+	case isUpstreamFailure(err):
+		s.breaker.Failure()
+	case err == nil || errors.As(err, new(*upstreamStatusError)):
+		s.breaker.Success()
+	default:
+		s.breaker.Release()
+	}
+}
+
+// send makes one request to Strapi within timeout. body, if not nil, is
+// sent with contentType.
+func (s *StrapiService) send(ctx context.Context, method, endpoint string, body io.Reader, contentType string, timeout time.Duration) ([]byte, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
 	if s.token != "" {
 		req.Header.Set("Authorization", "Bearer "+s.token)
 	}
 
-	log.Debug().Str("endpoint", endpoint).Msg("Fetching from Strapi")
+	log.Debug().Str("method", method).Str("endpoint", endpoint).Msg("Fetching from Strapi")
 
+	start := time.Now()
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
+		metrics.StrapiDuration.WithLabelValues("network").Observe(time.Since(start).Seconds())
+		metrics.StrapiErrors.WithLabelValues("network").Inc()
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("strapi returned status %d: %s", resp.StatusCode, string(body))
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	status := strconv.Itoa(resp.StatusCode)
+	metrics.StrapiDuration.WithLabelValues(status).Observe(time.Since(start).Seconds())
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		metrics.StrapiErrors.WithLabelValues(status).Inc()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		log.Debug().Int("status", resp.StatusCode).Str("endpoint", endpoint).Bytes("body", body).Msg("Strapi error response")
+		return nil, newUpstreamStatusError(resp.StatusCode, body)
 	}
 
 	return io.ReadAll(resp.Body)
 }
 
-func (s *StrapiService) IsHealthy() bool {
-	resp, err := s.httpClient.Get(s.baseURL + "/_health")
+// isUpstreamFailure reports whether err indicates an unhealthy upstream, as
+// opposed to a client error such as a 404 or the caller going away
+func isUpstreamFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status >= http.StatusInternalServerError
+	}
+	// The caller's file failed, not Strapi
+	return !errors.As(err, new(*uploadSourceError))
+}
+
+// isRetryable reports whether a GET that failed with err is worth retrying
+// within the remaining budget of ctx
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.status {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// backoff returns a "full jitter" delay for the given retry attempt
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	ceiling := base << attempt
+	if ceiling <= 0 || ceiling > max {
+		ceiling = max
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// BreakerState returns the state of the Strapi circuit breaker
+func (s *StrapiService) BreakerState() BreakerState {
+	return s.breaker.State()
+}
+
+func (s *StrapiService) IsHealthy(ctx context.Context) bool {
+	if s.healthTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.healthTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/_health", nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := s.httpClient.Do(req)
 	if err != nil {
 		return false
 	}
@@ -146,7 +619,66 @@ func (s *StrapiService) IsHealthy() bool {
 	return resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent
 }
 
-// InvalidateCache clears cache for a content type (for webhook integration)
-func (s *StrapiService) InvalidateCache(contentType string) error {
-	return s.cache.DeletePattern(fmt.Sprintf("*:%s:*", contentType))
+// contentCacheKinds are the key prefixes StrapiService caches under, each
+// followed by ":<content type>:"
+var contentCacheKinds = []string{"collection", "single", "slug"}
+
+// ContentCachePatterns returns glob patterns matching every cached content key
+func ContentCachePatterns() []string {
+	patterns := make([]string, len(contentCacheKinds))
+	for i, kind := range contentCacheKinds {
+		patterns[i] = kind + ":*"
+	}
+	return patterns
+}
+
+// InvalidateCache clears every cached collection, single and slug entry of a
+// content type and returns the number of keys removed
+func (s *StrapiService) InvalidateCache(ctx context.Context, contentType string) (int, error) {
+	total := 0
+	for _, kind := range contentCacheKinds {
+		n, err := s.cache.DeletePattern(ctx, fmt.Sprintf("%s:%s:*", kind, escapeGlob(contentType)))
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// InvalidateSlug clears the cached slug lookups of one entry, for every preset
+func (s *StrapiService) InvalidateSlug(ctx context.Context, contentType, slug string) (int, error) {
+	return s.cache.DeletePattern(ctx, fmt.Sprintf("slug:%s:*:%s", escapeGlob(contentType), escapeGlob(slug)))
+}
+
+// InvalidateAll clears every cached content entry, leaving other keys in the
+// Redis database alone
+func (s *StrapiService) InvalidateAll(ctx context.Context) (int, error) {
+	total := 0
+	for _, pattern := range ContentCachePatterns() {
+		n, err := s.cache.DeletePattern(ctx, pattern)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// GlobPrefix returns a SCAN pattern matching keys that start with prefix
+func GlobPrefix(prefix string) string {
+	return escapeGlob(prefix) + "*"
+}
+
+// escapeGlob quotes the characters Redis treats as glob syntax in MATCH
+func escapeGlob(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
 }
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+)
+
+func TestCacheMetricType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        string
+	}{
+		{"locations", "locations"},
+		{"blog-posts", "blog-posts"},
+		{"no-such-type", "other"},
+		{"locations%00", "other"},
+		{"", "other"},
+	}
+
+	for _, tt := range tests {
+		if got := cacheMetricType(tt.contentType); got != tt.want {
+			t.Errorf("cacheMetricType(%q) = %q, want %q", tt.contentType, got, tt.want)
+		}
+	}
+}
+
+// failingReader returns data, then err
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestStrapiUploadSourceError(t *testing.T) {
+	var received []byte
+	strapi := newTestStrapi(t, func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("files")
+		if err != nil {
+			http.Error(w, "bad upload", http.StatusBadRequest)
+			return
+		}
+		received, _ = io.ReadAll(file)
+		w.Write([]byte(`[{"id":7}]`))
+	})
+	errClient := errors.New("client went away")
+
+	tests := []struct {
+		name    string
+		file    io.Reader
+		wantErr error
+	}{
+		{"complete", strings.NewReader("%PDF-1.7"), nil},
+		{"read error", &failingReader{data: []byte("%PDF-1.7"), err: errClient}, errClient},
+		{"read error first", &failingReader{err: errClient}, errClient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = nil
+			file, err := strapi.Upload(context.Background(), "cv.pdf", "application/pdf", tt.file)
+			if tt.wantErr == nil {
+				if err != nil || file.ID != 7 || string(received) != "%PDF-1.7" {
+					t.Errorf("Upload() = %+v, %v, received %q", file, err, received)
+				}
+				return
+			}
+			if !errors.Is(err, ErrUploadRead) || !errors.Is(err, tt.wantErr) || errors.Is(err, ErrUpstreamUnavailable) {
+				t.Errorf("Upload() error = %v, want ErrUploadRead wrapping %v", err, tt.wantErr)
+			}
+			// A client's failed upload says nothing about Strapi
+			if state := strapi.breaker.State(); state != BreakerClosed {
+				t.Errorf("breaker %s after a failed read, want closed", state)
+			}
+		})
+	}
+}
+
+// slowReader returns data after delay, then io.EOF
+type slowReader struct {
+	data  []byte
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestStrapiUploadTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		clientDelay time.Duration
+		strapiDelay time.Duration
+		wantErr     error
+		wantState   BreakerState
+	}{
+		{"slower than the attempt timeout", 150 * time.Millisecond, 0, nil, BreakerClosed},
+		{"slow client", 500 * time.Millisecond, 0, ErrUpstreamTimeout, BreakerClosed},
+		{"slow Strapi", 0, 500 * time.Millisecond, ErrUpstreamTimeout, BreakerOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, _, err := r.FormFile("files"); err != nil {
+					return
+				}
+				time.Sleep(tt.strapiDelay)
+				w.Write([]byte(`[{"id":7}]`))
+			}))
+			defer srv.Close()
+			strapi := NewStrapiService(&config.Config{
+				StrapiURL:                srv.URL,
+				StrapiTimeout:            100 * time.Millisecond,
+				StrapiUploadTimeout:      300 * time.Millisecond,
+				StrapiBreakerOpenTimeout: time.Hour,
+			}, &CacheService{})
+
+			_, err := strapi.Upload(context.Background(), "cv.pdf", "application/pdf", &slowReader{data: []byte("%PDF-1.7"), delay: tt.clientDelay})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Upload() error = %v, want %v", err, tt.wantErr)
+			}
+			if state := strapi.breaker.State(); state != tt.wantState {
+				t.Errorf("breaker %s, want %s", state, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestStrapiRecordOutcome(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantState BreakerState
+	}{
+		{"success", nil, BreakerClosed},
+		{"client error", newUpstreamStatusError(http.StatusNotFound, nil), BreakerClosed},
+		{"server error", newUpstreamStatusError(http.StatusBadGateway, nil), BreakerOpen},
+		{"network error", errors.New("connection refused"), BreakerOpen},
+		{"timeout", context.DeadlineExceeded, BreakerOpen},
+		{"cancelled", context.Canceled, BreakerHalfOpen},
+		{"upload read error", &uploadSourceError{errors.New("client went away")}, BreakerHalfOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A half-open breaker with a single probe slot
+			s := &StrapiService{breaker: NewCircuitBreaker("strapi-test", 1, time.Hour, 1)}
+			s.breaker.Failure()
+			s.breaker.openedAt = time.Now().Add(-2 * time.Hour)
+			if err := s.breaker.Allow(); err != nil {
+				t.Fatalf("Allow() probe = %v", err)
+			}
+
+			s.recordOutcome(tt.err)
+			s.breaker.mu.Lock()
+			state := s.breaker.state
+			s.breaker.mu.Unlock()
+			if state != tt.wantState {
+				t.Errorf("state = %s, want %s", state, tt.wantState)
+			}
+			if tt.wantState == BreakerHalfOpen {
+				if err := s.breaker.Allow(); err != nil {
+					t.Errorf("Allow() after release = %v, want the probe slot freed", err)
+				}
+			}
+		})
+	}
+}
+
+func TestStrapiUnknownContentType(t *testing.T) {
+	var requests int
+	strapi := newTestStrapi(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"data":[]}`))
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		get  func(contentType string) error
+	}{
+		{"collection", func(ct string) error { _, _, err := strapi.GetCollection(ctx, ct, url.Values{}); return err }},
+		{"single", func(ct string) error { _, _, err := strapi.GetSingle(ctx, ct, "abc", url.Values{}); return err }},
+		{"preview", func(ct string) error { _, err := strapi.GetPreview(ctx, ct, "abc"); return err }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, contentType := range []string{"tour-bookings", "leads", "applications", "users", "../admin"} {
+				requests = 0
+				if err := tt.get(contentType); !errors.Is(err, ErrUnknownContentType) || requests != 0 {
+					t.Errorf("%s: error = %v after %d requests, want ErrUnknownContentType before any", contentType, err, requests)
+				}
+			}
+			if err := tt.get("locations"); err != nil {
+				t.Errorf("locations: error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,806 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+	"github.com/clayworks/middleware/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// Tour booking statuses
+const (
+	TourPending   = "pending"
+	TourConfirmed = "confirmed"
+	TourCancelled = "cancelled"
+)
+
+// tourBookingContentType is the Strapi collection storing bookings
+const tourBookingContentType = "tour-bookings"
+
+// tourLockTTL bounds how long a crashed replica can hold a location's
+// booking lock
+const tourLockTTL = 15 * time.Second
+
+// tourLockWork bounds the capacity check and write done under a location's
+// lock, so they end well before the lock can expire
+const tourLockWork = 10 * time.Second
+
+var (
+	// ErrSlotUnavailable means every requested slot is fully booked
+	ErrSlotUnavailable = errors.New("no requested slot is available")
+	// ErrBookingState means a booking's status does not allow the change
+	ErrBookingState = errors.New("booking status does not allow this change")
+)
+
+// tourFields are the contact details of a booking, validated like form
+// fields
+var tourFields = []FormField{
+	{Name: "name", Type: FieldText, Required: true},
+	{Name: "email", Type: FieldEmail, Required: true},
+	{Name: "phone", Type: FieldPhone},
+	{Name: "company", Type: FieldText},
+	{Name: "message", Type: FieldTextarea, MaxLength: 2000},
+}
+
+// TourRequest is a visitor's booking request. Values holds the contact
+// fields along with the spam check fields.
+type TourRequest struct {
+	Location  string
+	Slots     []string
+	Seats     int
+	Values    map[string]string
+	UserAgent string
+	ClientIP  string
+}
+
+// TourBooking is a booked tour, in the shape of the Strapi tour-booking
+// content type
+type TourBooking struct {
+	Location        string      `json:"location"`
+	LocationName    string      `json:"locationName,omitempty"`
+	LocationAddress string      `json:"locationAddress,omitempty"`
+	Slot            time.Time   `json:"slot"`
+	SlotEnd         time.Time   `json:"slotEnd"`
+	PreferredSlots  []time.Time `json:"preferredSlots,omitempty"`
+	Name            string      `json:"name"`
+	Email           string      `json:"email"`
+	Phone           string      `json:"phone,omitempty"`
+	Company         string      `json:"company,omitempty"`
+	Seats           int         `json:"seats,omitempty"`
+	Message         string      `json:"message,omitempty"`
+	Status          string      `json:"status"`
+	PageURL         string      `json:"pageUrl,omitempty"`
+	UserAgent       string      `json:"userAgent,omitempty"`
+	SubmittedAt     time.Time   `json:"submittedAt"`
+	ConfirmedAt     *time.Time  `json:"confirmedAt,omitempty"`
+	CancelledAt     *time.Time  `json:"cancelledAt,omitempty"`
+	CancelReason    string      `json:"cancelReason,omitempty"`
+
+	// DocumentID is assigned by Strapi
+	DocumentID string `json:"-"`
+}
+
+// storedTourBooking is a booking as Strapi returns it
+type storedTourBooking struct {
+	DocumentID string `json:"documentId"`
+	TourBooking
+}
+
+// TourSlot is a bookable slot and how many more bookings it takes
+type TourSlot struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Available int       `json:"available"`
+}
+
+// TourFilter selects bookings for the sales API. Zero values match
+// everything.
+type TourFilter struct {
+	Location string
+	Status   string
+	From     time.Time
+	To       time.Time
+	Page     int
+	PageSize int
+}
+
+// TourService books location tours against opening hours and per-slot
+// capacity, stores bookings in Strapi and emails calendar invites once the
+// sales team confirms them
+type TourService struct {
+	strapi *StrapiService
+	cache  *CacheService
+	mailer *Mailer
+	spam   spamChecker
+	fields []FormField
+
+	timezone   *time.Location
+	defaults   TourSchedule
+	schedules  map[string]TourSchedule
+	minNotice  time.Duration
+	maxAdvance time.Duration
+	maxSlots   int
+
+	// pendingHold is how long an unconfirmed booking holds its slot
+	pendingHold time.Duration
+
+	salesEmails []string
+	jobs        *backgroundJobs
+
+	// locks serialises bookings per location within this replica; the
+	// Redis lock covers the others
+	locks sync.Map
+}
+
+func NewTourService(cfg *config.Config, strapi *StrapiService, cache *CacheService, mailer *Mailer) (*TourService, error) {
+	timezone, err := time.LoadLocation(cfg.ToursTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("TOURS_TIMEZONE: %w", err)
+	}
+	hours, err := ParseOpeningHours(cfg.ToursOpeningHours)
+	if err != nil {
+		return nil, fmt.Errorf("TOURS_OPENING_HOURS: %w", err)
+	}
+
+	defaults := TourSchedule{
+		Hours:        hours,
+		SlotDuration: cfg.ToursSlotDuration,
+		Capacity:     cfg.ToursSlotCapacity,
+	}
+	if err := defaults.validate(); err != nil {
+		return nil, err
+	}
+	schedules, err := LoadTourSchedules(cfg.ToursScheduleFile, defaults)
+	if err != nil {
+		return nil, fmt.Errorf("TOURS_SCHEDULE_FILE: %w", err)
+	}
+
+	fields := append([]FormField(nil), tourFields...)
+	for i := range fields {
+		if err := fields[i].compile(cfg.FormsHoneypotField); err != nil {
+			return nil, err
+		}
+	}
+
+	salesEmails := cfg.ToursNotifyEmails
+	if len(salesEmails) == 0 {
+		salesEmails = cfg.FormsNotifyEmails
+	}
+
+	return &TourService{
+		strapi:      strapi,
+		cache:       cache,
+		mailer:      mailer,
+		spam:        newSpamChecker(cfg),
+		fields:      fields,
+		timezone:    timezone,
+		defaults:    defaults,
+		schedules:   schedules,
+		minNotice:   cfg.ToursMinNotice,
+		maxAdvance:  cfg.ToursMaxAdvance,
+		maxSlots:    cfg.ToursMaxPreferredSlots,
+		pendingHold: cfg.ToursPendingHold,
+		salesEmails: salesEmails,
+		jobs:        newBackgroundJobs("tour emails", cfg.FormsNotifyTimeout),
+	}, nil
+}
+
+// Timezone is the zone opening hours are given in
+func (s *TourService) Timezone() *time.Location {
+	return s.timezone
+}
+
+func (s *TourService) schedule(location string) TourSchedule {
+	if schedule, ok := s.schedules[location]; ok {
+		return schedule
+	}
+	return s.defaults
+}
+
+// Book stores a pending booking for the first requested slot with capacity
+// left and acknowledges it by email. The calendar invite waits for Confirm,
+// so a booking cannot make the gateway mail invites to arbitrary addresses.
+// It returns ErrSpam, a
+// *FormValidationError, ErrSlotUnavailable or a StrapiService error.
+func (s *TourService) Book(ctx context.Context, req TourRequest) (TourBooking, error) {
+	if reason := s.spam.reason(req.Values); reason != "" {
+		metrics.TourBookings.WithLabelValues("spam").Inc()
+		log.Info().Str("reason", reason).Str("ip", req.ClientIP).Msg("Dropped spam tour booking")
+		return TourBooking{}, ErrSpam
+	}
+
+	booking, slots, err := s.validate(req)
+	if err == nil {
+		err = s.resolveLocation(ctx, &booking)
+	}
+	if err != nil {
+		var invalid *FormValidationError
+		if errors.As(err, &invalid) {
+			metrics.TourBookings.WithLabelValues("invalid").Inc()
+		} else {
+			metrics.TourBookings.WithLabelValues("failed").Inc()
+		}
+		return TourBooking{}, err
+	}
+
+	lockCtx, lock, err := s.lock(ctx, booking.Location)
+	if err != nil {
+		metrics.TourBookings.WithLabelValues("failed").Inc()
+		return TourBooking{}, err
+	}
+	defer lock.unlock()
+
+	// One query counts the bookings of every requested slot
+	schedule := s.schedule(booking.Location)
+	from, to := slots[0], slots[0]
+	for _, slot := range slots[1:] {
+		if slot.Before(from) {
+			from = slot
+		}
+		if slot.After(to) {
+			to = slot
+		}
+	}
+	taken, err := s.takenSlots(lockCtx, booking.Location, from, to.Add(schedule.SlotDuration), time.Now())
+	if err != nil {
+		metrics.TourBookings.WithLabelValues("failed").Inc()
+		return TourBooking{}, err
+	}
+	for _, slot := range slots {
+		if taken[slot.Unix()] < schedule.Capacity {
+			booking.Slot = slot
+			booking.SlotEnd = slot.Add(schedule.SlotDuration)
+			break
+		}
+	}
+	if booking.Slot.IsZero() {
+		metrics.TourBookings.WithLabelValues("unavailable").Inc()
+		return TourBooking{}, ErrSlotUnavailable
+	}
+
+	if err := lock.held(lockCtx); err != nil {
+		metrics.TourBookings.WithLabelValues("failed").Inc()
+		return TourBooking{}, err
+	}
+	resp, err := s.strapi.CreateEntry(lockCtx, tourBookingContentType, booking)
+	if err != nil {
+		metrics.TourBookings.WithLabelValues("failed").Inc()
+		return TourBooking{}, err
+	}
+
+	var created struct {
+		Data storedTourBooking `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &created); err == nil {
+		booking.DocumentID = created.Data.DocumentID
+	}
+
+	metrics.TourBookings.WithLabelValues("booked").Inc()
+	log.Info().
+		Str("booking", booking.DocumentID).
+		Str("location", booking.Location).
+		Time("slot", booking.Slot).
+		Msg("Tour booked")
+
+	s.email(tourEvent{Booking: booking}, "requested")
+	return booking, nil
+}
+
+// validate checks the request and returns the booking without a slot, and
+// the requested slots in order of preference
+func (s *TourService) validate(req TourRequest) (TourBooking, []time.Time, error) {
+	now := time.Now()
+	booking := TourBooking{
+		Location:    strings.TrimSpace(req.Location),
+		Seats:       req.Seats,
+		Status:      TourPending,
+		PageURL:     truncate(req.Values[FormPageField], 2048),
+		UserAgent:   truncate(req.UserAgent, 512),
+		SubmittedAt: now.UTC(),
+	}
+
+	var invalid []FieldError
+	fail := func(field, message string) {
+		invalid = append(invalid, FieldError{Field: field, Message: message})
+	}
+
+	values := make(map[string]string, len(s.fields))
+	for _, field := range s.fields {
+		value, err := field.normalize(req.Values[field.Name])
+		if err != nil {
+			fail(field.Name, err.Error())
+			continue
+		}
+		values[field.Name] = value
+	}
+	booking.Name = values["name"]
+	booking.Email = values["email"]
+	booking.Phone = values["phone"]
+	booking.Company = values["company"]
+	booking.Message = values["message"]
+
+	if booking.Location == "" || len(booking.Location) > 200 {
+		fail("location", "is required")
+	}
+	if req.Seats < 0 || req.Seats > 10000 {
+		fail("seats", "must be between 0 and 10000")
+	}
+
+	switch {
+	case len(req.Slots) == 0:
+		fail("slots", "is required")
+	case len(req.Slots) > s.maxSlots:
+		fail("slots", fmt.Sprintf("must list at most %d slots", s.maxSlots))
+	}
+
+	schedule := s.schedule(booking.Location)
+	var slots []time.Time
+	for i, raw := range req.Slots {
+		if i >= s.maxSlots {
+			break
+		}
+		field := fmt.Sprintf("slots[%d]", i)
+
+		slot, err := time.Parse(time.RFC3339, raw)
+		switch {
+		case err != nil:
+			fail(field, "must be an RFC 3339 time")
+			continue
+		case !schedule.IsSlot(slot, s.timezone):
+			fail(field, "is not a bookable slot")
+			continue
+		case slot.Before(now.Add(s.minNotice)):
+			fail(field, fmt.Sprintf("must be at least %s from now", s.minNotice))
+			continue
+		case s.maxAdvance > 0 && slot.After(now.Add(s.maxAdvance)):
+			fail(field, fmt.Sprintf("must be within %s from now", s.maxAdvance))
+			continue
+		}
+
+		slot = slot.UTC()
+		if !containsTime(slots, slot) {
+			slots = append(slots, slot)
+		}
+	}
+	booking.PreferredSlots = slots
+
+	if len(invalid) > 0 {
+		return TourBooking{}, nil, &FormValidationError{Fields: invalid}
+	}
+	return booking, slots, nil
+}
+
+func containsTime(times []time.Time, t time.Time) bool {
+	for _, existing := range times {
+		if existing.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveLocation copies the location's name and address into booking,
+// reporting unknown slugs as a validation error
+func (s *TourService) resolveLocation(ctx context.Context, booking *TourBooking) error {
+	locations, _ := LookupContentType("locations")
+	data, _, err := s.strapi.GetBySlug(ctx, locations, booking.Location, "")
+	if errors.Is(err, ErrNotFound) {
+		return &FormValidationError{Fields: []FieldError{{Field: "location", Message: "is not a known location"}}}
+	}
+	if err != nil {
+		return err
+	}
+
+	var entry struct {
+		Data struct {
+			Name    string `json:"name"`
+			Title   string `json:"title"`
+			Address string `json:"address"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return fmt.Errorf("%w: decoding location: %w", ErrUpstreamUnavailable, err)
+	}
+
+	booking.LocationName = entry.Data.Name
+	if booking.LocationName == "" {
+		booking.LocationName = entry.Data.Title
+	}
+	booking.LocationAddress = entry.Data.Address
+	return nil
+}
+
+// locationLock is a location's booking lock, see TourService.lock
+type locationLock struct {
+	location string
+	mu       *sync.Mutex
+	// shared is nil when Redis failed and bookings are only serialised
+	// per replica
+	shared *Lock
+	cancel context.CancelFunc
+}
+
+// lock serialises capacity checks and writes for one location across
+// replicas. If Redis fails, bookings are only serialised per replica. The
+// returned context ends after tourLockWork, before the lock expires; work
+// under the lock must use it and call held before writing.
+func (s *TourService) lock(ctx context.Context, location string) (context.Context, *locationLock, error) {
+	value, _ := s.locks.LoadOrStore(location, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+
+	waitCtx, cancel := context.WithTimeout(ctx, tourLockTTL)
+	defer cancel()
+
+	shared, err := s.cache.Lock(waitCtx, "lock:tours:"+location, tourLockTTL)
+	switch {
+	case err == nil:
+	case waitCtx.Err() != nil:
+		mu.Unlock()
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, fmt.Errorf("%w: waiting for booking lock", ErrUpstreamTimeout)
+	default:
+		log.Warn().Err(err).Str("location", location).Msg("Booking lock unavailable, serialising locally")
+	}
+
+	workCtx, cancelWork := context.WithTimeout(ctx, tourLockWork)
+	return workCtx, &locationLock{location: location, mu: mu, shared: shared, cancel: cancelWork}, nil
+}
+
+// held returns an error if the shared lock expired or was taken over, so
+// the caller must not write. If Redis cannot tell, the local lock is
+// trusted as when the lock was taken.
+func (l *locationLock) held(ctx context.Context) error {
+	if l.shared == nil {
+		return nil
+	}
+	ok, err := l.shared.Held(ctx)
+	switch {
+	case err != nil:
+		log.Warn().Err(err).Str("location", l.location).Msg("Booking lock unverifiable, serialising locally")
+		return nil
+	case !ok:
+		return fmt.Errorf("%w: booking lock expired", ErrUpstreamTimeout)
+	}
+	return nil
+}
+
+func (l *locationLock) unlock() {
+	l.cancel()
+	if l.shared != nil {
+		l.shared.Unlock()
+	}
+	l.mu.Unlock()
+}
+
+// activeBookingsQuery selects the bookings of a location starting in
+// [from, to) that take up capacity: confirmed ones, and pending ones
+// submitted within the pending hold as of now
+func (s *TourService) activeBookingsQuery(location string, from, to, now time.Time) url.Values {
+	query := url.Values{}
+	query.Set("filters[location][$eq]", location)
+	query.Set("filters[slot][$gte]", from.UTC().Format(time.RFC3339))
+	query.Set("filters[slot][$lt]", to.UTC().Format(time.RFC3339))
+	if s.pendingHold <= 0 {
+		query.Set("filters[status][$in][0]", TourPending)
+		query.Set("filters[status][$in][1]", TourConfirmed)
+		return query
+	}
+	query.Set("filters[$or][0][status][$eq]", TourConfirmed)
+	query.Set("filters[$or][1][status][$eq]", TourPending)
+	query.Set("filters[$or][1][submittedAt][$gte]", now.Add(-s.pendingHold).UTC().Format(time.RFC3339))
+	return query
+}
+
+// holdExpired reports whether booking is pending and no longer holds its
+// slot
+func (s *TourService) holdExpired(booking TourBooking, now time.Time) bool {
+	return booking.Status == TourPending && s.pendingHold > 0 && booking.SubmittedAt.Before(now.Add(-s.pendingHold))
+}
+
+type strapiPagination struct {
+	Meta struct {
+		Pagination struct {
+			Page      int `json:"page"`
+			PageCount int `json:"pageCount"`
+			Total     int `json:"total"`
+		} `json:"pagination"`
+	} `json:"meta"`
+}
+
+func (s *TourService) countBookings(ctx context.Context, location string, from, to time.Time) (int, error) {
+	query := s.activeBookingsQuery(location, from, to, time.Now())
+	query.Set("fields[0]", "slot")
+	query.Set("pagination[pageSize]", "1")
+
+	data, err := s.strapi.ListEntries(ctx, tourBookingContentType, query)
+	if err != nil {
+		return 0, err
+	}
+	var page strapiPagination
+	if err := json.Unmarshal(data, &page); err != nil {
+		return 0, fmt.Errorf("%w: decoding booking count: %w", ErrUpstreamUnavailable, err)
+	}
+	return page.Meta.Pagination.Total, nil
+}
+
+// Availability lists the slots of location on day that can still be booked,
+// with the capacity left in each. It returns ErrNotFound for unknown
+// locations.
+func (s *TourService) Availability(ctx context.Context, location string, day time.Time) ([]TourSlot, error) {
+	locations, _ := LookupContentType("locations")
+	if _, _, err := s.strapi.GetBySlug(ctx, locations, location, ""); err != nil {
+		return nil, err
+	}
+
+	schedule := s.schedule(location)
+	now := time.Now()
+
+	var slots []time.Time
+	for _, slot := range schedule.Slots(day, s.timezone) {
+		if slot.Before(now.Add(s.minNotice)) || (s.maxAdvance > 0 && slot.After(now.Add(s.maxAdvance))) {
+			continue
+		}
+		slots = append(slots, slot)
+	}
+	if len(slots) == 0 {
+		return []TourSlot{}, nil
+	}
+
+	taken, err := s.takenSlots(ctx, location, slots[0], slots[len(slots)-1].Add(schedule.SlotDuration), now)
+	if err != nil {
+		return nil, err
+	}
+
+	available := make([]TourSlot, len(slots))
+	for i, slot := range slots {
+		available[i] = TourSlot{
+			Start:     slot.UTC(),
+			End:       slot.Add(schedule.SlotDuration).UTC(),
+			Available: max(schedule.Capacity-taken[slot.Unix()], 0),
+		}
+	}
+	return available, nil
+}
+
+// takenSlots counts the bookings of location taking up capacity in each
+// slot starting in [from, to), keyed by the slot's unix time
+func (s *TourService) takenSlots(ctx context.Context, location string, from, to, now time.Time) (map[int64]int, error) {
+	taken := map[int64]int{}
+	query := s.activeBookingsQuery(location, from, to, now)
+	query.Set("fields[0]", "slot")
+	query.Set("pagination[pageSize]", "100")
+	for page := 1; ; page++ {
+		query.Set("pagination[page]", strconv.Itoa(page))
+		data, err := s.strapi.ListEntries(ctx, tourBookingContentType, query)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Data []struct {
+				Slot time.Time `json:"slot"`
+			} `json:"data"`
+			strapiPagination
+		}
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("%w: decoding bookings: %w", ErrUpstreamUnavailable, err)
+		}
+		for _, booking := range result.Data {
+			taken[booking.Slot.Unix()]++
+		}
+		if page >= result.Meta.Pagination.PageCount {
+			return taken, nil
+		}
+	}
+}
+
+// List returns Strapi's response for the bookings matching filter, soonest
+// first
+func (s *TourService) List(ctx context.Context, filter TourFilter) ([]byte, error) {
+	query := url.Values{}
+	query.Set("sort[0]", "slot:asc")
+	if filter.Location != "" {
+		query.Set("filters[location][$eq]", filter.Location)
+	}
+	if filter.Status != "" {
+		query.Set("filters[status][$eq]", filter.Status)
+	}
+	if !filter.From.IsZero() {
+		query.Set("filters[slot][$gte]", filter.From.UTC().Format(time.RFC3339))
+	}
+	if !filter.To.IsZero() {
+		query.Set("filters[slot][$lt]", filter.To.UTC().Format(time.RFC3339))
+	}
+	if filter.Page > 0 {
+		query.Set("pagination[page]", strconv.Itoa(filter.Page))
+	}
+	if filter.PageSize > 0 {
+		query.Set("pagination[pageSize]", strconv.Itoa(filter.PageSize))
+	}
+	return s.strapi.ListEntries(ctx, tourBookingContentType, query)
+}
+
+// Get returns one booking by document ID
+func (s *TourService) Get(ctx context.Context, id string) (TourBooking, error) {
+	data, err := s.strapi.GetEntry(ctx, tourBookingContentType, id)
+	if err != nil {
+		return TourBooking{}, err
+	}
+
+	var entry struct {
+		Data storedTourBooking `json:"data"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return TourBooking{}, fmt.Errorf("%w: decoding booking: %w", ErrUpstreamUnavailable, err)
+	}
+	booking := entry.Data.TourBooking
+	booking.DocumentID = entry.Data.DocumentID
+	return booking, nil
+}
+
+// Confirm confirms a pending booking and emails the visitor the calendar
+// invite. A booking past its pending hold no longer holds its slot, so it
+// is only confirmed if the slot still has room; otherwise Confirm returns
+// ErrSlotUnavailable.
+func (s *TourService) Confirm(ctx context.Context, id string) (TourBooking, error) {
+	booking, err := s.Get(ctx, id)
+	if err != nil {
+		return TourBooking{}, err
+	}
+	if booking.Status != TourPending {
+		return booking, ErrBookingState
+	}
+
+	if s.holdExpired(booking, time.Now()) {
+		lockCtx, lock, err := s.lock(ctx, booking.Location)
+		if err != nil {
+			return TourBooking{}, err
+		}
+		defer lock.unlock()
+
+		taken, err := s.countBookings(lockCtx, booking.Location, booking.Slot, booking.SlotEnd)
+		if err != nil {
+			return TourBooking{}, err
+		}
+		if taken >= s.schedule(booking.Location).Capacity {
+			return booking, ErrSlotUnavailable
+		}
+		if err := lock.held(lockCtx); err != nil {
+			return TourBooking{}, err
+		}
+		ctx = lockCtx
+	}
+
+	now := time.Now().UTC()
+	update := map[string]interface{}{"status": TourConfirmed, "confirmedAt": now}
+	if _, err := s.strapi.UpdateEntry(ctx, tourBookingContentType, id, update); err != nil {
+		return TourBooking{}, err
+	}
+	booking.Status = TourConfirmed
+	booking.ConfirmedAt = &now
+
+	log.Info().Str("booking", id).Msg("Tour confirmed")
+	s.email(tourEvent{Method: icsRequest, Status: "CONFIRMED", Sequence: 0, Booking: booking}, "confirmed")
+	return booking, nil
+}
+
+// Cancel cancels a pending or confirmed booking, freeing its slot, and
+// emails the cancellation, withdrawing the invite of a confirmed booking
+func (s *TourService) Cancel(ctx context.Context, id, reason string) (TourBooking, error) {
+	booking, err := s.Get(ctx, id)
+	if err != nil {
+		return TourBooking{}, err
+	}
+	if booking.Status == TourCancelled {
+		return booking, ErrBookingState
+	}
+
+	event := tourEvent{Method: icsCancel, Status: "CANCELLED", Sequence: 1}
+	if booking.Status == TourPending {
+		// The visitor never got an invite to withdraw
+		event = tourEvent{}
+	}
+
+	now := time.Now().UTC()
+	reason = truncate(strings.TrimSpace(reason), 1000)
+	update := map[string]interface{}{"status": TourCancelled, "cancelledAt": now, "cancelReason": reason}
+	if _, err := s.strapi.UpdateEntry(ctx, tourBookingContentType, id, update); err != nil {
+		return TourBooking{}, err
+	}
+	booking.Status = TourCancelled
+	booking.CancelledAt = &now
+	booking.CancelReason = reason
+
+	log.Info().Str("booking", id).Msg("Tour cancelled")
+	event.Booking = booking
+	s.email(event, "cancelled")
+	return booking, nil
+}
+
+// email sends the visitor a message about event in the background, and
+// tells the sales team about new requests
+func (s *TourService) email(event tourEvent, kind string) {
+	if !s.mailer.Enabled() {
+		return
+	}
+
+	s.jobs.Go(func(ctx context.Context) {
+		from, err := s.mailer.From()
+		if err != nil {
+			log.Error().Err(err).Msg("Tour email not sent")
+			return
+		}
+
+		b := event.Booking
+		s.send(ctx, kind, []string{b.Email}, s.visitorMessage(event, kind, from))
+
+		if kind == "requested" && len(s.salesEmails) > 0 {
+			when := b.Slot.In(s.timezone).Format("Mon 2 Jan 2006, 3:04 PM MST")
+			summary := fmt.Sprintf("New tour request for %s on %s.\n\nName: %s\nEmail: %s\nPhone: %s\nCompany: %s\nSeats: %d\nMessage: %s\n\nBooking: %s\n",
+				b.LocationName, when, b.Name, b.Email, b.Phone, b.Company, b.Seats, b.Message, b.DocumentID)
+			subject := "Tour request: " + b.Name + ", " + b.LocationName + ", " + when
+			s.send(ctx, "sales", s.salesEmails, calendarMessage(from, s.salesEmails, subject, summary, "", ""))
+		}
+	})
+}
+
+// visitorMessage renders the visitor's email about event, with the invite
+// unless event has no method
+func (s *TourService) visitorMessage(event tourEvent, kind string, from *mail.Address) []byte {
+	b := event.Booking
+	when := b.Slot.In(s.timezone).Format("Mon 2 Jan 2006, 3:04 PM MST")
+	var invite string
+	if event.Method != "" {
+		invite = tourInvite(event, from, time.Now())
+	}
+
+	var subject, text string
+	switch kind {
+	case "requested":
+		subject = "Your ClayWorks tour request: " + when
+		// The address is not verified yet, so the acknowledgment carries
+		// nothing the requester wrote, not even their name
+		text = fmt.Sprintf("Hi,\n\nThanks for requesting a tour of %s on %s. Our team will confirm it shortly and send you a calendar invite.\n\n%s\n\nIf you did not request this tour, you can ignore this email.\n\nClayWorks\n",
+			b.LocationName, when, b.LocationAddress)
+	case "confirmed":
+		subject = "Tour confirmed: " + b.LocationName + ", " + when
+		text = fmt.Sprintf("Hi %s,\n\nYour tour of %s on %s is confirmed. See you there!\n\n%s\n\nClayWorks\n",
+			b.Name, b.LocationName, when, b.LocationAddress)
+	case "cancelled":
+		subject = "Tour cancelled: " + b.LocationName + ", " + when
+		text = fmt.Sprintf("Hi %s,\n\nYour tour of %s on %s has been cancelled.", b.Name, b.LocationName, when)
+		if b.CancelReason != "" {
+			text += "\n\n" + b.CancelReason
+		}
+		text += "\n\nReply to this email to find another time.\n\nClayWorks\n"
+	}
+
+	return calendarMessage(from, []string{b.Email}, subject, text, event.Method, invite)
+}
+
+func (s *TourService) send(ctx context.Context, kind string, to []string, msg []byte) {
+	if err := s.mailer.Send(ctx, to, msg); err != nil {
+		metrics.TourEmails.WithLabelValues(kind, "error").Inc()
+		log.Error().Err(err).Str("kind", kind).Msg("Tour email failed")
+		return
+	}
+	metrics.TourEmails.WithLabelValues(kind, "success").Inc()
+}
+
+// Close waits for pending emails until ctx expires, then cancels them
+func (s *TourService) Close(ctx context.Context) {
+	s.jobs.Close(ctx)
+}
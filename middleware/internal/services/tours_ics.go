@@ -0,0 +1,137 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// iCalendar methods
+const (
+	icsRequest = "REQUEST"
+	icsCancel  = "CANCEL"
+)
+
+const icsTimeFormat = "20060102T150405Z"
+
+// tourEvent is one revision of a booking's calendar event. Sequence must grow
+// with each revision sent for the same booking.
+type tourEvent struct {
+	Method   string
+	Status   string
+	Sequence int
+	Booking  TourBooking
+}
+
+// tourInvite renders an iCalendar (RFC 5545) invite for the booking, with
+// the sender as organizer and the visitor as attendee
+func tourInvite(event tourEvent, organizer *mail.Address, now time.Time) string {
+	b := event.Booking
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//ClayWorks//Tour Bookings//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:" + event.Method,
+		"BEGIN:VEVENT",
+		"UID:" + b.DocumentID + "@tours.clayworks",
+		"DTSTAMP:" + now.UTC().Format(icsTimeFormat),
+		fmt.Sprintf("SEQUENCE:%d", event.Sequence),
+		"DTSTART:" + b.Slot.UTC().Format(icsTimeFormat),
+		"DTEND:" + b.SlotEnd.UTC().Format(icsTimeFormat),
+		"SUMMARY:" + icsText("ClayWorks tour: "+b.LocationName),
+		"STATUS:" + event.Status,
+		"ORGANIZER;CN=" + icsParam(organizer.Name) + ":mailto:" + organizer.Address,
+		"ATTENDEE;CN=" + icsParam(b.Name) + ";ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION:mailto:" + b.Email,
+	}
+	if b.LocationAddress != "" {
+		lines = append(lines, "LOCATION:"+icsText(b.LocationAddress))
+	}
+	lines = append(lines, "END:VEVENT", "END:VCALENDAR")
+
+	var buf strings.Builder
+	for _, line := range lines {
+		buf.WriteString(icsFold(line))
+	}
+	return buf.String()
+}
+
+// icsText escapes a TEXT value
+func icsText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icsParam quotes a parameter value; parameter values cannot contain quotes
+// or line breaks at all
+func icsParam(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '"' || r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+	return `"` + s + `"`
+}
+
+// icsFold ends line with CRLF, folding it into chunks of at most 75 octets
+// without splitting UTF-8 sequences
+func icsFold(line string) string {
+	var buf strings.Builder
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buf.WriteString(line[:cut])
+		buf.WriteString("\r\n ")
+		line = line[cut:]
+		// The leading space of a continuation line counts
+		limit = 74
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+	return buf.String()
+}
+
+// calendarMessage renders an email with a plain-text part and, unless
+// invite is empty, the invite as a text/calendar alternative, which mail
+// clients show as an event
+func calendarMessage(from *mail.Address, to []string, subject, text, method, invite string) []byte {
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	var header bytes.Buffer
+	fmt.Fprintf(&header, "From: %s\r\n", from.String())
+	fmt.Fprintf(&header, "To: %s\r\n", strings.Join(to, ", "))
+	// Subjects include location names from the CMS; keep them on one line
+	subject = strings.Join(strings.Fields(subject), " ")
+	fmt.Fprintf(&header, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&header, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	header.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&header, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", body.Boundary())
+
+	part, _ := body.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	part.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n")))
+
+	if invite != "" {
+		part, _ = body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {fmt.Sprintf("text/calendar; charset=utf-8; method=%s", method)},
+			"Content-Disposition":       {`attachment; filename="tour.ics"`},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		part.Write([]byte(invite))
+	}
+	body.Close()
+
+	return append(header.Bytes(), buf.Bytes()...)
+}
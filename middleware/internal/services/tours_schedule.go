@@ -0,0 +1,204 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// openingWindow is a span of a day in minutes since midnight
+type openingWindow struct {
+	start, end int
+}
+
+// OpeningHours lists the windows a location takes tours in, per weekday
+type OpeningHours map[time.Weekday][]openingWindow
+
+// ParseOpeningHours reads comma-separated entries of the form
+// "mon-fri 10:00-13:00" or "sat 10:00-13:00". A day may appear in several
+// entries, e.g. to leave out a lunch break.
+func ParseOpeningHours(spec string) (OpeningHours, error) {
+	hours := OpeningHours{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		days, span, ok := strings.Cut(entry, " ")
+		if !ok {
+			return nil, fmt.Errorf("opening hours %q: expected \"<days> <HH:MM>-<HH:MM>\"", entry)
+		}
+
+		first, last, isRange := strings.Cut(strings.ToLower(days), "-")
+		if !isRange {
+			last = first
+		}
+		from, ok1 := weekdays[first]
+		to, ok2 := weekdays[last]
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("opening hours %q: unknown day in %q", entry, days)
+		}
+
+		window, err := parseOpeningWindow(strings.TrimSpace(span))
+		if err != nil {
+			return nil, fmt.Errorf("opening hours %q: %w", entry, err)
+		}
+
+		// Ranges may wrap around the week, e.g. "sat-mon"
+		for day := from; ; day = (day + 1) % 7 {
+			hours[day] = append(hours[day], window)
+			if day == to {
+				break
+			}
+		}
+	}
+	return hours, nil
+}
+
+func parseOpeningWindow(span string) (openingWindow, error) {
+	start, end, ok := strings.Cut(span, "-")
+	if !ok {
+		return openingWindow{}, fmt.Errorf("expected HH:MM-HH:MM, got %q", span)
+	}
+	from, err := parseClock(start)
+	if err != nil {
+		return openingWindow{}, err
+	}
+	to, err := parseClock(end)
+	if err != nil {
+		return openingWindow{}, err
+	}
+	if to <= from {
+		return openingWindow{}, fmt.Errorf("window %q ends before it starts", span)
+	}
+	return openingWindow{start: from, end: to}, nil
+}
+
+// parseClock returns the minutes since midnight for HH:MM, allowing 24:00
+func parseClock(clock string) (int, error) {
+	hh, mm, ok := strings.Cut(clock, ":")
+	hour, err1 := strconv.Atoi(hh)
+	minute, err2 := strconv.Atoi(mm)
+	if !ok || err1 != nil || err2 != nil || hour < 0 || minute < 0 || minute > 59 ||
+		hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time %q", clock)
+	}
+	return hour*60 + minute, nil
+}
+
+// TourSchedule is when one location takes tours and how many bookings each
+// slot holds
+type TourSchedule struct {
+	Hours        OpeningHours
+	SlotDuration time.Duration
+	Capacity     int
+}
+
+// tourScheduleOverride is one location's entry in TOURS_SCHEDULE_FILE; unset
+// fields keep the defaults
+type tourScheduleOverride struct {
+	Hours        string `json:"hours"`
+	SlotDuration string `json:"slot_duration"`
+	Capacity     int    `json:"capacity"`
+}
+
+// LoadTourSchedules reads per-location overrides of defaults from a JSON
+// object keyed by location slug. It returns no overrides when file is empty.
+func LoadTourSchedules(file string, defaults TourSchedule) (map[string]TourSchedule, error) {
+	schedules := map[string]TourSchedule{}
+	if file == "" {
+		return schedules, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]tourScheduleOverride
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+
+	for slug, override := range overrides {
+		schedule := defaults
+		if override.Hours != "" {
+			if schedule.Hours, err = ParseOpeningHours(override.Hours); err != nil {
+				return nil, fmt.Errorf("location %s: %w", slug, err)
+			}
+		}
+		if override.SlotDuration != "" {
+			if schedule.SlotDuration, err = time.ParseDuration(override.SlotDuration); err != nil {
+				return nil, fmt.Errorf("location %s: %w", slug, err)
+			}
+		}
+		if override.Capacity > 0 {
+			schedule.Capacity = override.Capacity
+		}
+		if err := schedule.validate(); err != nil {
+			return nil, fmt.Errorf("location %s: %w", slug, err)
+		}
+		schedules[slug] = schedule
+	}
+	return schedules, nil
+}
+
+func (s TourSchedule) validate() error {
+	if s.SlotDuration < time.Minute || s.SlotDuration%time.Minute != 0 {
+		return fmt.Errorf("slot duration %s must be a whole number of minutes", s.SlotDuration)
+	}
+	if s.Capacity <= 0 {
+		return fmt.Errorf("slot capacity must be positive")
+	}
+	return nil
+}
+
+// IsSlot reports whether a tour may start at start: on the slot grid of an
+// opening window, measured from the window's start, and ending within it
+func (s TourSchedule) IsSlot(start time.Time, loc *time.Location) bool {
+	local := start.In(loc)
+	if local.Second() != 0 || local.Nanosecond() != 0 {
+		return false
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	length := int(s.SlotDuration / time.Minute)
+	for _, window := range s.Hours[local.Weekday()] {
+		if minute >= window.start && minute+length <= window.end && (minute-window.start)%length == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Slots returns the start of every slot on the given day in loc, in order
+func (s TourSchedule) Slots(day time.Time, loc *time.Location) []time.Time {
+	year, month, date := day.In(loc).Date()
+	midnight := time.Date(year, month, date, 0, 0, 0, 0, loc)
+	length := int(s.SlotDuration / time.Minute)
+
+	var slots []time.Time
+	for _, window := range s.Hours[midnight.Weekday()] {
+		for minute := window.start; minute+length <= window.end; minute += length {
+			slots = append(slots, time.Date(year, month, date, 0, minute, 0, 0, loc))
+		}
+	}
+
+	// Windows may be listed in any order
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Before(slots[j]) })
+	return slots
+}
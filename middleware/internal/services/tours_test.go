@@ -0,0 +1,315 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+)
+
+// fakeTourStrapi serves one location and the tour-booking collection,
+// applying the capacity filters of activeBookingsQuery
+type fakeTourStrapi struct {
+	mu       sync.Mutex
+	bookings map[string]TourBooking
+	created  int
+	lists    int
+}
+
+func (f *fakeTourStrapi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/api/locations":
+		w.Write([]byte(`{"data":[{"name":"Indiranagar","address":"100 Feet Road"}]}`))
+	case r.URL.Path == "/api/tour-bookings" && r.Method == http.MethodGet:
+		f.lists++
+		active := f.active(r.URL.Query())
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": active,
+			"meta": map[string]interface{}{"pagination": map[string]int{"page": 1, "pageCount": 1, "total": len(active)}},
+		})
+	case r.URL.Path == "/api/tour-bookings" && r.Method == http.MethodPost:
+		var body struct {
+			Data TourBooking `json:"data"`
+		}
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		f.created++
+		id := fmt.Sprintf("new%d", f.created)
+		f.bookings[id] = body.Data
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"documentId": id}})
+	case strings.HasPrefix(r.URL.Path, "/api/tour-bookings/"):
+		id := strings.TrimPrefix(r.URL.Path, "/api/tour-bookings/")
+		booking, ok := f.bookings[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodPut {
+			var body struct {
+				Data struct {
+					Status string `json:"status"`
+				} `json:"data"`
+			}
+			data, _ := io.ReadAll(r.Body)
+			json.Unmarshal(data, &body)
+			booking.Status = body.Data.Status
+			f.bookings[id] = booking
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": storedTourBooking{DocumentID: id, TourBooking: booking}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeTourStrapi) active(query url.Values) []map[string]time.Time {
+	from, _ := time.Parse(time.RFC3339, query.Get("filters[slot][$gte]"))
+	to, _ := time.Parse(time.RFC3339, query.Get("filters[slot][$lt]"))
+	cutoff, held := time.Time{}, query.Has("filters[$or][1][submittedAt][$gte]")
+	if held {
+		cutoff, _ = time.Parse(time.RFC3339, query.Get("filters[$or][1][submittedAt][$gte]"))
+	}
+
+	active := []map[string]time.Time{}
+	for _, b := range f.bookings {
+		if b.Location != query.Get("filters[location][$eq]") || b.Slot.Before(from) || !b.Slot.Before(to) {
+			continue
+		}
+		if b.Status == TourConfirmed || (b.Status == TourPending && (!held || !b.SubmittedAt.Before(cutoff))) {
+			active = append(active, map[string]time.Time{"slot": b.Slot})
+		}
+	}
+	return active
+}
+
+func newTestTours(t *testing.T, bookings map[string]TourBooking) (*TourService, *fakeTourStrapi) {
+	t.Helper()
+	fake := &fakeTourStrapi{bookings: bookings}
+	cfg := &config.Config{
+		ToursTimezone:          "UTC",
+		ToursOpeningHours:      "sun-sat 09:00-18:00",
+		ToursSlotDuration:      time.Hour,
+		ToursSlotCapacity:      1,
+		ToursMaxPreferredSlots: 3,
+		ToursPendingHold:       48 * time.Hour,
+	}
+	tours, err := NewTourService(cfg, newTestStrapi(t, fake.ServeHTTP), &CacheService{}, NewMailer(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tours, fake
+}
+
+func TestTourServiceBook(t *testing.T) {
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 2)
+	nine, ten, eleven := day.Add(9*time.Hour), day.Add(10*time.Hour), day.Add(11*time.Hour)
+	booked := func(slot time.Time, status string, age time.Duration) TourBooking {
+		return TourBooking{Location: "indiranagar", Slot: slot, Status: status, SubmittedAt: time.Now().Add(-age)}
+	}
+
+	tests := []struct {
+		name     string
+		existing map[string]TourBooking
+		want     time.Time
+		wantErr  error
+	}{
+		{"free", nil, ten, nil},
+		{"first choice pending", map[string]TourBooking{"a": booked(ten, TourPending, time.Hour)}, eleven, nil},
+		{"first choice confirmed", map[string]TourBooking{"a": booked(ten, TourConfirmed, 100*time.Hour)}, eleven, nil},
+		{"first choice hold expired", map[string]TourBooking{"a": booked(ten, TourPending, 49*time.Hour)}, ten, nil},
+		{"first choice cancelled", map[string]TourBooking{"a": booked(ten, TourCancelled, time.Hour)}, ten, nil},
+		{"other location", map[string]TourBooking{"a": {Location: "koramangala", Slot: ten, Status: TourConfirmed}}, ten, nil},
+		{"last choice, earliest slot", map[string]TourBooking{
+			"a": booked(ten, TourConfirmed, time.Hour),
+			"b": booked(eleven, TourPending, time.Hour),
+		}, nine, nil},
+		{"all full", map[string]TourBooking{
+			"a": booked(ten, TourConfirmed, time.Hour),
+			"b": booked(eleven, TourPending, time.Hour),
+			"c": booked(nine, TourConfirmed, time.Hour),
+		}, time.Time{}, ErrSlotUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := map[string]TourBooking{}
+			for id, b := range tt.existing {
+				existing[id] = b
+			}
+			tours, fake := newTestTours(t, existing)
+
+			booking, err := tours.Book(context.Background(), TourRequest{
+				Location: "indiranagar",
+				Slots:    []string{ten.Format(time.RFC3339), eleven.Format(time.RFC3339), nine.Format(time.RFC3339)},
+				Values:   map[string]string{"name": "Asha Rao", "email": "asha@example.com", FormTokenField: tours.spam.issue(time.Now())},
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Book() error = %v, want %v", err, tt.wantErr)
+			}
+			if !booking.Slot.Equal(tt.want) {
+				t.Errorf("Book() slot = %s, want %s", booking.Slot, tt.want)
+			}
+			if fake.lists != 1 {
+				t.Errorf("Book() listed bookings %d times, want one query for every slot", fake.lists)
+			}
+			if tt.wantErr != nil {
+				if fake.created != 0 {
+					t.Errorf("created %d bookings, want none", fake.created)
+				}
+				return
+			}
+			if fake.created != 1 || booking.Status != TourPending || booking.LocationName != "Indiranagar" {
+				t.Errorf("Book() = %+v, created %d bookings", booking, fake.created)
+			}
+		})
+	}
+}
+
+func TestTourServiceConfirm(t *testing.T) {
+	slot := time.Now().UTC().Truncate(time.Hour).Add(72 * time.Hour)
+	booked := func(status string, age time.Duration) TourBooking {
+		return TourBooking{Location: "indiranagar", Slot: slot, SlotEnd: slot.Add(time.Hour), Status: status, SubmittedAt: time.Now().Add(-age)}
+	}
+
+	tests := []struct {
+		name     string
+		bookings map[string]TourBooking
+		wantErr  error
+	}{
+		{"pending", map[string]TourBooking{"x": booked(TourPending, time.Hour)}, nil},
+		{"hold expired, slot free", map[string]TourBooking{"x": booked(TourPending, 72*time.Hour)}, nil},
+		{"hold expired, slot taken", map[string]TourBooking{
+			"x": booked(TourPending, 72*time.Hour),
+			"y": booked(TourPending, time.Hour),
+		}, ErrSlotUnavailable},
+		{"already confirmed", map[string]TourBooking{"x": booked(TourConfirmed, time.Hour)}, ErrBookingState},
+		{"cancelled", map[string]TourBooking{"x": booked(TourCancelled, time.Hour)}, ErrBookingState},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tours, fake := newTestTours(t, tt.bookings)
+			wasStatus := tt.bookings["x"].Status
+
+			booking, err := tours.Confirm(context.Background(), "x")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Confirm() error = %v, want %v", err, tt.wantErr)
+			}
+			want := wasStatus
+			if tt.wantErr == nil {
+				want = TourConfirmed
+			}
+			if booking.Status != want || fake.bookings["x"].Status != want {
+				t.Errorf("status = %s, stored %s, want %s", booking.Status, fake.bookings["x"].Status, want)
+			}
+		})
+	}
+}
+
+func TestTourVisitorMessage(t *testing.T) {
+	tours, _ := newTestTours(t, nil)
+	from := &mail.Address{Name: "ClayWorks", Address: "tours@clayworks.space"}
+	slot := time.Date(2026, 11, 3, 10, 0, 0, 0, time.UTC)
+	booking := TourBooking{
+		DocumentID:   "abc",
+		LocationName: "Indiranagar",
+		Slot:         slot,
+		SlotEnd:      slot.Add(time.Hour),
+		Name:         "Buy cheap pills at spam.example",
+		Email:        "victim@example.com",
+	}
+
+	tests := []struct {
+		name    string
+		event   tourEvent
+		kind    string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "requested",
+			event:   tourEvent{Booking: booking},
+			kind:    "requested",
+			want:    []string{"To: victim@example.com", "Indiranagar"},
+			notWant: []string{"text/calendar", "BEGIN:VCALENDAR", "spam.example"},
+		},
+		{
+			name:  "confirmed",
+			event: tourEvent{Method: icsRequest, Status: "CONFIRMED", Sequence: 0, Booking: booking},
+			kind:  "confirmed",
+			want:  []string{"method=REQUEST", "METHOD:REQUEST", "STATUS:CONFIRMED", "SEQUENCE:0", "UID:abc@tours.clayworks"},
+		},
+		{
+			name:  "confirmed cancelled",
+			event: tourEvent{Method: icsCancel, Status: "CANCELLED", Sequence: 1, Booking: booking},
+			kind:  "cancelled",
+			want:  []string{"METHOD:CANCEL", "STATUS:CANCELLED", "SEQUENCE:1"},
+		},
+		{
+			name:    "pending cancelled",
+			event:   tourEvent{Booking: booking},
+			kind:    "cancelled",
+			want:    []string{"has been cancelled"},
+			notWant: []string{"text/calendar"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := string(tours.visitorMessage(tt.event, tt.kind, from))
+			for _, s := range tt.want {
+				if !strings.Contains(msg, s) {
+					t.Errorf("message lacks %q", s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(msg, s) {
+					t.Errorf("message contains %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestTourInviteEscaping(t *testing.T) {
+	organizer := &mail.Address{Name: `Clay"Works`, Address: "tours@clayworks.space"}
+	booking := TourBooking{
+		DocumentID:      "abc",
+		LocationName:    "Indiranagar; Bengaluru, India",
+		LocationAddress: "100 Feet Road\nIndiranagar " + strings.Repeat("ಬೆಂಗಳೂರು ", 10),
+		Name:            "Asha \"Ash\" Rao\r\nX-Injected: 1",
+		Email:           "asha@example.com",
+	}
+	invite := tourInvite(tourEvent{Method: icsRequest, Status: "CONFIRMED", Booking: booking}, organizer, time.Now())
+
+	for _, want := range []string{
+		`SUMMARY:ClayWorks tour: Indiranagar\; Bengaluru\, India`,
+		`ORGANIZER;CN="ClayWorks":mailto:tours@clayworks.space`,
+		`ATTENDEE;CN="Asha Ash RaoX-Injected: 1";`,
+	} {
+		if !strings.Contains(invite, want) {
+			t.Errorf("invite lacks %q:\n%s", want, invite)
+		}
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(invite, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %q", line)
+		}
+		if strings.ContainsAny(line, "\r\n") {
+			t.Errorf("bare line break in %q", line)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clayworks/middleware/internal/config"
+	"github.com/rs/zerolog/log"
+)
+
+// warmDebounce collects a burst of invalidations into a single warm run
+const warmDebounce = 2 * time.Second
+
+// CacheWarmer prefetches a list of gateway routes into the content cache on
+// startup and after each invalidation. In crawl mode, slugs found in warmed
+// collection responses are prefetched as by-slug lookups too.
+type CacheWarmer struct {
+	strapi      *StrapiService
+	tasks       []warmTask
+	concurrency int
+	crawl       bool
+	trigger     chan struct{}
+}
+
+// warmTask fetches one route through StrapiService, filling its cache entry
+type warmTask struct {
+	route string
+	fetch func(ctx context.Context) ([]byte, error)
+	// crawl is the slugged content type listed by a collection route
+	crawl *ContentType
+}
+
+func NewCacheWarmer(cfg *config.Config, strapi *StrapiService) *CacheWarmer {
+	w := &CacheWarmer{
+		strapi:      strapi,
+		concurrency: cfg.CacheWarmConcurrency,
+		crawl:       cfg.CacheWarmCrawl,
+		trigger:     make(chan struct{}, 1),
+	}
+	if w.concurrency < 1 {
+		w.concurrency = 1
+	}
+
+	for _, route := range cfg.CacheWarmRoutes {
+		route = strings.TrimSpace(route)
+		if route == "" {
+			continue
+		}
+		task, err := w.parseRoute(route)
+		if err != nil {
+			log.Warn().Err(err).Str("route", route).Msg("Ignoring cache warm route")
+			continue
+		}
+		w.tasks = append(w.tasks, task)
+	}
+
+	return w
+}
+
+// parseRoute maps a gateway path such as /api/v1/content/locations?preset=card
+// to the StrapiService call that serves it
+func (w *CacheWarmer) parseRoute(route string) (warmTask, error) {
+	u, err := url.Parse(route)
+	if err != nil {
+		return warmTask{}, err
+	}
+	query := u.Query()
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(u.Path, "/api/v1/"), "/"), "/")
+	task := warmTask{route: route}
+
+	switch {
+	case parts[0] == "content" && len(parts) == 2:
+		task.fetch = func(ctx context.Context) ([]byte, error) {
+			data, _, err := w.strapi.GetCollection(ctx, parts[1], query)
+			return data, err
+		}
+		if ct, ok := LookupContentType(parts[1]); ok && ct.HasSlug() {
+			task.crawl = &ct
+		}
+	case parts[0] == "content" && len(parts) == 4 && parts[2] == "by-slug":
+		ct, ok := LookupContentType(parts[1])
+		if !ok || !ct.HasSlug() {
+			return warmTask{}, fmt.Errorf("content type %q does not support slug lookups", parts[1])
+		}
+		task.fetch = func(ctx context.Context) ([]byte, error) {
+			data, _, err := w.strapi.GetBySlug(ctx, ct, parts[3], query.Get("preset"))
+			return data, err
+		}
+	case parts[0] == "content" && len(parts) == 3:
+		task.fetch = func(ctx context.Context) ([]byte, error) {
+			data, _, err := w.strapi.GetSingle(ctx, parts[1], parts[2], query)
+			return data, err
+		}
+	case parts[0] == "pages" && len(parts) == 2:
+		task.fetch = func(ctx context.Context) ([]byte, error) {
+			data, _, err := w.strapi.GetPageBySlug(ctx, parts[1], query.Get("preset"))
+			return data, err
+		}
+	default:
+		return warmTask{}, fmt.Errorf("unsupported route %q", u.Path)
+	}
+
+	return task, nil
+}
+
+// Trigger schedules a warm run; calls during a pending run are coalesced
+func (w *CacheWarmer) Trigger() {
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run warms the cache once and then after every Trigger until ctx is done
+func (w *CacheWarmer) Run(ctx context.Context) {
+	if len(w.tasks) == 0 {
+		return
+	}
+	if !w.strapi.cache.Enabled() {
+		log.Info().Msg("Cache disabled, skipping cache warming")
+		return
+	}
+
+	w.Warm(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.trigger:
+		}
+
+		// Let the rest of a publish burst arrive before fetching
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(warmDebounce):
+		}
+		select {
+		case <-w.trigger:
+		default:
+		}
+
+		w.Warm(ctx)
+	}
+}
+
+// Warm fetches every configured route, then, in crawl mode, every slug
+// listed by the warmed collections
+func (w *CacheWarmer) Warm(ctx context.Context) {
+	start := time.Now()
+
+	responses := w.runAll(ctx, w.tasks)
+	warmed, failed := len(w.tasks)-len(responses.failed), len(responses.failed)
+
+	if w.crawl && ctx.Err() == nil {
+		crawled := w.crawlTasks(responses.data)
+		crawlResult := w.runAll(ctx, crawled)
+		warmed += len(crawled) - len(crawlResult.failed)
+		failed += len(crawlResult.failed)
+	}
+
+	log.Info().
+		Int("warmed", warmed).
+		Int("failed", failed).
+		Dur("duration", time.Since(start)).
+		Msg("Cache warmed")
+}
+
+type warmResult struct {
+	data   map[int][]byte
+	failed []string
+}
+
+// runAll runs tasks with at most concurrency in flight and returns the
+// response of each successful task by index
+func (w *CacheWarmer) runAll(ctx context.Context, tasks []warmTask) warmResult {
+	result := warmResult{data: make(map[int][]byte, len(tasks))}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, w.concurrency)
+	)
+
+	for i, task := range tasks {
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			result.failed = append(result.failed, task.route)
+			mu.Unlock()
+			continue
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(i int, task warmTask) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			data, err := task.fetch(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Warn().Err(err).Str("route", task.route).Msg("Cache warm failed")
+				result.failed = append(result.failed, task.route)
+				return
+			}
+			result.data[i] = data
+		}(i, task)
+	}
+
+	wg.Wait()
+	return result
+}
+
+// crawlTasks builds by-slug lookups for the slugs in warmed collection
+// responses, skipping duplicates
+func (w *CacheWarmer) crawlTasks(responses map[int][]byte) []warmTask {
+	seen := make(map[string]bool)
+	var tasks []warmTask
+
+	for i, task := range w.tasks {
+		data, ok := responses[i]
+		if !ok || task.crawl == nil {
+			continue
+		}
+		ct := *task.crawl
+
+		var collection struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(data, &collection); err != nil {
+			continue
+		}
+
+		for _, entry := range collection.Data {
+			slug, _ := entry[ct.SlugField].(string)
+			key := ct.Name + "/" + slug
+			if slug == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			tasks = append(tasks, warmTask{
+				route: "/api/v1/content/" + ct.Name + "/by-slug/" + slug,
+				fetch: func(ctx context.Context) ([]byte, error) {
+					data, _, err := w.strapi.GetBySlug(ctx, ct, slug, "")
+					return data, err
+				},
+			})
+		}
+	}
+
+	return tasks
+}